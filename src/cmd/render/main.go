@@ -0,0 +1,301 @@
+// Command render draws the lightshow of an effect over a whole track into an animated GIF, or into PNG frames with a
+// JSON file of their timings, so effects can be reviewed without lights or a playing track.
+//
+// The track's analysis comes from a file, or from the cache the gateway keeps with SPOTIFY_CACHE_DIR:
+//
+//	go run ./cmd/render -cache tracks -track 4uLU6hMCjMI75M1A2tKUQC -effect rainbow -layout '{"mirror": true}' -o show.gif
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/hardware"
+	"github.com/tom-milner/LightBeatGateway/palettes"
+	"github.com/tom-milner/LightBeatGateway/spotify"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Timing describes the PNG frames written, for tools that play them back.
+type Timing struct {
+	FPS    int          `json:"fps"`
+	Frames []TimedFrame `json:"frames"`
+}
+
+// TimedFrame is a PNG frame, and how far into the track it shows.
+type TimedFrame struct {
+	File string `json:"file"`
+	Time int64  `json:"time_ms"`
+}
+
+func main() {
+	analysisFile := flag.String("analysis", "", "the file holding the track's analysis")
+	featuresFile := flag.String("features", "", "the file holding the track's features, for palette strategies")
+	cacheDir := flag.String("cache", "", "the gateway's SPOTIFY_CACHE_DIR, to read the track from instead of files")
+	track := flag.String("track", "", "the Spotify ID of the track in the cache")
+	effectName := flag.String("effect", hardware.DefaultEffect, fmt.Sprintf("the effect to render, one of %v", hardware.EffectNames()))
+	params := flag.String("params", "", "the effect's params, as JSON")
+	layoutConfig := flag.String("layout", "", "the layout of the pixels, as JSON")
+	pixels := flag.Int("pixels", 0, "how many pixels are on the strip, if the layout doesn't say (default 8)")
+	trigger := flag.String("trigger", string(models.Beat), "the part of the track that triggers the lights, beat or bar")
+	paletteName := flag.String("palette", "", "hex colors separated by commas, a named palette, or a palette strategy (default the mood strategy if there are features, or red)")
+	fps := flag.Int("fps", 25, "how many frames a second to render")
+	size := flag.Int("size", 10, "how many image pixels wide each light is drawn")
+	start := flag.Duration("start", 0, "how far into the track to start rendering")
+	duration := flag.Duration("duration", 0, "how long to render for (default to the end of the track)")
+	output := flag.String("o", "show.gif", "the GIF to write, or the directory to write PNG frames and timing.json to")
+	flag.Parse()
+
+	if *cacheDir != "" && *track != "" {
+		*analysisFile = spotify.AnalysisFile(*cacheDir, *track)
+		if _, err := os.Stat(spotify.FeaturesFile(*cacheDir, *track)); *featuresFile == "" && err == nil {
+			*featuresFile = spotify.FeaturesFile(*cacheDir, *track)
+		}
+	}
+	if *analysisFile == "" {
+		log.Fatal("The track's analysis is required, from -analysis or -cache and -track.")
+	}
+	analysis, err := spotify.LoadAnalysis(*analysisFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var features *models.MediaAudioFeatures
+	if *featuresFile != "" {
+		f, err := spotify.LoadFeatures(*featuresFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		features = &f
+	}
+
+	triggerType := models.TriggerType(*trigger)
+	if !triggerType.Valid() {
+		log.Fatalf("Unknown trigger type %q, expected one of %v.", *trigger, models.TriggerTypes)
+	}
+	showPalette, err := choosePalette(*paletteName, features)
+	if err != nil {
+		log.Fatal(err)
+	}
+	effect, err := hardware.NewEffect(*effectName, json.RawMessage(*params))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var layout hardware.Layout
+	if *layoutConfig != "" {
+		if layout, err = hardware.ParseLayout(*layoutConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+	numPixels := *pixels
+	if numPixels == 0 {
+		numPixels = layout.NumPixels()
+	}
+	if numPixels == 0 {
+		numPixels = 8
+	}
+	canvas, err := layout.Canvas(numPixels)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *fps <= 0 || *size <= 0 {
+		log.Fatal("The fps and size must be more than 0.")
+	}
+
+	show := hardware.Show{Analysis: analysis, Trigger: triggerType, Palette: showPalette}
+	end := show.Duration()
+	if *duration > 0 && *start+*duration < end {
+		end = *start + *duration
+	}
+	if end <= *start {
+		log.Fatal("Nothing to render, the track ends before the start.")
+	}
+
+	var out frameWriter
+	if strings.EqualFold(filepath.Ext(*output), ".gif") {
+		out, err = newGIFWriter(*output, *fps)
+	} else {
+		out, err = newPNGWriter(*output, *fps, *start)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Render every frame of the show, drawn as the lights would be laid out, writing each one as it's rendered.
+	width, height := layout.Size(numPixels)
+	frames := 0
+	for at := *start; at < end; at += time.Second / time.Duration(*fps) {
+		clock := show.ClockAt(at)
+		layers := []hardware.Layer{{Effect: effect, Blend: hardware.Over, Elapsed: at - *start}}
+		pixels, _ := hardware.RenderFrame(canvas, layers, clock)
+		if err := out.WriteFrame(drawLights(pixels, layout, width, height, *size)); err != nil {
+			log.Fatal(err)
+		}
+		frames++
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Rendered %d frames of %s to %s\n", frames, *effectName, *output)
+}
+
+// choosePalette returns the colors of the palette, which is either hex colors separated by commas, a named palette,
+// or a palette strategy.
+func choosePalette(name string, features *models.MediaAudioFeatures) ([]colors.Color, error) {
+	if name == "" {
+		if features == nil {
+			return []colors.Color{colors.MustParse(colors.Red)}, nil
+		}
+		name = palettes.DefaultStrategy
+	}
+	if named, err := colors.Palette(name); err == nil {
+		return named, nil
+	}
+	if strategy, err := palettes.Get(name); err == nil {
+		if features == nil {
+			return nil, fmt.Errorf("palette strategy %s needs the track's features", name)
+		}
+		return strategy.Palette(*features), nil
+	}
+	var chosen []colors.Color
+	for _, code := range strings.Split(name, ",") {
+		c, err := colors.Parse(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("palette must be hex colors, one of the palettes %v, or one of the strategies %v",
+				colors.PaletteNames(), palettes.Names())
+		}
+		chosen = append(chosen, c)
+	}
+	return chosen, nil
+}
+
+// drawLights draws each pixel of the strip as a square of size image pixels, with a gap between the squares.
+func drawLights(pixels hardware.Frame, layout hardware.Layout, width, height, size int) *image.RGBA {
+	gap := size / 8
+	img := image.NewRGBA(image.Rect(0, 0, width*size, height*size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for i, p := range pixels {
+		x, y := layout.Position(i)
+		light := image.Rect(x*size+gap, y*size+gap, (x+1)*size-gap, (y+1)*size-gap)
+		draw.Draw(img, light, image.NewUniform(color.RGBA{p.R, p.G, p.B, 255}), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// frameWriter writes rendered frames out, one at a time.
+type frameWriter interface {
+	WriteFrame(frame *image.RGBA) error
+	Close() error
+}
+
+// gifWriter writes frames to an animated GIF. The whole animation is encoded when it's closed, so each frame is kept
+// as a paletted image, a quarter of the size of the frame.
+type gifWriter struct {
+	file      *os.File
+	fps       int
+	animation gif.GIF
+}
+
+func newGIFWriter(file string, fps int) (*gifWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return &gifWriter{file: f, fps: fps}, nil
+}
+
+// WriteFrame adds the frame to the animation.
+func (w *gifWriter) WriteFrame(frame *image.RGBA) error {
+	paletted := image.NewPaletted(frame.Bounds(), framePalette(frame))
+	draw.Draw(paletted, paletted.Bounds(), frame, image.Point{}, draw.Src)
+
+	// GIF delays are in hundredths of a second, so round each frame's end to keep the animation in time.
+	i := float64(len(w.animation.Image))
+	delay := math.Round((i+1)*100/float64(w.fps)) - math.Round(i*100/float64(w.fps))
+	w.animation.Image = append(w.animation.Image, paletted)
+	w.animation.Delay = append(w.animation.Delay, int(delay))
+	return nil
+}
+
+// Close encodes the animation to the file.
+func (w *gifWriter) Close() error {
+	if err := gif.EncodeAll(w.file, &w.animation); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// framePalette returns the colors in the frame, or a general purpose palette if there are too many for a GIF.
+func framePalette(frame *image.RGBA) color.Palette {
+	seen := map[color.RGBA]bool{}
+	var used color.Palette
+	for i := 0; i < len(frame.Pix); i += 4 {
+		c := color.RGBA{frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3]}
+		if !seen[c] {
+			if len(used) == 256 {
+				return palette.Plan9
+			}
+			seen[c] = true
+			used = append(used, c)
+		}
+	}
+	return used
+}
+
+// pngWriter writes each frame to a PNG in a directory, and timing.json saying when each is shown once it's closed.
+type pngWriter struct {
+	dir    string
+	start  time.Duration
+	timing Timing
+}
+
+func newPNGWriter(dir string, fps int, start time.Duration) (*pngWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &pngWriter{dir: dir, start: start, timing: Timing{FPS: fps}}, nil
+}
+
+// WriteFrame writes the frame to the next PNG.
+func (w *pngWriter) WriteFrame(frame *image.RGBA) error {
+	i := len(w.timing.Frames)
+	name := fmt.Sprintf("frame%05d.png", i)
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, frame); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	at := w.start + time.Duration(i)*time.Second/time.Duration(w.timing.FPS)
+	w.timing.Frames = append(w.timing.Frames, TimedFrame{File: name, Time: at.Milliseconds()})
+	return nil
+}
+
+// Close writes timing.json.
+func (w *pngWriter) Close() error {
+	b, err := json.MarshalIndent(w.timing, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(w.dir, "timing.json"), append(b, '\n'), 0644)
+}
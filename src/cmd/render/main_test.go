@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// solid returns a frame filled with the color.
+func solid(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestPNGWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "frames")
+	w, err := newPNGWriter(dir, 4, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.WriteFrame(solid(color.RGBA{R: uint8(i), A: 255})); err != nil {
+			t.Fatal(err)
+		}
+		// Each frame is written as soon as it's rendered.
+		if _, err := os.Stat(filepath.Join(dir, w.timing.Frames[i].File)); err != nil {
+			t.Errorf("frame %d not written: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "timing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var timing Timing
+	if err := json.Unmarshal(b, &timing); err != nil {
+		t.Fatal(err)
+	}
+	if timing.FPS != 4 || len(timing.Frames) != 3 {
+		t.Fatalf("timing = %+v, want 3 frames at 4 fps", timing)
+	}
+	for i, want := range []int64{1000, 1250, 1500} {
+		if timing.Frames[i].Time != want {
+			t.Errorf("frame %d shows at %dms, want %dms", i, timing.Frames[i].Time, want)
+		}
+	}
+}
+
+func TestGIFWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "show.gif")
+	w, err := newGIFWriter(file, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	for _, c := range colors {
+		if err := w.WriteFrame(solid(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	animation, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != len(colors) {
+		t.Fatalf("GIF has %d frames, want %d", len(animation.Image), len(colors))
+	}
+	// 30 fps doesn't divide into hundredths of a second, so the delays alternate to keep in time.
+	for i, want := range []int{3, 4, 3} {
+		if animation.Delay[i] != want {
+			t.Errorf("frame %d delay = %d, want %d", i, animation.Delay[i], want)
+		}
+	}
+	for i, c := range colors {
+		r, g, b, _ := animation.Image[i].At(1, 1).RGBA()
+		if got := (color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}); got != c {
+			t.Errorf("frame %d = %v, want %v", i, got, c)
+		}
+	}
+}
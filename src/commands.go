@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tom-milner/LightBeatGateway/edge"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/hardware"
+	"github.com/tom-milner/LightBeatGateway/output/osc"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// commandHandlers holds the handler of every command the gateway understands, by name.
+var commandHandlers = map[string]edge.CommandHandler{
+	"set-trigger":    setTriggerCommand,
+	"get-status":     getStatusCommand,
+	"set-brightness": setBrightnessCommand,
+	"set-palette":    setPaletteCommand,
+	"pause-lights":   pauseLightsCommand,
+	"set-effect":     setEffectCommand,
+}
+
+// registerCommands registers the handlers of all the commands the gateway understands.
+func registerCommands() {
+	for name, handler := range commandHandlers {
+		if err := edge.HandleCommand(name, handler); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// applyTriggerType changes the trigger type, and lets every device know.
+func applyTriggerType(trigger models.TriggerType) {
+	state.SetTriggerType(trigger)
+	edge.SendMessage(topics.TriggerMode, messages.TriggerMode{Trigger: trigger})
+}
+
+func setTriggerCommand(params json.RawMessage) (interface{}, error) {
+	var command messages.SetTrigger
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	applyTriggerType(command.Trigger)
+	return messages.TriggerMode{Trigger: command.Trigger}, nil
+}
+
+func getStatusCommand(params json.RawMessage) (interface{}, error) {
+	var command struct{}
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	return state.Status(), nil
+}
+
+func setBrightnessCommand(params json.RawMessage) (interface{}, error) {
+	var command messages.SetBrightness
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	state.SetBrightness(command.Brightness)
+	hardware.SetBrightness(state.Brightness())
+	return state.Status(), nil
+}
+
+func setPaletteCommand(params json.RawMessage) (interface{}, error) {
+	var command messages.SetPalette
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	switch {
+	case command.Strategy != "":
+		if err := state.SetPaletteStrategy(command.Strategy); err != nil {
+			return nil, err
+		}
+	case command.Name != "":
+		palette, err := colors.Palette(command.Name)
+		if err != nil {
+			return nil, err
+		}
+		state.SetPalette(palette)
+	default:
+		palette := make([]colors.Color, len(command.Colors))
+		for i, code := range command.Colors {
+			// The codes have already been validated.
+			palette[i] = colors.MustParse(code)
+		}
+		state.SetPalette(palette)
+	}
+	return state.Status(), nil
+}
+
+func pauseLightsCommand(params json.RawMessage) (interface{}, error) {
+	var command messages.PauseLights
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	state.SetPaused(command.Paused)
+	hardware.Pause(command.Paused)
+	return state.Status(), nil
+}
+
+func setEffectCommand(params json.RawMessage) (interface{}, error) {
+	var command messages.SetEffect
+	if err := messages.DecodeParams(params, &command); err != nil {
+		return nil, err
+	}
+	if err := hardware.SetEffect(command.Device, command.Effect, command.Params); err != nil {
+		return nil, err
+	}
+	return state.Status(), nil
+}
+
+// handleOSCCommand runs the command named by the address of an OSC message, e.g. /lightbeat/set-trigger "bar".
+func handleOSCCommand(prefix string) func(osc.Message) {
+	return func(msg osc.Message) {
+		name := strings.TrimPrefix(msg.Address, prefix+"/")
+		handler, exists := commandHandlers[name]
+		if !exists {
+			log.Println("Unknown OSC command:", msg.Address)
+			return
+		}
+		params, err := oscParams(name, msg.Arguments)
+		if err == nil {
+			_, err = handler(params)
+		}
+		if err != nil {
+			log.Printf("OSC command %s failed: %v\n", msg.Address, err)
+		}
+	}
+}
+
+// oscParams converts the arguments of an OSC message to the parameters of the command.
+func oscParams(name string, arguments []interface{}) (json.RawMessage, error) {
+	var params interface{}
+	switch name {
+	case "set-trigger":
+		if len(arguments) != 1 {
+			return nil, errors.New("set-trigger takes the trigger type")
+		}
+		params = map[string]interface{}{"trigger": arguments[0]}
+	case "set-brightness":
+		if len(arguments) != 1 {
+			return nil, errors.New("set-brightness takes the brightness")
+		}
+		params = map[string]interface{}{"brightness": arguments[0]}
+	case "set-palette":
+		// A single argument that isn't a color is the name of a palette or a strategy, e.g. /lightbeat/set-palette
+		// "ocean" or /lightbeat/set-palette "mood".
+		params = map[string]interface{}{"colors": arguments}
+		if len(arguments) == 1 {
+			if name, ok := arguments[0].(string); ok && !colors.IsHex(name) {
+				if _, err := colors.Palette(name); err == nil {
+					params = map[string]interface{}{"name": name}
+				} else {
+					params = map[string]interface{}{"strategy": name}
+				}
+			}
+		}
+	case "set-effect":
+		if len(arguments) < 1 || len(arguments) > 2 {
+			return nil, errors.New("set-effect takes the effect, and optionally the device")
+		}
+		effect := map[string]interface{}{"effect": arguments[0]}
+		if len(arguments) == 2 {
+			effect["device"] = arguments[1]
+		}
+		params = effect
+	case "pause-lights":
+		if len(arguments) != 1 {
+			return nil, errors.New("pause-lights takes whether to pause")
+		}
+		// VJ software often can't send booleans, so accept numbers too.
+		paused := fmt.Sprint(arguments[0])
+		params = map[string]interface{}{"paused": paused == "true" || (paused != "false" && paused != "0")}
+	default:
+		params = map[string]interface{}{}
+	}
+	return json.Marshal(params)
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestOSCParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		arguments []interface{}
+		want      string
+	}{
+		{"trigger", "set-trigger", []interface{}{"bar"}, `{"trigger":"bar"}`},
+		{"brightness", "set-brightness", []interface{}{float32(0.5)}, `{"brightness":0.5}`},
+		{"one color", "set-palette", []interface{}{"FF0000"}, `{"colors":["FF0000"]}`},
+		{"colors", "set-palette", []interface{}{"FF0000", "00ff00"}, `{"colors":["FF0000","00ff00"]}`},
+		{"named palette", "set-palette", []interface{}{"ocean"}, `{"name":"ocean"}`},
+		{"palette strategy", "set-palette", []interface{}{"mood"}, `{"strategy":"mood"}`},
+		{"effect", "set-effect", []interface{}{"rainbow"}, `{"effect":"rainbow"}`},
+		{"effect on a device", "set-effect", []interface{}{"strobe", "blinkt"}, `{"device":"blinkt","effect":"strobe"}`},
+		{"pause", "pause-lights", []interface{}{true}, `{"paused":true}`},
+		{"resume", "pause-lights", []interface{}{false}, `{"paused":false}`},
+		{"pause with a number", "pause-lights", []interface{}{int32(1)}, `{"paused":true}`},
+		{"resume with a number", "pause-lights", []interface{}{int32(0)}, `{"paused":false}`},
+		{"resume with a string", "pause-lights", []interface{}{"false"}, `{"paused":false}`},
+		{"no params", "get-status", []interface{}{"ignored"}, `{}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params, err := oscParams(test.command, test.arguments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(params) != test.want {
+				t.Errorf("params = %s, want %s", params, test.want)
+			}
+		})
+	}
+}
+
+func TestInvalidOSCParams(t *testing.T) {
+	tests := []struct {
+		command   string
+		arguments []interface{}
+	}{
+		{"set-trigger", nil},
+		{"set-trigger", []interface{}{"beat", "bar"}},
+		{"set-brightness", nil},
+		{"set-effect", nil},
+		{"set-effect", []interface{}{"strobe", "blinkt", "extra"}},
+		{"pause-lights", nil},
+	}
+	for _, test := range tests {
+		if params, err := oscParams(test.command, test.arguments); err == nil {
+			t.Errorf("%s %v: params = %s, want an error", test.command, test.arguments, params)
+		}
+	}
+}
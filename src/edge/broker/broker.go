@@ -0,0 +1,472 @@
+// Package broker is a small MQTT 3.1.1 broker that runs inside the gateway, so edge devices can connect to the gateway
+// directly without a separate broker such as Mosquitto.
+//
+// It supports QoS 0 and 1 subscriptions (QoS 2 publishes are accepted and delivered at QoS 1), retained messages,
+// wildcard subscriptions, last will messages and username/password authentication. Sessions aren't persisted, and
+// messages to QoS 1 subscribers are sent once without redelivery.
+package broker
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// connectTimeout is how long a new connection has to send its CONNECT packet.
+const connectTimeout = 10 * time.Second
+
+// maxQoS is the highest QoS messages are delivered with.
+const maxQoS = 1
+
+// writeTimeout is how long a client has to accept a packet before it's disconnected.
+const writeTimeout = 10 * time.Second
+
+// queueSize is how many messages can wait to be sent to a client. Clients that fall further behind are disconnected,
+// so one slow client can't hold up everyone else.
+const queueSize = 256
+
+// Config holds the settings of the broker.
+type Config struct {
+	Address  string // The host:port to listen on, e.g. ":1883".
+	Username string // If set, clients must connect with this username and password.
+	Password string
+}
+
+// Broker is an embedded MQTT broker.
+type Broker struct {
+	config   Config
+	listener net.Listener
+
+	mu       sync.RWMutex
+	sessions map[string]*session
+	retained map[string]*packets.PublishPacket
+	closed   bool
+
+	nextAnonymousID uint64
+}
+
+// New creates a broker with the given config. Call Start to start accepting connections.
+func New(config Config) *Broker {
+	return &Broker{
+		config:   config,
+		sessions: map[string]*session{},
+		retained: map[string]*packets.PublishPacket{},
+	}
+}
+
+// Start starts listening for connections in the background.
+func (b *Broker) Start() error {
+	listener, err := net.Listen("tcp", b.config.Address)
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	log.Println("MQTT broker listening on", listener.Addr())
+
+	go b.serve()
+	return nil
+}
+
+// Addr returns the address the broker is listening on.
+func (b *Broker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Close stops the broker and disconnects every client.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range sessions {
+		s.conn.Close()
+	}
+	return b.listener.Close()
+}
+
+func (b *Broker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			b.mu.RLock()
+			closed := b.closed
+			b.mu.RUnlock()
+			if !closed {
+				log.Println("MQTT broker stopped accepting connections:", err)
+			}
+			return
+		}
+		go b.handleConnection(conn)
+	}
+}
+
+// handleConnection runs a single client's connection until it disconnects.
+func (b *Broker) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	s, err := b.connect(conn)
+	if err != nil {
+		log.Printf("MQTT client %s failed to connect: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	log.Printf("MQTT client %s connected from %s\n", s.id, conn.RemoteAddr())
+	go s.sendQueued()
+
+	cleanDisconnect := false
+	for {
+		if s.keepalive > 0 {
+			// Clients get one and a half keepalive periods to send something.
+			conn.SetReadDeadline(time.Now().Add(s.keepalive * 3 / 2))
+		}
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("MQTT client %s dropped: %v\n", s.id, err)
+			}
+			break
+		}
+		if _, ok := packet.(*packets.DisconnectPacket); ok {
+			cleanDisconnect = true
+			break
+		}
+		if err := b.handlePacket(s, packet); err != nil {
+			log.Printf("MQTT client %s sent a bad packet: %v\n", s.id, err)
+			break
+		}
+	}
+
+	close(s.done)
+	b.disconnect(s, cleanDisconnect)
+	log.Printf("MQTT client %s disconnected\n", s.id)
+}
+
+// connect reads the CONNECT packet and sets up the session of the client.
+func (b *Broker) connect(conn net.Conn) (*session, error) {
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		return nil, errors.New("expected CONNECT, got " + packet.String())
+	}
+
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = connect.Validate()
+	if connack.ReturnCode == packets.Accepted && !b.authorised(connect) {
+		connack.ReturnCode = packets.ErrRefusedNotAuthorised
+	}
+	if err := connack.Write(conn); err != nil {
+		return nil, err
+	}
+	if connack.ReturnCode != packets.Accepted {
+		return nil, errors.New(packets.ConnackReturnCodes[connack.ReturnCode])
+	}
+
+	s := &session{
+		id:            connect.ClientIdentifier,
+		conn:          conn,
+		keepalive:     time.Duration(connect.Keepalive) * time.Second,
+		subscriptions: map[string]byte{},
+		queue:         make(chan queuedMessage, queueSize),
+		done:          make(chan struct{}),
+	}
+	if connect.WillFlag {
+		s.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		s.will.TopicName = connect.WillTopic
+		s.will.Payload = connect.WillMessage
+		s.will.Qos = connect.WillQos
+		s.will.Retain = connect.WillRetain
+	}
+
+	b.mu.Lock()
+	if s.id == "" {
+		b.nextAnonymousID++
+		s.id = fmt.Sprintf("anonymous-%d", b.nextAnonymousID)
+	}
+	// A client connecting with the ID of an existing client takes over from it.
+	existing := b.sessions[s.id]
+	b.sessions[s.id] = s
+	b.mu.Unlock()
+
+	if existing != nil {
+		log.Printf("MQTT client %s reconnected, closing its old connection\n", s.id)
+		existing.conn.Close()
+	}
+	return s, nil
+}
+
+// authorised checks the client's credentials, if the broker needs them.
+func (b *Broker) authorised(connect *packets.ConnectPacket) bool {
+	if b.config.Username == "" && b.config.Password == "" {
+		return true
+	}
+	usernameMatches := subtle.ConstantTimeCompare([]byte(connect.Username), []byte(b.config.Username)) == 1
+	passwordMatches := subtle.ConstantTimeCompare(connect.Password, []byte(b.config.Password)) == 1
+	return usernameMatches && passwordMatches
+}
+
+// disconnect removes the session, sending its will if the client didn't disconnect cleanly.
+func (b *Broker) disconnect(s *session, clean bool) {
+	b.mu.Lock()
+	// The session may already have been replaced by a new connection with the same ID.
+	if b.sessions[s.id] == s {
+		delete(b.sessions, s.id)
+	}
+	b.mu.Unlock()
+
+	if !clean && s.will != nil {
+		b.publish(s.will)
+	}
+}
+
+func (b *Broker) handlePacket(s *session, packet packets.ControlPacket) error {
+	switch p := packet.(type) {
+	case *packets.PublishPacket:
+		if err := topics.ValidateTopic(p.TopicName); err != nil {
+			return err
+		}
+		switch p.Qos {
+		case 1:
+			puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			puback.MessageID = p.MessageID
+			if err := s.write(puback); err != nil {
+				return err
+			}
+		case 2:
+			pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+			pubrec.MessageID = p.MessageID
+			if err := s.write(pubrec); err != nil {
+				return err
+			}
+		}
+		b.publish(p)
+
+	case *packets.PubrelPacket:
+		pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+		pubcomp.MessageID = p.MessageID
+		return s.write(pubcomp)
+
+	case *packets.SubscribePacket:
+		return b.subscribe(s, p)
+
+	case *packets.UnsubscribePacket:
+		s.mu.Lock()
+		for _, filter := range p.Topics {
+			delete(s.subscriptions, filter)
+		}
+		s.mu.Unlock()
+		unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+		unsuback.MessageID = p.MessageID
+		return s.write(unsuback)
+
+	case *packets.PingreqPacket:
+		return s.write(packets.NewControlPacket(packets.Pingresp))
+
+	case *packets.PubackPacket, *packets.PubrecPacket, *packets.PubcompPacket:
+		// Messages aren't redelivered, so there's nothing to do with acknowledgements.
+
+	default:
+		return errors.New("unexpected packet " + packet.String())
+	}
+	return nil
+}
+
+// subscribe adds the subscriptions and sends the client any retained messages that match them.
+func (b *Broker) subscribe(s *session, subscribe *packets.SubscribePacket) error {
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID = subscribe.MessageID
+
+	var accepted []string
+	s.mu.Lock()
+	for i, filter := range subscribe.Topics {
+		if err := topics.ValidateFilter(filter); err != nil {
+			log.Printf("MQTT client %s: %v\n", s.id, err)
+			suback.ReturnCodes = append(suback.ReturnCodes, 0x80)
+			continue
+		}
+		qos := subscribe.Qoss[i]
+		if qos > maxQoS {
+			qos = maxQoS
+		}
+		s.subscriptions[filter] = qos
+		suback.ReturnCodes = append(suback.ReturnCodes, qos)
+		accepted = append(accepted, filter)
+	}
+	s.mu.Unlock()
+
+	if err := s.write(suback); err != nil {
+		return err
+	}
+
+	// Send the retained messages after the SUBACK.
+	b.mu.RLock()
+	var retained []*packets.PublishPacket
+	for topic, msg := range b.retained {
+		for _, filter := range accepted {
+			if topics.Match(filter, topic) {
+				retained = append(retained, msg)
+				break
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, msg := range retained {
+		if qos, matched := s.matchQoS(msg.TopicName); matched {
+			if err := s.deliver(msg, qos, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// publish stores the message if it's retained, and queues it for every client subscribed to its topic. Clients that
+// are too far behind to take it are disconnected.
+func (b *Broker) publish(msg *packets.PublishPacket) {
+	b.mu.Lock()
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			// An empty retained message clears the retained message.
+			delete(b.retained, msg.TopicName)
+		} else {
+			b.retained[msg.TopicName] = msg
+		}
+	}
+	sessions := make([]*session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range sessions {
+		qos, matched := s.matchQoS(msg.TopicName)
+		if !matched {
+			continue
+		}
+		if err := s.deliver(msg, qos, false); err != nil {
+			log.Printf("Failed to deliver message to MQTT client %s: %v\n", s.id, err)
+			s.conn.Close()
+		}
+	}
+}
+
+// session holds the state of a single connected client.
+type session struct {
+	id        string
+	conn      net.Conn
+	keepalive time.Duration
+	will      *packets.PublishPacket
+
+	mu            sync.Mutex
+	subscriptions map[string]byte // Topic filter to QoS.
+
+	queue chan queuedMessage // The messages waiting to be sent to the client.
+	done  chan struct{}      // Closed once the client has disconnected.
+
+	writeMu       sync.Mutex
+	nextMessageID uint16
+}
+
+// queuedMessage is a message waiting to be sent to a client.
+type queuedMessage struct {
+	msg      *packets.PublishPacket
+	qos      byte
+	retained bool
+}
+
+// matchQoS returns the highest QoS of the client's subscriptions that match the topic.
+func (s *session) matchQoS(topic string) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var qos byte
+	matched := false
+	for filter, subQoS := range s.subscriptions {
+		if topics.Match(filter, topic) {
+			matched = true
+			if subQoS > qos {
+				qos = subQoS
+			}
+		}
+	}
+	return qos, matched
+}
+
+// deliver queues the message to be sent to the client, failing if the client is too far behind to take it.
+func (s *session) deliver(msg *packets.PublishPacket, subQoS byte, retained bool) error {
+	select {
+	case s.queue <- queuedMessage{msg: msg, qos: subQoS, retained: retained}:
+		return nil
+	case <-s.done:
+		return errors.New("client has disconnected")
+	default:
+		return fmt.Errorf("client is more than %d messages behind", queueSize)
+	}
+}
+
+// sendQueued sends the queued messages to the client until it disconnects.
+func (s *session) sendQueued() {
+	for {
+		select {
+		case q := <-s.queue:
+			if err := s.send(q.msg, q.qos, q.retained); err != nil {
+				log.Printf("Failed to deliver message to MQTT client %s: %v\n", s.id, err)
+				s.conn.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send writes the message to the client, downgrading it to the subscription QoS.
+func (s *session) send(msg *packets.PublishPacket, subQoS byte, retained bool) error {
+	qos := msg.Qos
+	if subQoS < qos {
+		qos = subQoS
+	}
+
+	out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	out.TopicName = msg.TopicName
+	out.Payload = msg.Payload
+	out.Qos = qos
+	out.Retain = retained
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if qos > 0 {
+		s.nextMessageID++
+		if s.nextMessageID == 0 {
+			// 0 isn't a valid message ID.
+			s.nextMessageID = 1
+		}
+		out.MessageID = s.nextMessageID
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return out.Write(s.conn)
+}
+
+func (s *session) write(packet packets.ControlPacket) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return packet.Write(s.conn)
+}
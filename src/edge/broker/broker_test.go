@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// dial connects a client with the ID to the broker.
+func dial(t *testing.T, b *Broker, id string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.CleanSession = true
+	connect.ClientIdentifier = id
+	if err := connect.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readPacket(t, conn).(*packets.ConnackPacket); !ok {
+		t.Fatal("expected CONNACK")
+	}
+	return conn
+}
+
+// subscribe subscribes the client to the filter.
+func subscribe(t *testing.T, conn net.Conn, filter string) {
+	t.Helper()
+	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sub.MessageID = 1
+	sub.Topics = []string{filter}
+	sub.Qoss = []byte{0}
+	if err := sub.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readPacket(t, conn).(*packets.SubackPacket); !ok {
+		t.Fatal("expected SUBACK")
+	}
+}
+
+func readPacket(t *testing.T, conn net.Conn) packets.ControlPacket {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func publishPacket(topic string, payload []byte) *packets.PublishPacket {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = payload
+	return p
+}
+
+func TestSlowClientDoesNotBlockPublishers(t *testing.T) {
+	b := New(Config{Address: "127.0.0.1:0"})
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// The slow client subscribes to everything, then never reads.
+	slow := dial(t, b, "slow")
+	subscribe(t, slow, "#")
+	fast := dial(t, b, "fast")
+	subscribe(t, fast, "ping")
+	publisher := dial(t, b, "publisher")
+
+	// Flood the slow client with far more than its socket and queue can hold.
+	flooded := make(chan error, 1)
+	go func() {
+		payload := make([]byte, 32*1024)
+		for i := 0; i < 2000; i++ {
+			if err := publishPacket("flood", payload).Write(publisher); err != nil {
+				flooded <- err
+				return
+			}
+		}
+		flooded <- publishPacket("ping", []byte("pong")).Write(publisher)
+	}()
+	select {
+	case err := <-flooded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher blocked by a slow client")
+	}
+
+	// Other clients still get their messages.
+	msg, ok := readPacket(t, fast).(*packets.PublishPacket)
+	if !ok || msg.TopicName != "ping" || string(msg.Payload) != "pong" {
+		t.Fatalf("fast client got %v, want the ping", msg)
+	}
+
+	// And the slow client is dropped.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.RLock()
+		_, connected := b.sessions["slow"]
+		b.mu.RUnlock()
+		if !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow client wasn't disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRetainedMessagesAreQueuedAfterSuback(t *testing.T) {
+	b := New(Config{Address: "127.0.0.1:0"})
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	publisher := dial(t, b, "publisher")
+	retained := publishPacket("status", []byte("on"))
+	retained.Retain = true
+	if err := retained.Write(publisher); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the broker has handled the publish before subscribing.
+	if err := packets.NewControlPacket(packets.Pingreq).Write(publisher); err != nil {
+		t.Fatal(err)
+	}
+	readPacket(t, publisher)
+
+	subscriber := dial(t, b, "subscriber")
+	subscribe(t, subscriber, "status")
+	msg, ok := readPacket(t, subscriber).(*packets.PublishPacket)
+	if !ok || !msg.Retain || string(msg.Payload) != "on" {
+		t.Fatalf("got %v, want the retained message", msg)
+	}
+}
@@ -2,49 +2,127 @@
 package edge
 
 import (
+	"errors"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
 	"github.com/tom-milner/LightBeatGateway/edge/topics"
 )
 
 var client mqtt.Client
 
+// gatewayID identifies this gateway in the envelope of every message it sends.
+var gatewayID string
+
+// sequence is the sequence number of the last message sent.
+var sequence uint64
+
 // MQTTConnInfo holds all the info needed to connect to an MQTT broker
 type MQTTConnInfo struct {
 	Username string
 	Password string
 	ClientID string
 	Broker   MQTTBroker
+	TLS      MQTTTLSInfo
 }
 
 // MQTTBroker holds all the information about a single broker
 type MQTTBroker struct {
+	Scheme  string // One of tcp, ssl, ws or wss. Defaults to tcp.
 	Address string
 	Port    string
+	Path    string // The path of the websocket endpoint, for ws and wss.
+}
+
+// URL returns the URL used to dial the broker.
+func (b MQTTBroker) URL() (string, error) {
+	scheme := strings.ToLower(b.Scheme)
+	if scheme == "" {
+		scheme = "tcp"
+	}
+
+	switch scheme {
+	case "tcp", "ssl":
+		return scheme + "://" + b.Address + ":" + b.Port, nil
+	case "ws", "wss":
+		path := b.Path
+		if path != "" && !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return scheme + "://" + b.Address + ":" + b.Port + path, nil
+	default:
+		return "", errors.New("unsupported MQTT broker scheme: " + b.Scheme)
+	}
+}
+
+// usesTLS returns whether the connection to the broker is encrypted.
+func (b MQTTBroker) usesTLS() bool {
+	scheme := strings.ToLower(b.Scheme)
+	return scheme == "ssl" || scheme == "wss"
 }
 
 type EdgeMessage mqtt.Message
 type MessageHandler func(EdgeMessage)
 
-var messageHandlers map[topics.TopicName]MessageHandler
+var messageRouter = newRouter()
 
-// Handle any default messages.
+// Handle messages that arrive without a matching subscription, such as those sent before a resubscription.
 func mqttMessageHandler(client mqtt.Client, msg mqtt.Message) {
 	log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
 
-	// Call the correct function for the topic.
-	messageHandlers[topics.TopicName(msg.Topic())](EdgeMessage(msg))
+	// Call the correct functions for the topic.
+	messageRouter.dispatch(EdgeMessage(msg))
+}
+
+// OnReceive registers a handler for a topic. The topic can contain the MQTT + and # wildcards, and a topic can have
+// multiple handlers.
+func OnReceive(topic topics.TopicName, handler MessageHandler) error {
+	if err := topics.ValidateFilter(string(topic)); err != nil {
+		return err
+	}
+	if handler == nil {
+		return errors.New("handler must not be nil")
+	}
+
+	// Only subscribe the first time we see a topic.
+	if !messageRouter.add(topic, handler) {
+		return nil
+	}
+	return subscribe(topic)
 }
 
-func OnReceive(topic topics.TopicName, handler MessageHandler) {
-	client.Subscribe(string(topic), 0, mqttMessageHandler)
-	messageHandlers[topic] = handler
+// OnUnhandled sets the handler called for messages that don't match any other handler.
+func OnUnhandled(handler MessageHandler) {
+	messageRouter.setFallback(handler)
+}
+
+// subscribe subscribes to the topic filter, passing its messages to the handlers of that filter only.
+// The subscription uses the QoS the topic is published with, so commands sent at QoS 1 aren't downgraded and lost.
+// Filters with wildcards use the policy of the levels before them, e.g. command/+ uses command's.
+func subscribe(topic topics.TopicName) error {
+	token := client.Subscribe(string(topic), topics.PolicyFor(topic).QoS, func(client mqtt.Client, msg mqtt.Message) {
+		log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+		messageRouter.dispatchFilter(topic, EdgeMessage(msg))
+	})
+	token.Wait()
+	return token.Error()
 }
 
 // Function called when connected
 func onConnectHandler(client mqtt.Client) {
 	log.Println("Connected as ", client.OptionsReader())
+
+	// Subscriptions are lost when the connection drops, so resubscribe to everything we have handlers for.
+	for _, topic := range messageRouter.filters() {
+		if err := subscribe(topic); err != nil {
+			log.Printf("Failed to resubscribe to %s: %v\n", topic, err)
+		}
+	}
 }
 
 func onConnectionLostHandler(client mqtt.Client, err error) {
@@ -54,32 +132,113 @@ func onConnectionLostHandler(client mqtt.Client, err error) {
 // ConnectToMQTTBroker connects to the specified MQTT broker.
 func ConnectToMQTTBroker(info MQTTConnInfo) (mqtt.Client, error) {
 
+	brokerURL, err := info.Broker.URL()
+	if err != nil {
+		return nil, err
+	}
+
 	// Add connection settings
 	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL)
 	opts.SetClientID(info.ClientID)
-	opts.AddBroker("tcp://" + info.Broker.Address + ":" + info.Broker.Port)
-	opts.SetClientID(info.ClientID)
+	gatewayID = info.ClientID
 	opts.SetUsername(info.Username)
 	opts.SetPassword(info.Password)
 
+	if info.Broker.usesTLS() {
+		tlsConfig, err := newTLSConfig(info.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
 	opts.SetDefaultPublishHandler(mqttMessageHandler)
 	opts.OnConnect = onConnectHandler
 	opts.OnConnectionLost = onConnectionLostHandler
 
+	// Every time we connect to a new MQTT broker, we'll need to respecify the topics to subscribe to.
+	messageRouter = newRouter()
+	replyOnce = sync.Once{}
+
 	client = mqtt.NewClient(opts)
 
 	// Connect!
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
 		return client, token.Error()
 	}
-
-	// Every time we connect to a new MQTT broker, we'll need to respecify the topics to subscribe to.
-	messageHandlers = map[topics.TopicName]MessageHandler{}
+	AddTransport("mqtt", mqttTransport{})
 
 	return client, nil
 }
 
-func SendMessage(topic topics.TopicName, payload interface{}) {
-	token := client.Publish(string(topic), 0, false, payload)
+// SendMessage wraps the body in an envelope and sends it to the topic over every transport.
+func SendMessage(topic topics.TopicName, body interface{}) {
+	envelope, err := messages.New(gatewayID, atomic.AddUint64(&sequence, 1), body)
+	if err != nil {
+		log.Printf("Failed to build message for %s: %v\n", topic, err)
+		return
+	}
+	sendToTransports(topic, envelope, body)
+}
+
+// SendError tells the sender of a message on the topic why it was rejected.
+func SendError(topic topics.TopicName, rejectedSequence uint64, reason error) {
+	SendMessage(messages.ErrorTopic(topic), messages.Error{
+		Topic:    topic,
+		Sequence: rejectedSequence,
+		Message:  reason.Error(),
+	})
+}
+
+// publish sends the payload to the topic on the MQTT broker, using the topic's publish policy.
+func publish(topic topics.TopicName, payload []byte) error {
+	policy := topics.PolicyFor(topic)
+	token := client.Publish(string(topic), policy.QoS, policy.Retained, payload)
 	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	if policy.Retained {
+		scheduleExpiry(topic, policy)
+	}
+	return nil
+}
+
+// expiryTimers holds the timers that clear retained messages once they expire.
+var expiryTimers = map[topics.TopicName]*time.Timer{}
+var expiryMu sync.Mutex
+
+// scheduleExpiry clears the retained message on the topic after the policy's expiry, unless a newer message is sent first.
+// MQTT 3.1.1 has no message expiry, so the gateway has to clear retained messages itself.
+func scheduleExpiry(topic topics.TopicName, policy topics.Policy) {
+	expiryMu.Lock()
+	defer expiryMu.Unlock()
+
+	if timer, exists := expiryTimers[topic]; exists {
+		timer.Stop()
+		delete(expiryTimers, topic)
+	}
+	if policy.Expiry <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(policy.Expiry, func() {
+		expiryMu.Lock()
+		// Don't clear the topic if a newer message has replaced this timer.
+		if expiryTimers[topic] != timer {
+			expiryMu.Unlock()
+			return
+		}
+		delete(expiryTimers, topic)
+		expiryMu.Unlock()
+
+		// An empty retained message removes the retained message from the broker.
+		log.Printf("Retained message on %s expired.\n", topic)
+		token := client.Publish(string(topic), policy.QoS, true, []byte{})
+		token.Wait()
+	})
+	expiryTimers[topic] = timer
 }
@@ -0,0 +1,244 @@
+package edge
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/tom-milner/LightBeatGateway/edge/broker"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// startBroker starts an embedded broker on loopback, and connects the edge client to it.
+func startBroker(t *testing.T) *broker.Broker {
+	t.Helper()
+	b := broker.New(broker.Config{Address: "127.0.0.1:0"})
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	_, port, _ := net.SplitHostPort(b.Addr().String())
+	c, err := ConnectToMQTTBroker(MQTTConnInfo{
+		ClientID: "edge-test",
+		Broker:   MQTTBroker{Address: "127.0.0.1", Port: port},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Disconnect(100) })
+	return b
+}
+
+func TestOverlappingFiltersCallEachHandlerOnce(t *testing.T) {
+	startBroker(t)
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	counter := func(name string) MessageHandler {
+		return func(msg EdgeMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[name]++
+		}
+	}
+	for filter, name := range map[topics.TopicName]string{
+		"command/+":           "wildcard",
+		"command/set-trigger": "exact",
+		"#":                   "everything",
+	} {
+		if err := OnReceive(filter, counter(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := client.Publish("command/set-trigger", 0, false, []byte("{}"))
+	token.Wait()
+	if err := token.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the message to come back, then a little longer for any duplicates.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		received := len(calls) == 3
+		mu.Unlock()
+		if received || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"wildcard", "exact", "everything"} {
+		if calls[name] != 1 {
+			t.Errorf("%s handler called %d times, want 1", name, calls[name])
+		}
+	}
+}
+
+func TestPanickingHandlerDoesNotStopOthers(t *testing.T) {
+	r := newRouter()
+	var calls []string
+	r.add("trigger", func(msg EdgeMessage) { calls = append(calls, "first") })
+	r.add("trigger", func(msg EdgeMessage) { panic("broken handler") })
+	r.add("#", func(msg EdgeMessage) { calls = append(calls, "everything") })
+
+	r.dispatch(localMessage{topic: "trigger"})
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "everything" {
+		t.Errorf("handlers called: %v, want first and everything", calls)
+	}
+
+	// The panic doesn't stop later messages being handled either.
+	calls = nil
+	r.dispatchFilter("trigger", localMessage{topic: "trigger"})
+	if len(calls) != 1 || calls[0] != "first" {
+		t.Errorf("handlers called: %v, want first", calls)
+	}
+}
+
+func TestUnhandledMessagesGoToTheFallback(t *testing.T) {
+	r := newRouter()
+	var handled, unhandled []string
+	r.add("command/+", func(msg EdgeMessage) { handled = append(handled, msg.Topic()) })
+
+	// Without a fallback, unhandled messages are dropped.
+	r.dispatch(localMessage{topic: "trigger"})
+
+	r.setFallback(func(msg EdgeMessage) { unhandled = append(unhandled, msg.Topic()) })
+	r.dispatch(localMessage{topic: "command/set-trigger"})
+	r.dispatch(localMessage{topic: "trigger"})
+	r.dispatch(localMessage{topic: "command/set-trigger/response"})
+
+	if len(handled) != 1 || handled[0] != "command/set-trigger" {
+		t.Errorf("handled %v, want command/set-trigger", handled)
+	}
+	if len(unhandled) != 2 || unhandled[0] != "trigger" || unhandled[1] != "command/set-trigger/response" {
+		t.Errorf("fallback handled %v, want trigger and command/set-trigger/response", unhandled)
+	}
+}
+
+func TestPanickingFallbackIsRecovered(t *testing.T) {
+	r := newRouter()
+	r.setFallback(func(msg EdgeMessage) { panic("broken fallback") })
+	r.dispatch(localMessage{topic: "trigger"})
+}
+
+// received collects the messages on a topic filter, subscribed to directly so the router isn't involved.
+func received(t *testing.T, filter string) chan mqtt.Message {
+	t.Helper()
+	messages := make(chan mqtt.Message, 10)
+	token := client.Subscribe(filter, 1, func(c mqtt.Client, msg mqtt.Message) { messages <- msg })
+	token.Wait()
+	if err := token.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+// nextMessage returns the next message received, or nil if none arrives in time.
+func nextMessage(messages chan mqtt.Message, timeout time.Duration) mqtt.Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestSubscriptionsUseTheTopicQoS(t *testing.T) {
+	startBroker(t)
+
+	qos := make(chan byte, 10)
+	handler := func(msg EdgeMessage) { qos <- msg.Qos() }
+	tests := []struct {
+		filter  topics.TopicName
+		topic   string
+		wantQoS byte
+	}{
+		{"command/+", "command/set-trigger", 1},
+		{topics.SetTrigger, "set-trigger", 1},
+		{topics.Trigger, "trigger", 0},
+	}
+	for _, test := range tests {
+		if err := OnReceive(test.filter, handler); err != nil {
+			t.Fatal(err)
+		}
+		token := client.Publish(test.topic, 1, false, []byte("{}"))
+		token.Wait()
+		if err := token.Error(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-qos:
+			if got != test.wantQoS {
+				t.Errorf("%s received at QoS %d, want %d", test.filter, got, test.wantQoS)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("nothing received on %s", test.filter)
+		}
+	}
+}
+
+func TestRetainedMessagesExpire(t *testing.T) {
+	startBroker(t)
+	const topic = "test/expiry"
+	policy := topics.Policy{QoS: 1, Retained: true, Expiry: 100 * time.Millisecond}
+
+	token := client.Publish(topic, 1, true, []byte("retained"))
+	token.Wait()
+	if err := token.Error(); err != nil {
+		t.Fatal(err)
+	}
+	scheduleExpiry(topic, policy)
+
+	messages := received(t, topic)
+	if msg := nextMessage(messages, 2*time.Second); msg == nil || string(msg.Payload()) != "retained" {
+		t.Fatalf("received %v, want the retained message", msg)
+	}
+	// After the expiry, an empty retained message clears the topic.
+	msg := nextMessage(messages, 2*time.Second)
+	if msg == nil {
+		t.Fatal("retained message wasn't cleared")
+	}
+	if len(msg.Payload()) != 0 {
+		t.Errorf("cleared with %q, want an empty message", msg.Payload())
+	}
+
+	// Later subscribers don't receive the expired message.
+	if msg := nextMessage(received(t, "test/#"), 200*time.Millisecond); msg != nil {
+		t.Errorf("received %q after it expired", msg.Payload())
+	}
+}
+
+func TestNewerMessagesReplaceTheExpiry(t *testing.T) {
+	startBroker(t)
+	const topic = "test/replaced"
+	messages := received(t, topic)
+
+	scheduleExpiry(topic, topics.Policy{QoS: 1, Retained: true, Expiry: 50 * time.Millisecond})
+	// A message that never expires cancels the earlier expiry.
+	scheduleExpiry(topic, topics.Policy{QoS: 1, Retained: true})
+	if msg := nextMessage(messages, 300*time.Millisecond); msg != nil {
+		t.Errorf("cleared the topic after a newer message, received %q", msg.Payload())
+	}
+
+	// Only the latest expiry clears the topic.
+	scheduleExpiry(topic, topics.Policy{QoS: 1, Retained: true, Expiry: 50 * time.Millisecond})
+	scheduleExpiry(topic, topics.Policy{QoS: 1, Retained: true, Expiry: 150 * time.Millisecond})
+	start := time.Now()
+	if msg := nextMessage(messages, 2*time.Second); msg == nil {
+		t.Fatal("topic wasn't cleared")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("cleared after %v, want the later expiry", elapsed)
+	}
+	if msg := nextMessage(messages, 300*time.Millisecond); msg != nil {
+		t.Errorf("cleared the topic twice")
+	}
+}
@@ -0,0 +1,172 @@
+package edge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// Frames are a compact, fixed-layout binary alternative to the JSON envelope, for devices that can't parse JSON at beat rate.
+// All the fields are big-endian. Every frame starts with a 16 byte header:
+//
+//	0  2 bytes  Magic, "LB"
+//	2  1 byte   Frame format version, currently 1
+//	3  1 byte   Kind, 1 for a trigger or 2 for a schedule
+//	4  4 bytes  Sequence number (the low 32 bits of the envelope sequence)
+//	8  8 bytes  Sent at, in milliseconds since the unix epoch
+//
+// A trigger frame is followed by the trigger number and duration in milliseconds, as 4 byte unsigned integers.
+// A schedule frame is followed by a 2 byte count of triggers, then 12 bytes for each trigger: its number, when it
+// happens (in milliseconds after the frame was sent) and its duration in milliseconds, as 4 byte unsigned integers.
+
+// FrameKind identifies what a binary frame contains.
+type FrameKind byte
+
+const (
+	TriggerFrame  FrameKind = 1
+	ScheduleFrame FrameKind = 2
+)
+
+// FrameVersion is the version of the binary frame layout.
+const FrameVersion = 1
+
+const (
+	frameHeaderSize          = 16
+	triggerFrameBodySize     = 8
+	scheduledTriggerSize     = 12
+	maxScheduledTriggers     = math.MaxUint16
+	frameMagic0, frameMagic1 = 'L', 'B'
+)
+
+// Frame is a decoded binary frame.
+type Frame struct {
+	Kind     FrameKind
+	Sequence uint32
+	SentAt   int64           // Milliseconds since the unix epoch.
+	Trigger  models.Trigger  // Set when Kind is TriggerFrame.
+	Schedule models.Schedule // Set when Kind is ScheduleFrame.
+}
+
+// newFrame builds the binary frame equivalent of the message.
+func newFrame(envelope messages.Envelope, body interface{}) (Frame, error) {
+	frame := Frame{
+		Sequence: uint32(envelope.Sequence),
+		SentAt:   envelope.SentAt,
+	}
+	switch b := body.(type) {
+	case models.Trigger:
+		frame.Kind = TriggerFrame
+		frame.Trigger = b
+	case models.Schedule:
+		frame.Kind = ScheduleFrame
+		frame.Schedule = b
+	default:
+		return frame, fmt.Errorf("%T can't be sent as a binary frame", body)
+	}
+	return frame, nil
+}
+
+// MarshalBinary encodes the frame.
+func (f Frame) MarshalBinary() ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	header[0], header[1] = frameMagic0, frameMagic1
+	header[2] = FrameVersion
+	header[3] = byte(f.Kind)
+	binary.BigEndian.PutUint32(header[4:], f.Sequence)
+	binary.BigEndian.PutUint64(header[8:], uint64(f.SentAt))
+
+	switch f.Kind {
+	case TriggerFrame:
+		body := make([]byte, triggerFrameBodySize)
+		if err := putUint32s(body, f.Trigger.Number, f.Trigger.Duration); err != nil {
+			return nil, err
+		}
+		return append(header, body...), nil
+
+	case ScheduleFrame:
+		triggers := f.Schedule.Triggers
+		if len(triggers) > maxScheduledTriggers {
+			return nil, fmt.Errorf("too many triggers in schedule: %d", len(triggers))
+		}
+		body := make([]byte, 2+len(triggers)*scheduledTriggerSize)
+		binary.BigEndian.PutUint16(body, uint16(len(triggers)))
+		for i, trigger := range triggers {
+			offset := 2 + i*scheduledTriggerSize
+			if err := putUint32s(body[offset:], trigger.Number, trigger.At, trigger.Duration); err != nil {
+				return nil, err
+			}
+		}
+		return append(header, body...), nil
+
+	default:
+		return nil, fmt.Errorf("unknown frame kind: %d", f.Kind)
+	}
+}
+
+// UnmarshalBinary decodes the frame.
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	if len(data) < frameHeaderSize {
+		return errors.New("frame too short")
+	}
+	if data[0] != frameMagic0 || data[1] != frameMagic1 {
+		return errors.New("not a LightBeat frame")
+	}
+	if data[2] != FrameVersion {
+		return fmt.Errorf("unsupported frame version %d, expected %d", data[2], FrameVersion)
+	}
+
+	*f = Frame{
+		Kind:     FrameKind(data[3]),
+		Sequence: binary.BigEndian.Uint32(data[4:]),
+		SentAt:   int64(binary.BigEndian.Uint64(data[8:])),
+	}
+	body := data[frameHeaderSize:]
+
+	switch f.Kind {
+	case TriggerFrame:
+		if len(body) != triggerFrameBodySize {
+			return fmt.Errorf("trigger frame body should be %d bytes, got %d", triggerFrameBodySize, len(body))
+		}
+		f.Trigger = models.Trigger{
+			Number:   int(binary.BigEndian.Uint32(body)),
+			Duration: int(binary.BigEndian.Uint32(body[4:])),
+		}
+
+	case ScheduleFrame:
+		if len(body) < 2 {
+			return errors.New("schedule frame missing trigger count")
+		}
+		count := int(binary.BigEndian.Uint16(body))
+		if len(body) != 2+count*scheduledTriggerSize {
+			return fmt.Errorf("schedule frame of %d triggers should be %d bytes, got %d", count, 2+count*scheduledTriggerSize, len(body))
+		}
+		f.Schedule.Triggers = make([]models.ScheduledTrigger, count)
+		for i := range f.Schedule.Triggers {
+			offset := 2 + i*scheduledTriggerSize
+			f.Schedule.Triggers[i] = models.ScheduledTrigger{
+				Number:   int(binary.BigEndian.Uint32(body[offset:])),
+				At:       int(binary.BigEndian.Uint32(body[offset+4:])),
+				Duration: int(binary.BigEndian.Uint32(body[offset+8:])),
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown frame kind: %d", f.Kind)
+	}
+	return nil
+}
+
+// putUint32s writes the values into b as consecutive 4 byte unsigned integers.
+func putUint32s(b []byte, values ...int) error {
+	for i, value := range values {
+		if value < 0 || int64(value) > math.MaxUint32 {
+			return fmt.Errorf("%d doesn't fit in a frame field", value)
+		}
+		binary.BigEndian.PutUint32(b[i*4:], uint32(value))
+	}
+	return nil
+}
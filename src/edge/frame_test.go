@@ -0,0 +1,141 @@
+package edge
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// frameBodies are bodies that can be sent as frames, with fields at the edges of what frames hold.
+var frameBodies = map[string]interface{}{
+	"trigger":        models.Trigger{Number: 42, Duration: 500},
+	"zero trigger":   models.Trigger{},
+	"largest fields": models.Trigger{Number: math.MaxUint32, Duration: math.MaxUint32},
+	"schedule": models.Schedule{Triggers: []models.ScheduledTrigger{
+		{Number: 1, At: 0, Duration: 480},
+		{Number: 2, At: 480, Duration: 520},
+		{Number: 3, At: 1000, Duration: 500},
+	}},
+	"empty schedule": models.Schedule{Triggers: []models.ScheduledTrigger{}},
+}
+
+// encodeFrame encodes the body as a frame, as it would be sent.
+func encodeFrame(t *testing.T, body interface{}) (messages.Envelope, []byte) {
+	t.Helper()
+	envelope, err := messages.New("gateway", 1<<32+7, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := newFrame(envelope, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope, data
+}
+
+func TestFrameRoundTripMatchesJSON(t *testing.T) {
+	for name, body := range frameBodies {
+		t.Run(name, func(t *testing.T) {
+			envelope, data := encodeFrame(t, body)
+			var frame Frame
+			if err := frame.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+
+			// Decode the JSON form of the same message, and check the frame says the same.
+			payload, err := json.Marshal(envelope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromJSON := reflect.New(reflect.TypeOf(body))
+			decoded, err := messages.Decode(payload, fromJSON.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if frame.Sequence != uint32(decoded.Sequence) {
+				t.Errorf("sequence = %d, want %d", frame.Sequence, uint32(decoded.Sequence))
+			}
+			if frame.SentAt != decoded.SentAt {
+				t.Errorf("sent at = %d, want %d", frame.SentAt, decoded.SentAt)
+			}
+			var fromFrame interface{}
+			switch frame.Kind {
+			case TriggerFrame:
+				fromFrame = frame.Trigger
+			case ScheduleFrame:
+				fromFrame = frame.Schedule
+			}
+			if want := fromJSON.Elem().Interface(); !reflect.DeepEqual(fromFrame, want) {
+				t.Errorf("frame body = %+v, want %+v", fromFrame, want)
+			}
+		})
+	}
+}
+
+func TestFrameTruncated(t *testing.T) {
+	for name, body := range frameBodies {
+		_, data := encodeFrame(t, body)
+		for n := 0; n < len(data); n++ {
+			var frame Frame
+			if err := frame.UnmarshalBinary(data[:n]); err == nil {
+				t.Errorf("%s: decoding the first %d of %d bytes succeeded", name, n, len(data))
+			}
+		}
+	}
+}
+
+func TestFrameOversized(t *testing.T) {
+	for name, body := range frameBodies {
+		_, data := encodeFrame(t, body)
+		var frame Frame
+		if err := frame.UnmarshalBinary(append(data, 0)); err == nil {
+			t.Errorf("%s: decoding a frame with a trailing byte succeeded", name)
+		}
+	}
+
+	// A schedule can't hold more triggers than its count can say.
+	schedule := models.Schedule{Triggers: make([]models.ScheduledTrigger, maxScheduledTriggers+1)}
+	if _, err := (Frame{Kind: ScheduleFrame, Schedule: schedule}).MarshalBinary(); err == nil {
+		t.Error("encoding a schedule of too many triggers succeeded")
+	}
+}
+
+func TestFrameInvalid(t *testing.T) {
+	_, valid := encodeFrame(t, frameBodies["trigger"])
+	corrupt := func(offset int, value byte) []byte {
+		data := append([]byte(nil), valid...)
+		data[offset] = value
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"bad magic":    corrupt(0, 'X'),
+		"bad version":  corrupt(2, FrameVersion+1),
+		"unknown kind": corrupt(3, 9),
+	} {
+		var frame Frame
+		if err := frame.UnmarshalBinary(data); err == nil {
+			t.Errorf("decoding a frame with a %s succeeded", name)
+		}
+	}
+
+	for name, trigger := range map[string]models.Trigger{
+		"negative number":   {Number: -1},
+		"too long duration": {Duration: math.MaxUint32 + 1},
+	} {
+		if _, err := (Frame{Kind: TriggerFrame, Trigger: trigger}).MarshalBinary(); err == nil {
+			t.Errorf("encoding a trigger with a %s succeeded", name)
+		}
+	}
+	if _, err := newFrame(messages.Envelope{}, models.Media{}); err == nil {
+		t.Error("building a frame of media succeeded")
+	}
+}
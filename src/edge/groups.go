@@ -0,0 +1,95 @@
+package edge
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// Encoding is the format messages are sent to a group of devices in.
+type Encoding string
+
+const (
+	JSONEncoding   Encoding = "json"
+	BinaryEncoding Encoding = "binary"
+)
+
+// groupEncodings maps each device group to the encoding its devices understand.
+// Devices in a group subscribe to <topic>/<group>, e.g. trigger/stage.
+var groupEncodings = map[string]Encoding{}
+var groupsMu sync.RWMutex
+
+// SetGroupEncoding sets the encoding of the trigger and schedule messages sent to a group of devices.
+func SetGroupEncoding(group string, encoding Encoding) error {
+	if group == "" || strings.ContainsAny(group, "/+#") {
+		return errors.New("invalid device group name: " + group)
+	}
+	if encoding != JSONEncoding && encoding != BinaryEncoding {
+		return errors.New("unsupported encoding: " + string(encoding))
+	}
+
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	groupEncodings[group] = encoding
+	return nil
+}
+
+// ParseGroupEncodings parses a list of device groups and their encodings, such as "stage:binary,booth:json".
+func ParseGroupEncodings(list string) (map[string]Encoding, error) {
+	encodings := map[string]Encoding{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, errors.New("device groups must be given as <group>:<encoding>, got: " + entry)
+		}
+		encodings[parts[0]] = Encoding(parts[1])
+	}
+	return encodings, nil
+}
+
+// SendTrigger sends the trigger to every device.
+func SendTrigger(trigger models.Trigger) {
+	SendMessage(topics.Trigger, trigger)
+}
+
+// SendSchedule sends the upcoming triggers to every device.
+func SendSchedule(schedule models.Schedule) {
+	SendMessage(topics.Schedule, schedule)
+}
+
+// publishToGroups publishes the message to the topic of each device group, in the group's encoding.
+// Every copy of the message has the same sequence number as the JSON message on the topic itself, so they can be compared.
+func publishToGroups(topic topics.TopicName, envelope messages.Envelope, body interface{}, jsonPayload []byte) error {
+	groupsMu.RLock()
+	defer groupsMu.RUnlock()
+
+	var binaryPayload []byte
+	for group, encoding := range groupEncodings {
+		payload := jsonPayload
+		if encoding == BinaryEncoding {
+			// Only encode the frame once, however many groups need it.
+			if binaryPayload == nil {
+				frame, err := newFrame(envelope, body)
+				if err != nil {
+					return err
+				}
+				if binaryPayload, err = frame.MarshalBinary(); err != nil {
+					return err
+				}
+			}
+			payload = binaryPayload
+		}
+		if err := publish(topics.GroupTopic(topic, group), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
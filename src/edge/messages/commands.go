@@ -0,0 +1,137 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Request is the body of a command sent to command/<name>.
+type Request struct {
+	ID      string          `json:"id"`               // Chosen by the caller, and copied into the response.
+	ReplyTo string          `json:"reply_to"`         // The topic to send the response to, under reply/.
+	Params  json.RawMessage `json:"params,omitempty"` // The parameters of the command.
+}
+
+// Validate checks the request can be replied to.
+func (r Request) Validate() error {
+	if r.ID == "" {
+		return errors.New("missing request id")
+	}
+	return topics.ValidateReplyTopic(r.ReplyTo)
+}
+
+// Response is the body of the reply to a Request.
+type Response struct {
+	ID     string          `json:"id"`               // The ID of the request.
+	OK     bool            `json:"ok"`               // Whether the command succeeded.
+	Result json.RawMessage `json:"result,omitempty"` // The result of the command, if it succeeded.
+	Error  string          `json:"error,omitempty"`  // Why the command failed, if it didn't.
+}
+
+// Command describes the parameters and result of a command.
+type Command struct {
+	Params interface{}
+	Result interface{}
+}
+
+// Commands maps each command the gateway understands to its parameters and result.
+var Commands = map[string]Command{
+	"set-trigger":    {Params: SetTrigger{}, Result: TriggerMode{}},
+	"get-status":     {Params: struct{}{}, Result: Status{}},
+	"set-brightness": {Params: SetBrightness{}, Result: Status{}},
+	"set-palette":    {Params: SetPalette{}, Result: Status{}},
+	"pause-lights":   {Params: PauseLights{}, Result: Status{}},
+	"set-effect":     {Params: SetEffect{}, Result: Status{}},
+}
+
+// Status describes what the gateway is currently doing.
+type Status struct {
+	Trigger    models.TriggerType `json:"trigger"`    // The current trigger type.
+	Playing    bool               `json:"playing"`    // Whether media is playing.
+	MediaID    string             `json:"media_id"`   // The Spotify ID of the current media.
+	MediaName  string             `json:"media_name"` // The name of the current media.
+	Brightness float64            `json:"brightness"` // The brightness of the lights, from 0 to 1.
+	Palette    []string           `json:"palette"`    // The hex colors the lights cycle through.
+	Strategy   string             `json:"strategy"`   // The strategy choosing the palette for each track, or empty if it was set by hand.
+	Paused     bool               `json:"paused"`     // Whether the lights are paused.
+	Effects    map[string]string  `json:"effects"`    // The effect each of the gateway's light devices is showing.
+}
+
+// SetBrightness is the command to change the brightness of the lights.
+type SetBrightness struct {
+	Brightness float64 `json:"brightness"` // From 0 to 1.
+}
+
+// Validate checks the brightness is in range.
+func (s SetBrightness) Validate() error {
+	if s.Brightness < 0 || s.Brightness > 1 {
+		return fmt.Errorf("brightness must be between 0 and 1, got %v", s.Brightness)
+	}
+	return nil
+}
+
+// SetPalette is the command to change the colors of the lights, to fixed colors, a named palette, or the colors a
+// strategy chooses for each track.
+type SetPalette struct {
+	Colors   []string `json:"colors,omitempty"`   // Hex color codes, e.g. FF0000.
+	Name     string   `json:"name,omitempty"`     // The name of a palette, e.g. ocean.
+	Strategy string   `json:"strategy,omitempty"` // The name of a palette strategy, e.g. mood.
+}
+
+// Validate checks there's exactly one of some colors, a name or a strategy, and that every color is a valid hex code.
+func (s SetPalette) Validate() error {
+	given := 0
+	for _, set := range []bool{len(s.Colors) > 0, s.Name != "", s.Strategy != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return errors.New("palette must have exactly one of colors, a name or a strategy")
+	}
+	for _, color := range s.Colors {
+		if !colors.IsHex(color) {
+			return fmt.Errorf("invalid hex color: %q", color)
+		}
+	}
+	return nil
+}
+
+// PauseLights is the command to pause or resume the lights.
+type PauseLights struct {
+	Paused bool `json:"paused"`
+}
+
+// SetEffect is the command to change the effect shown on the gateway's lights.
+type SetEffect struct {
+	Device string          `json:"device,omitempty"` // The light device to change, e.g. blinkt. Every device if empty.
+	Effect string          `json:"effect"`           // The name of the effect, e.g. pulse.
+	Params json.RawMessage `json:"params,omitempty"` // The settings of the effect. Defaults are used for any left out.
+}
+
+// Validate checks an effect has been chosen.
+func (s SetEffect) Validate() error {
+	if s.Effect == "" {
+		return errors.New("missing effect")
+	}
+	return nil
+}
+
+// DecodeParams decodes the parameters of a request, checking they're valid.
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := strictUnmarshal(params, v); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package messages
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequestReplyTopic(t *testing.T) {
+	tests := []struct {
+		replyTo string
+		valid   bool
+	}{
+		{"reply/LightBeatGateway", true},
+		{"reply/browser/42", true},
+		{"", false},
+		{"reply", false},
+		{"reply/", false},
+		{"reply/+", false},
+		{"replyx/client", false},
+		{"new-media", false},
+		{"trigger-mode", false},
+		{"command/set-trigger", false},
+	}
+	for _, test := range tests {
+		body, err := json.Marshal(Request{ID: "1", ReplyTo: test.replyTo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelope, err := New("client", 1, json.RawMessage(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var request Request
+		_, err = Decode(payload, &request)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("reply_to %q: got error %v, want valid %v", test.replyTo, err, test.valid)
+		}
+	}
+}
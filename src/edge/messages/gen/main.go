@@ -0,0 +1,23 @@
+// Command gen writes the JSON Schema of the LightBeat messages.
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+)
+
+func main() {
+	output := flag.String("o", "messages.schema.json", "the file to write the schema to")
+	flag.Parse()
+
+	b, err := messages.SchemaJSON()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+// Package messages defines the format of every message sent between the gateway and the edge devices.
+//
+// Every message is wrapped in an Envelope, with the topic-specific data in the envelope's body.
+// The JSON Schema in messages.schema.json describes all of them, and is regenerated with go generate.
+package messages
+
+//go:generate go run ./gen -o messages.schema.json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// SchemaVersion is the version of the message format. It's increased whenever a message changes incompatibly.
+const SchemaVersion = 1
+
+// Envelope wraps the body of every message.
+type Envelope struct {
+	Version   int             `json:"version"`    // The SchemaVersion the message was sent with.
+	GatewayID string          `json:"gateway_id"` // The ID of the gateway that sent the message.
+	Sequence  uint64          `json:"sequence"`   // Increases by one for every message sent by the gateway.
+	SentAt    int64           `json:"sent_at"`    // When the message was sent, in milliseconds since the unix epoch.
+	Body      json.RawMessage `json:"body"`       // The topic-specific data.
+}
+
+// TriggerMode is sent whenever the trigger type changes.
+type TriggerMode struct {
+	Trigger models.TriggerType `json:"trigger"`
+}
+
+// SetTrigger is the command to change the trigger type.
+type SetTrigger struct {
+	Trigger models.TriggerType `json:"trigger"`
+}
+
+// Validate checks the trigger type is supported.
+func (s SetTrigger) Validate() error {
+	if !s.Trigger.Valid() {
+		return fmt.Errorf("unsupported trigger type: %q", s.Trigger)
+	}
+	return nil
+}
+
+// Error is sent to <topic>/error when a message sent to <topic> is rejected.
+type Error struct {
+	Topic    topics.TopicName `json:"topic"`    // The topic the rejected message was sent to.
+	Sequence uint64           `json:"sequence"` // The sequence number of the rejected message, if it had one.
+	Message  string           `json:"message"`  // Why the message was rejected.
+}
+
+// Validator is implemented by message bodies that need more checks than decoding.
+type Validator interface {
+	Validate() error
+}
+
+// Bodies maps each topic to the type of its message body.
+var Bodies = map[topics.TopicName]interface{}{
+	topics.Trigger:       models.Trigger{},
+	topics.Schedule:      models.Schedule{},
+	topics.NewMedia:      models.Media{},
+	topics.MediaFeatures: models.MediaAudioFeatures{},
+	topics.TriggerMode:   TriggerMode{},
+	topics.SetTrigger:    SetTrigger{},
+}
+
+// ErrorTopic returns the topic that errors about messages on the given topic are sent to.
+func ErrorTopic(topic topics.TopicName) topics.TopicName {
+	return topic + "/error"
+}
+
+// New wraps the body in an envelope.
+func New(gatewayID string, sequence uint64, body interface{}) (Envelope, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Version:   SchemaVersion,
+		GatewayID: gatewayID,
+		Sequence:  sequence,
+		SentAt:    time.Now().UnixNano() / int64(time.Millisecond),
+		Body:      b,
+	}, nil
+}
+
+// Decode unwraps the payload into the body, checking the envelope and body are valid.
+// The envelope is returned even if the body is invalid, so the sequence number can be used in an error reply.
+func Decode(payload []byte, body interface{}) (Envelope, error) {
+	var envelope Envelope
+	if err := strictUnmarshal(payload, &envelope); err != nil {
+		return envelope, fmt.Errorf("invalid envelope: %v", err)
+	}
+	if envelope.Version != SchemaVersion {
+		return envelope, fmt.Errorf("unsupported schema version %d, expected %d", envelope.Version, SchemaVersion)
+	}
+	if len(envelope.Body) == 0 {
+		return envelope, errors.New("missing body")
+	}
+	if err := strictUnmarshal(envelope.Body, body); err != nil {
+		return envelope, fmt.Errorf("invalid body: %v", err)
+	}
+	if validator, ok := body.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return envelope, err
+		}
+	}
+	return envelope, nil
+}
+
+// strictUnmarshal decodes JSON, rejecting any unknown fields.
+func strictUnmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
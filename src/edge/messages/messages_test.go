@@ -0,0 +1,104 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		valid   bool
+	}{
+		{"valid", `{"version":1,"gateway_id":"client","sequence":7,"sent_at":0,"body":{"trigger":"bar"}}`, true},
+		{"only version and body", `{"version":1,"body":{"trigger":"beat"}}`, true},
+		{"not json", `trigger bar`, false},
+		{"unknown envelope field", `{"version":1,"sequence":7,"body":{"trigger":"bar"},"extra":true}`, false},
+		{"missing version", `{"sequence":7,"body":{"trigger":"bar"}}`, false},
+		{"old version", `{"version":0,"sequence":7,"body":{"trigger":"bar"}}`, false},
+		{"newer version", `{"version":2,"sequence":7,"body":{"trigger":"bar"}}`, false},
+		{"version as a string", `{"version":"1","sequence":7,"body":{"trigger":"bar"}}`, false},
+		{"missing body", `{"version":1,"sequence":7}`, false},
+		{"body is a string", `{"version":1,"sequence":7,"body":"bar"}`, false},
+		{"body is a number", `{"version":1,"sequence":7,"body":1}`, false},
+		{"body is an array", `{"version":1,"sequence":7,"body":[{"trigger":"bar"}]}`, false},
+		{"unknown body field", `{"version":1,"sequence":7,"body":{"trigger":"bar","extra":true}}`, false},
+		{"trigger of the wrong type", `{"version":1,"sequence":7,"body":{"trigger":1}}`, false},
+		// These decode, but SetTrigger's Validate rejects them.
+		{"unsupported trigger", `{"version":1,"sequence":7,"body":{"trigger":"nope"}}`, false},
+		{"empty body", `{"version":1,"sequence":7,"body":{}}`, false},
+		{"null body", `{"version":1,"sequence":7,"body":null}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var command SetTrigger
+			_, err := Decode([]byte(test.payload), &command)
+			if (err == nil) != test.valid {
+				t.Fatalf("error = %v, want valid %v", err, test.valid)
+			}
+			if test.valid && !command.Trigger.Valid() {
+				t.Errorf("decoded trigger %q", command.Trigger)
+			}
+		})
+	}
+}
+
+func TestDecodeKeepsSequence(t *testing.T) {
+	// The sequence number of a rejected message is needed for the error reply.
+	tests := []string{
+		`{"version":2,"sequence":7,"body":{"trigger":"bar"}}`,
+		`{"version":1,"sequence":7}`,
+		`{"version":1,"sequence":7,"body":{"trigger":"bar","extra":true}}`,
+		`{"version":1,"sequence":7,"body":{"trigger":"nope"}}`,
+	}
+	for _, payload := range tests {
+		var command SetTrigger
+		envelope, err := Decode([]byte(payload), &command)
+		if err == nil {
+			t.Errorf("%s: decoded, want an error", payload)
+		}
+		if envelope.Sequence != 7 {
+			t.Errorf("%s: sequence = %d, want 7", payload, envelope.Sequence)
+		}
+	}
+}
+
+func TestNewDecodes(t *testing.T) {
+	envelope, err := New("gateway", 3, SetTrigger{Trigger: models.Bar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Version != SchemaVersion || envelope.GatewayID != "gateway" || envelope.Sequence != 3 {
+		t.Errorf("envelope = %+v", envelope)
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var command SetTrigger
+	if _, err := Decode(payload, &command); err != nil {
+		t.Fatal(err)
+	}
+	if command.Trigger != models.Bar {
+		t.Errorf("trigger = %q, want %q", command.Trigger, models.Bar)
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	want, err := SchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := ioutil.ReadFile("messages.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(committed, want) {
+		t.Error("messages.schema.json is out of date, run go generate ./edge/messages")
+	}
+}
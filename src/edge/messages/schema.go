@@ -0,0 +1,192 @@
+package messages
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// Schema returns a JSON Schema (draft-07) describing the envelope and the message of every topic.
+// Each topic has a definition named after it, e.g. #/definitions/new-media.
+func Schema() map[string]interface{} {
+	definitions := map[string]interface{}{
+		"envelope": typeSchema(reflect.TypeOf(Envelope{})),
+	}
+
+	topicNames := make([]string, 0, len(Bodies))
+	for topic, body := range Bodies {
+		topicNames = append(topicNames, string(topic))
+		definitions[string(topic)] = messageSchema(topic, body)
+		definitions[string(ErrorTopic(topic))] = messageSchema(ErrorTopic(topic), Error{})
+	}
+	for name, command := range Commands {
+		topic := topics.CommandTopic(name)
+		topicNames = append(topicNames, string(topic))
+		definitions[string(topic)] = messageSchema(topic, requestSchema(command.Params))
+		definitions[string(topic)+"/response"] = messageSchema(topics.Replies, responseSchema(command.Result))
+	}
+	sort.Strings(topicNames)
+
+	topicRefs := make([]interface{}, len(topicNames))
+	for i, topic := range topicNames {
+		topicRefs[i] = ref(topic)
+	}
+
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "https://github.com/tom-milner/LightBeat/messages.schema.json",
+		"title":       "LightBeat messages",
+		"description": "Messages sent between the LightBeat gateway and edge devices. Use the definition named after a topic to validate a message on that topic.",
+		"definitions": definitions,
+		"oneOf":       topicRefs,
+	}
+}
+
+// SchemaJSON returns the schema as it's written to messages.schema.json.
+func SchemaJSON() ([]byte, error) {
+	b, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// messageSchema describes an envelope containing the given body.
+// The body can either be a Go value to describe, or a schema.
+func messageSchema(topic topics.TopicName, body interface{}) map[string]interface{} {
+	bodySchema, isSchema := body.(map[string]interface{})
+	if !isSchema {
+		bodySchema = typeSchema(reflect.TypeOf(body))
+	}
+	return map[string]interface{}{
+		"description": "A message on the " + string(topic) + " topic.",
+		"allOf": []interface{}{
+			ref("envelope"),
+			map[string]interface{}{
+				"properties": map[string]interface{}{
+					"version": map[string]interface{}{"const": SchemaVersion},
+					"body":    bodySchema,
+				},
+			},
+		},
+	}
+}
+
+// requestSchema describes a request with the given parameters.
+func requestSchema(params interface{}) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Request{}))
+	schema["properties"].(map[string]interface{})["params"] = typeSchema(reflect.TypeOf(params))
+	return schema
+}
+
+// responseSchema describes a response with the given result.
+func responseSchema(result interface{}) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Response{}))
+	schema["properties"].(map[string]interface{})["result"] = typeSchema(reflect.TypeOf(result))
+	return schema
+}
+
+func ref(definition string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/definitions/" + definition}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// typeSchema describes a Go type the way encoding/json encodes it.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == rawMessageType {
+		// Raw JSON can be anything.
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return stringSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// enums holds the allowed values of string types that only have a few valid values.
+var enums = map[reflect.Type][]interface{}{
+	reflect.TypeOf(models.Beat): triggerTypeValues(),
+}
+
+func triggerTypeValues() []interface{} {
+	values := make([]interface{}, len(models.TriggerTypes))
+	for i, triggerType := range models.TriggerTypes {
+		values[i] = triggerType
+	}
+	return values
+}
+
+// stringSchema describes a string, restricting it to the allowed values if it's an enum.
+func stringSchema(t reflect.Type) map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	if values, exists := enums[t]; exists {
+		schema["enum"] = values
+	}
+	return schema
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// Unexported fields aren't encoded.
+			continue
+		}
+
+		name := field.Name
+		omitEmpty := false
+		if tag, exists := field.Tag.Lookup("json"); exists {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+
+		properties[name] = typeSchema(field.Type)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,244 @@
+package edge
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// maxFrameSize is the largest UDP payload a receiver reads.
+const maxFrameSize = 65535
+
+// sequenceWindow is how many recent sequence numbers receivers remember, to spot duplicates and late frames.
+const sequenceWindow = 64
+
+// sequenceRestart is how far the sequence number has to jump, either way, for receivers to treat it as the sender
+// restarting rather than frames being lost or late. Senders start from a random sequence number, so a restart is
+// almost certainly a jump this big.
+const sequenceRestart = 4096
+
+// MulticastTransport broadcasts trigger and schedule frames to every device on the LAN using UDP multicast.
+// This skips the broker hop and TCP head-of-line blocking of MQTT, at the cost of delivery guarantees. Frames are
+// numbered in order by the transport, so receivers can spot duplicates and lost frames. Numbering starts from a random
+// value, so receivers can tell when the transport has been restarted.
+type MulticastTransport struct {
+	conn       *net.UDPConn
+	redundancy int
+
+	mu       sync.Mutex
+	sequence uint32
+}
+
+// NewMulticastTransport creates a transport that sends frames to the multicast group address, e.g. 239.255.76.66:7676.
+// Each frame is sent redundancy times, to make it less likely to be lost.
+func NewMulticastTransport(address string, redundancy int) (*MulticastTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsMulticast() {
+		return nil, errors.New(address + " isn't a multicast address")
+	}
+	if redundancy < 1 {
+		redundancy = 1
+	}
+
+	var start [4]byte
+	if _, err := rand.Read(start[:]); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &MulticastTransport{conn: conn, redundancy: redundancy, sequence: binary.BigEndian.Uint32(start[:])}, nil
+}
+
+// Send broadcasts trigger and schedule messages as binary frames. Other topics are ignored.
+func (t *MulticastTransport) Send(topic topics.TopicName, envelope messages.Envelope, body interface{}) error {
+	if topic != topics.Trigger && topic != topics.Schedule {
+		return nil
+	}
+	frame, err := newFrame(envelope, body)
+	if err != nil {
+		return err
+	}
+
+	// The transport numbers its own frames, so receivers don't see gaps for messages it doesn't carry.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sequence++
+	frame.Sequence = t.sequence
+
+	b, err := frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < t.redundancy; i++ {
+		if _, err := t.conn.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the transport.
+func (t *MulticastTransport) Close() error {
+	return t.conn.Close()
+}
+
+// MulticastStats counts the frames seen by a MulticastReceiver.
+type MulticastStats struct {
+	Received   uint64 // Frames passed to the handler.
+	Duplicates uint64 // Frames dropped because they had already been received.
+	Lost       uint64 // Frames that never arrived.
+	Late       uint64 // Frames that arrived after a newer frame. These are still passed to the handler.
+	Stale      uint64 // Frames too old to tell whether they are duplicates. These are dropped.
+	Restarts   uint64 // Times a sender started counting again from a different sequence number.
+	Invalid    uint64 // Packets that weren't valid frames.
+}
+
+// senderState tracks the sequence numbers received from a single sender.
+type senderState struct {
+	base uint32 // The first sequence number received. Earlier frames were never counted as lost.
+	last uint32 // The highest sequence number received.
+	seen uint64 // Bit n is set if frame last-n has been received.
+}
+
+// MulticastReceiver receives frames sent by a MulticastTransport, dropping duplicates and counting lost frames.
+type MulticastReceiver struct {
+	conn    *net.UDPConn
+	handler func(Frame)
+
+	mu      sync.Mutex
+	senders map[string]*senderState
+	stats   MulticastStats
+}
+
+// ListenMulticast joins the multicast group address and calls the handler with every new frame received.
+// The interface name can be empty to use the system's default multicast interface.
+func ListenMulticast(address string, interfaceName string, handler func(Frame)) (*MulticastReceiver, error) {
+	addr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var iface *net.Interface
+	if interfaceName != "" {
+		if iface, err = net.InterfaceByName(interfaceName); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", iface, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(maxFrameSize)
+
+	r := &MulticastReceiver{
+		conn:    conn,
+		handler: handler,
+		senders: map[string]*senderState{},
+	}
+	go r.receive()
+	return r, nil
+}
+
+// Stats returns the counts of the frames received so far.
+func (r *MulticastReceiver) Stats() MulticastStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Close stops receiving frames.
+func (r *MulticastReceiver) Close() error {
+	return r.conn.Close()
+}
+
+func (r *MulticastReceiver) receive() {
+	buf := make([]byte, maxFrameSize)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection has been closed.
+			return
+		}
+
+		var frame Frame
+		if err := frame.UnmarshalBinary(buf[:n]); err != nil {
+			log.Printf("Invalid frame from %s: %v\n", from, err)
+			r.mu.Lock()
+			r.stats.Invalid++
+			r.mu.Unlock()
+			continue
+		}
+
+		if r.accept(from.String(), frame.Sequence) {
+			r.handler(frame)
+		}
+	}
+}
+
+// accept records the sequence number, returning false if the frame is a duplicate or too old.
+func (r *MulticastReceiver) accept(sender string, sequence uint32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, exists := r.senders[sender]
+	if !exists {
+		r.senders[sender] = &senderState{base: sequence, last: sequence, seen: 1}
+		r.stats.Received++
+		return true
+	}
+
+	// The difference is signed so sequence numbers can wrap around.
+	diff := int32(sequence - state.last)
+	switch {
+	case diff >= sequenceRestart || diff <= -sequenceRestart:
+		// The sender has restarted and is counting from somewhere else.
+		*state = senderState{base: sequence, last: sequence, seen: 1}
+		r.stats.Restarts++
+
+	case diff > 0:
+		// A newer frame. Anything skipped over is lost, unless it turns up late.
+		r.stats.Lost += uint64(diff - 1)
+		if diff >= sequenceWindow {
+			state.seen = 0
+		} else {
+			state.seen <<= uint(diff)
+		}
+		state.seen |= 1
+		state.last = sequence
+
+	case diff > -sequenceWindow:
+		// An older frame we can still remember.
+		bit := uint64(1) << uint(-diff)
+		if state.seen&bit != 0 {
+			r.stats.Duplicates++
+			return false
+		}
+		state.seen |= bit
+		// Frames from before the first one received were never counted as lost.
+		if int32(sequence-state.base) > 0 {
+			r.stats.Lost--
+		}
+		r.stats.Late++
+
+	default:
+		// Too old to know whether it has been received already.
+		r.stats.Stale++
+		return false
+	}
+
+	r.stats.Received++
+	return true
+}
@@ -0,0 +1,187 @@
+package edge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+const testMulticastAddress = "239.255.76.66:17676"
+
+func TestMulticastLoopback(t *testing.T) {
+	frames := make(chan Frame, 16)
+	receiver, err := ListenMulticast(testMulticastAddress, "", func(frame Frame) { frames <- frame })
+	if err != nil {
+		t.Skip("can't join multicast group:", err)
+	}
+	defer receiver.Close()
+
+	transport, err := NewMulticastTransport(testMulticastAddress, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { transport.Close() }()
+
+	// Only triggers and schedules are sent as frames.
+	sent := []interface{}{
+		models.Trigger{Number: 1, Duration: 500},
+		models.Schedule{Triggers: []models.ScheduledTrigger{{Number: 2, At: 500, Duration: 480}}},
+		models.Trigger{Number: 3, Duration: 520},
+	}
+	send := func(topic topics.TopicName, body interface{}) {
+		envelope, err := messages.New("gateway", 1, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transport.Send(topic, envelope, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(topics.Trigger, sent[0])
+	send(topics.NewMedia, models.Media{})
+	send(topics.Schedule, sent[1])
+	send(topics.Trigger, sent[2])
+
+	var sequence uint32
+	for i, body := range sent {
+		var frame Frame
+		select {
+		case frame = <-frames:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("frame %d not received", i)
+		}
+
+		if i > 0 && frame.Sequence != sequence+1 {
+			t.Errorf("frame %d has sequence %d, want %d", i, frame.Sequence, sequence+1)
+		}
+		sequence = frame.Sequence
+
+		switch b := body.(type) {
+		case models.Trigger:
+			if frame.Kind != TriggerFrame || frame.Trigger != b {
+				t.Errorf("frame %d = %+v, want trigger %+v", i, frame, b)
+			}
+		case models.Schedule:
+			if frame.Kind != ScheduleFrame || len(frame.Schedule.Triggers) != 1 || frame.Schedule.Triggers[0] != b.Triggers[0] {
+				t.Errorf("frame %d = %+v, want schedule %+v", i, frame, b)
+			}
+		}
+	}
+
+	// Each frame was sent three times, but the copies are dropped.
+	select {
+	case frame := <-frames:
+		t.Errorf("unexpected frame %+v", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+	stats := receiver.Stats()
+	if stats.Received != 3 || stats.Duplicates != 6 || stats.Lost != 0 {
+		t.Errorf("stats = %+v, want 3 received and 6 duplicates", stats)
+	}
+
+	// A restarted transport's frames aren't mistaken for duplicates.
+	transport.Close()
+	if transport, err = NewMulticastTransport(testMulticastAddress, 1); err != nil {
+		t.Fatal(err)
+	}
+	send(topics.Trigger, sent[0])
+	select {
+	case frame := <-frames:
+		if frame.Trigger != sent[0] {
+			t.Errorf("frame = %+v, want trigger %+v", frame, sent[0])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("frame from restarted transport not received")
+	}
+}
+
+func TestMulticastSequences(t *testing.T) {
+	tests := []struct {
+		name      string
+		sequences []uint32
+		accepted  []uint32
+		stats     MulticastStats
+	}{
+		{
+			name:      "in order",
+			sequences: []uint32{1, 2, 3},
+			accepted:  []uint32{1, 2, 3},
+			stats:     MulticastStats{Received: 3},
+		},
+		{
+			name:      "duplicates",
+			sequences: []uint32{1, 1, 2, 1, 2},
+			accepted:  []uint32{1, 2},
+			stats:     MulticastStats{Received: 2, Duplicates: 3},
+		},
+		{
+			name:      "lost",
+			sequences: []uint32{1, 4, 5},
+			accepted:  []uint32{1, 4, 5},
+			stats:     MulticastStats{Received: 3, Lost: 2},
+		},
+		{
+			name:      "reordered",
+			sequences: []uint32{1, 3, 2, 4, 2},
+			accepted:  []uint32{1, 3, 2, 4},
+			stats:     MulticastStats{Received: 4, Late: 1, Duplicates: 1},
+		},
+		{
+			name:      "reordered before the first frame",
+			sequences: []uint32{10, 9, 8, 11},
+			accepted:  []uint32{10, 9, 8, 11},
+			stats:     MulticastStats{Received: 4, Late: 2},
+		},
+		{
+			name:      "wraps around",
+			sequences: []uint32{1<<32 - 2, 1<<32 - 1, 0, 2, 1},
+			accepted:  []uint32{1<<32 - 2, 1<<32 - 1, 0, 2, 1},
+			stats:     MulticastStats{Received: 5, Late: 1},
+		},
+		{
+			name:      "too old",
+			sequences: []uint32{1, 100, 2},
+			accepted:  []uint32{1, 100},
+			stats:     MulticastStats{Received: 2, Lost: 98, Stale: 1},
+		},
+		{
+			name:      "restarted from a random sequence",
+			sequences: []uint32{1000, 1001, 3000000000, 3000000001, 1000},
+			accepted:  []uint32{1000, 1001, 3000000000, 3000000001, 1000},
+			stats:     MulticastStats{Received: 5, Restarts: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &MulticastReceiver{senders: map[string]*senderState{}}
+			var accepted []uint32
+			for _, sequence := range test.sequences {
+				if r.accept("sender", sequence) {
+					accepted = append(accepted, sequence)
+				}
+			}
+			if len(accepted) != len(test.accepted) {
+				t.Fatalf("accepted %v, want %v", accepted, test.accepted)
+			}
+			for i := range accepted {
+				if accepted[i] != test.accepted[i] {
+					t.Fatalf("accepted %v, want %v", accepted, test.accepted)
+				}
+			}
+			if stats := r.Stats(); stats != test.stats {
+				t.Errorf("stats = %+v, want %+v", stats, test.stats)
+			}
+		})
+	}
+}
+
+func TestMulticastSendersAreSeparate(t *testing.T) {
+	r := &MulticastReceiver{senders: map[string]*senderState{}}
+	if !r.accept("a", 1) || !r.accept("b", 1) || r.accept("a", 1) {
+		t.Error("senders share sequence numbers")
+	}
+}
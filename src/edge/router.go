@@ -0,0 +1,145 @@
+package edge
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// route holds all the handlers registered for a single topic filter.
+type route struct {
+	filter   topics.TopicName
+	handlers []MessageHandler
+}
+
+// router dispatches received messages to every handler whose topic filter matches the message topic.
+// It is safe to use from the MQTT client callbacks and the rest of the gateway at the same time.
+type router struct {
+	mu       sync.RWMutex
+	routes   []*route
+	fallback MessageHandler
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// add registers a handler for the topic filter. It returns true if this is the first handler for the filter.
+func (r *router) add(filter topics.TopicName, handler MessageHandler) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routes {
+		if rt.filter == filter {
+			rt.handlers = append(rt.handlers, handler)
+			return false
+		}
+	}
+	r.routes = append(r.routes, &route{filter: filter, handlers: []MessageHandler{handler}})
+	return true
+}
+
+// setFallback sets the handler called for messages that no other handler matches.
+func (r *router) setFallback(handler MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+// filters returns all the topic filters that have handlers.
+func (r *router) filters() []topics.TopicName {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filters := make([]topics.TopicName, len(r.routes))
+	for i, rt := range r.routes {
+		filters[i] = rt.filter
+	}
+	return filters
+}
+
+// match returns all the handlers for the topic, or the fallback handler if there are none.
+func (r *router) match(topic string) []MessageHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var handlers []MessageHandler
+	for _, rt := range r.routes {
+		if topics.Match(string(rt.filter), topic) {
+			handlers = append(handlers, rt.handlers...)
+		}
+	}
+	if len(handlers) == 0 && r.fallback != nil {
+		handlers = append(handlers, r.fallback)
+	}
+	return handlers
+}
+
+// handlersFor returns the handlers registered for exactly the topic filter.
+func (r *router) handlersFor(filter topics.TopicName) []MessageHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rt := range r.routes {
+		if rt.filter == filter {
+			return append([]MessageHandler(nil), rt.handlers...)
+		}
+	}
+	return nil
+}
+
+// dispatch calls every handler matching the message topic.
+func (r *router) dispatch(msg EdgeMessage) {
+	handlers := r.match(msg.Topic())
+	if len(handlers) == 0 {
+		log.Printf("No handler for topic: %s\n", msg.Topic())
+		return
+	}
+	callHandlers(handlers, msg)
+}
+
+// dispatchFilter calls the handlers of the topic filter only. The MQTT client calls the callback of every
+// subscription that matches a message, so each subscription must only call its own handlers, or handlers of
+// overlapping filters would be called once for every match.
+func (r *router) dispatchFilter(filter topics.TopicName, msg EdgeMessage) {
+	callHandlers(r.handlersFor(filter), msg)
+}
+
+// callHandlers calls the handlers outside the lock, so they can register more handlers.
+func callHandlers(handlers []MessageHandler, msg EdgeMessage) {
+	for _, handler := range handlers {
+		callHandler(handler, msg)
+	}
+}
+
+// callHandler calls the handler, recovering from any panic so one broken handler can't take down the others.
+func callHandler(handler MessageHandler, msg EdgeMessage) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Handler for topic %s panicked: %v\n%s", msg.Topic(), err, debug.Stack())
+		}
+	}()
+	handler(msg)
+}
+
+// localMessage is a message that arrived some other way than MQTT, such as over a websocket.
+type localMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m localMessage) Duplicate() bool   { return false }
+func (m localMessage) Qos() byte         { return 0 }
+func (m localMessage) Retained() bool    { return false }
+func (m localMessage) Topic() string     { return m.topic }
+func (m localMessage) MessageID() uint16 { return 0 }
+func (m localMessage) Payload() []byte   { return m.payload }
+func (m localMessage) Ack()              {}
+
+// Dispatch passes a message received by something other than the MQTT client to the handlers of its topic, as if
+// it had arrived over MQTT.
+func Dispatch(topic topics.TopicName, payload []byte) {
+	messageRouter.dispatch(localMessage{topic: string(topic), payload: payload})
+}
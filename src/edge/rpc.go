@@ -0,0 +1,139 @@
+package edge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// ErrTimeout is returned by Call when no response arrives in time.
+var ErrTimeout = errors.New("timed out waiting for a response")
+
+// CommandHandler runs a command, returning its result or why it failed.
+// The params should be decoded with messages.DecodeParams.
+type CommandHandler func(params json.RawMessage) (interface{}, error)
+
+// HandleCommand registers the handler for the named command. Requests are received on command/<name>, and the
+// response is sent to the request's reply topic.
+func HandleCommand(name string, handler CommandHandler) error {
+	topic := topics.CommandTopic(name)
+	return OnReceive(topic, func(msg EdgeMessage) {
+		var request messages.Request
+		envelope, err := messages.Decode(msg.Payload(), &request)
+		if err != nil {
+			// Without a valid request there's nowhere to reply to, so fall back to the error topic.
+			log.Printf("Rejected %s: %v\n", topic, err)
+			go SendError(topic, envelope.Sequence, err)
+			return
+		}
+
+		// Run the command outside the MQTT client's callback, so it can send messages of its own.
+		go func() {
+			response := messages.Response{ID: request.ID}
+			result, err := handler(request.Params)
+			if err == nil {
+				response.Result, err = json.Marshal(result)
+			}
+			if err != nil {
+				response.Error = err.Error()
+			} else {
+				response.OK = true
+			}
+			SendMessage(topics.TopicName(request.ReplyTo), response)
+		}()
+	})
+}
+
+// pendingCalls holds a channel for each call waiting for a response, by request ID.
+var pendingCalls = map[string]chan messages.Response{}
+var pendingMu sync.Mutex
+
+// replyTopic is where responses to this gateway's calls are sent.
+var replyTopic topics.TopicName
+var replyOnce sync.Once
+var replyErr error
+
+// Call sends the named command and waits for its response, decoding the result into result.
+// If the command fails, its error is returned.
+func Call(name string, params interface{}, result interface{}, timeout time.Duration) error {
+	replyOnce.Do(func() {
+		replyTopic = topics.Replies + "/" + topics.TopicName(gatewayID)
+		replyErr = OnReceive(replyTopic, onReply)
+	})
+	if replyErr != nil {
+		return replyErr
+	}
+
+	id, err := newRequestID()
+	if err != nil {
+		return err
+	}
+	request := messages.Request{ID: id, ReplyTo: string(replyTopic)}
+	if params != nil {
+		if request.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+
+	responses := make(chan messages.Response, 1)
+	pendingMu.Lock()
+	pendingCalls[id] = responses
+	pendingMu.Unlock()
+	defer func() {
+		pendingMu.Lock()
+		delete(pendingCalls, id)
+		pendingMu.Unlock()
+	}()
+
+	SendMessage(topics.CommandTopic(name), request)
+
+	select {
+	case response := <-responses:
+		if !response.OK {
+			return errors.New(response.Error)
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(response.Result, result)
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
+// onReply passes a response to the call waiting for it.
+func onReply(msg EdgeMessage) {
+	var response messages.Response
+	if _, err := messages.Decode(msg.Payload(), &response); err != nil {
+		log.Println("Invalid response:", err)
+		return
+	}
+
+	pendingMu.Lock()
+	responses, exists := pendingCalls[response.ID]
+	pendingMu.Unlock()
+	if !exists {
+		log.Println("Response to unknown or timed out request:", response.ID)
+		return
+	}
+	select {
+	case responses <- response:
+	default:
+		log.Println("Duplicate response to request:", response.ID)
+	}
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
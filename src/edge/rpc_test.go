@@ -0,0 +1,239 @@
+package edge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// echo is a command handler that returns its params.
+func echo(params json.RawMessage) (interface{}, error) {
+	var value interface{}
+	if err := messages.DecodeParams(params, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// payload wraps the body in an envelope, as a device would send it.
+func payload(t *testing.T, body interface{}) []byte {
+	t.Helper()
+	envelope, err := messages.New("device", 7, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCall(t *testing.T) {
+	startBroker(t)
+	if err := HandleCommand("echo", echo); err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+	if err := Call("echo", "hello", &result, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if result != "hello" {
+		t.Errorf("result = %q, want hello", result)
+	}
+
+	// The result can be ignored.
+	if err := Call("echo", "hello", nil, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallReturnsHandlerErrors(t *testing.T) {
+	startBroker(t)
+	if err := HandleCommand("fail", func(params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("lights are on fire")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Results that can't be encoded fail the command too.
+	if err := HandleCommand("unencodable", func(params json.RawMessage) (interface{}, error) {
+		return func() {}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+	err := Call("fail", nil, &result, 2*time.Second)
+	if err == nil || err.Error() != "lights are on fire" {
+		t.Errorf("error = %v, want the handler's error", err)
+	}
+	if err := Call("unencodable", nil, &result, 2*time.Second); err == nil || err == ErrTimeout {
+		t.Errorf("error = %v, want the encoding error", err)
+	}
+}
+
+func TestCallTimesOut(t *testing.T) {
+	startBroker(t)
+
+	start := time.Now()
+	if err := Call("nobody-handles-this", nil, nil, 100*time.Millisecond); err != ErrTimeout {
+		t.Errorf("error = %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed out after %v, want 100ms", elapsed)
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if len(pendingCalls) != 0 {
+		t.Errorf("%d calls still pending after timing out", len(pendingCalls))
+	}
+}
+
+func TestCallsGetTheirOwnResponses(t *testing.T) {
+	startBroker(t)
+	// Later calls finish first, so the responses arrive in the opposite order to the requests.
+	if err := HandleCommand("sleep", func(params json.RawMessage) (interface{}, error) {
+		var n int
+		if err := messages.DecodeParams(params, &n); err != nil {
+			return nil, err
+		}
+		time.Sleep(time.Duration(5-n) * 20 * time.Millisecond)
+		return n, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var result int
+			if err := Call("sleep", n, &result, 2*time.Second); err != nil {
+				errs <- err
+			} else if result != n {
+				errs <- fmt.Errorf("call %d got the response to call %d", n, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLateResponsesAreDropped(t *testing.T) {
+	startBroker(t)
+	slow := make(chan struct{})
+	if err := HandleCommand("slow", func(params json.RawMessage) (interface{}, error) {
+		<-slow
+		return "late", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleCommand("echo", echo); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Call("slow", nil, nil, 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("error = %v, want %v", err, ErrTimeout)
+	}
+	// The response to the timed out call arrives while the next call is waiting.
+	close(slow)
+	var result string
+	if err := Call("echo", "on time", &result, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if result != "on time" {
+		t.Errorf("result = %q, want the response to this call", result)
+	}
+}
+
+func TestOnReplyIgnoresUnknownAndCompletedCalls(t *testing.T) {
+	responses := make(chan messages.Response, 1)
+	pendingMu.Lock()
+	pendingCalls["waiting"] = responses
+	pendingMu.Unlock()
+	t.Cleanup(func() {
+		pendingMu.Lock()
+		delete(pendingCalls, "waiting")
+		pendingMu.Unlock()
+	})
+
+	reply := func(response messages.Response) {
+		onReply(localMessage{topic: "reply/edge-test", payload: payload(t, response)})
+	}
+	reply(messages.Response{ID: "unknown", OK: true})
+	reply(messages.Response{ID: "waiting", OK: true, Result: json.RawMessage(`"first"`)})
+	// Duplicates of a completed response are dropped rather than blocking.
+	reply(messages.Response{ID: "waiting", OK: true, Result: json.RawMessage(`"second"`)})
+	// Invalid responses are dropped.
+	onReply(localMessage{topic: "reply/edge-test", payload: []byte(`{"version":1,"body":{"id":"waiting","extra":1}}`)})
+
+	if response := <-responses; string(response.Result) != `"first"` {
+		t.Errorf("result = %s, want the first response", response.Result)
+	}
+	select {
+	case response := <-responses:
+		t.Errorf("received %+v, want only the first response", response)
+	default:
+	}
+}
+
+func TestInvalidRequestsAreRejected(t *testing.T) {
+	startBroker(t)
+	called := make(chan struct{}, 10)
+	if err := HandleCommand("echo", func(params json.RawMessage) (interface{}, error) {
+		called <- struct{}{}
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errorTopic := messages.ErrorTopic(topics.CommandTopic("echo"))
+	errorMessages := received(t, string(errorTopic))
+
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"not json", []byte("echo")},
+		{"missing id", payload(t, messages.Request{ReplyTo: "reply/device"})},
+		{"reply outside reply/", payload(t, messages.Request{ID: "1", ReplyTo: "trigger-mode"})},
+		{"unknown field", payload(t, map[string]string{"id": "1", "reply_to": "reply/device", "extra": "x"})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := client.Publish(string(topics.CommandTopic("echo")), 1, false, test.payload)
+			token.Wait()
+			if err := token.Error(); err != nil {
+				t.Fatal(err)
+			}
+
+			msg := nextMessage(errorMessages, 2*time.Second)
+			if msg == nil {
+				t.Fatal("no error reply sent")
+			}
+			var reply messages.Error
+			if _, err := messages.Decode(msg.Payload(), &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Topic != topics.CommandTopic("echo") || reply.Message == "" {
+				t.Errorf("error reply = %+v", reply)
+			}
+		})
+	}
+	select {
+	case <-called:
+		t.Error("handler called with an invalid request")
+	default:
+	}
+}
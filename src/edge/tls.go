@@ -0,0 +1,54 @@
+package edge
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// MQTTTLSInfo holds the certificates used to secure the connection to an MQTT broker.
+// All the fields are optional - with none set, the system's root certificates are used to verify the broker.
+type MQTTTLSInfo struct {
+	CACertFile         string // PEM file of the CA(s) that signed the broker's certificate.
+	ClientCertFile     string // PEM file of the certificate to identify this client with.
+	ClientKeyFile      string // PEM file of the private key of the client certificate.
+	ServerName         string // The name to verify the broker's certificate against, if it isn't the broker address.
+	InsecureSkipVerify bool   // Don't verify the broker's certificate. Only use this for testing!
+}
+
+// newTLSConfig builds the TLS config described by the info.
+func newTLSConfig(info MQTTTLSInfo) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         info.ServerName,
+		InsecureSkipVerify: info.InsecureSkipVerify,
+	}
+
+	// Trust the given CA instead of the system roots.
+	if info.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(info.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no certificates found in " + info.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	// The client certificate and key only make sense together.
+	if (info.ClientCertFile == "") != (info.ClientKeyFile == "") {
+		return nil, errors.New("both a client certificate and key are needed for client authentication")
+	}
+	if info.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(info.ClientCertFile, info.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,365 @@
+package edge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge/broker"
+)
+
+// testCert is a generated certificate, and the files it's written to.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert generates a certificate signed by the parent, or a self-signed CA if the parent is nil, and writes it to
+// PEM files in the directory.
+func newTestCert(t *testing.T, dir string, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writeFile(t, c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return c
+}
+
+func writeFile(t *testing.T, file string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client with the config to a server that requires a client certificate signed by the CA. It
+// returns the error the server saw.
+func handshake(t *testing.T, ca *testCert, server *testCert, config *tls.Config) error {
+	t.Helper()
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := tls.Client(conn, config)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	client.Handshake()
+	return <-serverErr
+}
+
+func TestNewTLSConfigMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "broker", ca)
+	client := newTestCert(t, dir, "gateway", ca)
+
+	config, err := newTLSConfig(MQTTTLSInfo{
+		CACertFile:     ca.certFile,
+		ClientCertFile: client.certFile,
+		ClientKeyFile:  client.keyFile,
+		ServerName:     "broker",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %x, want TLS 1.2", config.MinVersion)
+	}
+	if len(config.Certificates) != 1 {
+		t.Fatalf("got %d client certificates, want 1", len(config.Certificates))
+	}
+	if err := handshake(t, ca, server, config); err != nil {
+		t.Errorf("mutual TLS handshake failed: %v", err)
+	}
+
+	// Without the client certificate, the broker refuses the connection.
+	config.Certificates = nil
+	if err := handshake(t, ca, server, config); err == nil {
+		t.Error("handshake without a client certificate succeeded")
+	}
+}
+
+func TestNewTLSConfigVerifiesBroker(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	otherCA := newTestCert(t, dir, "other-ca", nil)
+	server := newTestCert(t, dir, "broker", ca)
+	client := newTestCert(t, dir, "gateway", ca)
+
+	// A broker signed by a CA that isn't trusted is rejected by the client, so the handshake fails.
+	config, err := newTLSConfig(MQTTTLSInfo{
+		CACertFile:     otherCA.certFile,
+		ClientCertFile: client.certFile,
+		ClientKeyFile:  client.keyFile,
+		ServerName:     "broker",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, ca, server, config); err == nil {
+		t.Error("handshake with an untrusted broker succeeded")
+	}
+
+	// As is a broker with the wrong name.
+	config.RootCAs = x509.NewCertPool()
+	config.RootCAs.AddCert(ca.cert)
+	config.ServerName = "somewhere-else"
+	if err := handshake(t, ca, server, config); err == nil {
+		t.Error("handshake with the wrong broker name succeeded")
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	client := newTestCert(t, dir, "gateway", ca)
+	other := newTestCert(t, dir, "other", ca)
+	notPEM := filepath.Join(dir, "not-pem.crt")
+	writeFile(t, notPEM, []byte("not a certificate"))
+
+	tests := []struct {
+		name string
+		info MQTTTLSInfo
+		want string
+	}{
+		{"missing CA", MQTTTLSInfo{CACertFile: filepath.Join(dir, "missing.crt")}, "no such file"},
+		{"CA without certificates", MQTTTLSInfo{CACertFile: notPEM}, "no certificates found"},
+		{"certificate without key", MQTTTLSInfo{ClientCertFile: client.certFile}, "both a client certificate and key"},
+		{"key without certificate", MQTTTLSInfo{ClientKeyFile: client.keyFile}, "both a client certificate and key"},
+		{"missing certificate", MQTTTLSInfo{ClientCertFile: filepath.Join(dir, "missing.crt"), ClientKeyFile: client.keyFile}, "no such file"},
+		{"mismatched key", MQTTTLSInfo{ClientCertFile: client.certFile, ClientKeyFile: other.keyFile}, "private key does not match"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newTLSConfig(test.info)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+// startTLSBroker starts an embedded broker with the config behind a TLS listener on loopback, as a broker such as
+// Mosquitto would be with TLS enabled. It returns the port and the CA that signed the broker's certificate for
+// localhost.
+func startTLSBroker(t *testing.T, config broker.Config) (string, *testCert) {
+	t.Helper()
+	b := broker.New(config)
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "localhost", ca)
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// Pass the decrypted connections through to the broker.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", b.Addr().String())
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				conn.Close()
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port, ca
+}
+
+func TestConnectOverTLS(t *testing.T) {
+	port, ca := startTLSBroker(t, broker.Config{Address: "127.0.0.1:0", Username: "gateway", Password: "secret"})
+	info := MQTTConnInfo{
+		Username: "gateway",
+		Password: "secret",
+		ClientID: "tls-test",
+		Broker:   MQTTBroker{Scheme: "ssl", Address: "localhost", Port: port},
+		TLS:      MQTTTLSInfo{CACertFile: ca.certFile},
+	}
+	if url, _ := info.Broker.URL(); url != "ssl://localhost:"+port {
+		t.Fatalf("broker URL = %s, want ssl://localhost:%s", url, port)
+	}
+	c, err := ConnectToMQTTBroker(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect(100)
+
+	// Messages go both ways over the encrypted connection.
+	received := make(chan string, 1)
+	if err := OnReceive("tls/test", func(msg EdgeMessage) { received <- string(msg.Payload()) }); err != nil {
+		t.Fatal(err)
+	}
+	if token := c.Publish("tls/test", 0, false, "hello"); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	select {
+	case payload := <-received:
+		if payload != "hello" {
+			t.Errorf("received %q, want hello", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no message received over TLS")
+	}
+}
+
+func TestConnectOverTLSRejected(t *testing.T) {
+	port, ca := startTLSBroker(t, broker.Config{Address: "127.0.0.1:0", Username: "gateway", Password: "secret"})
+	otherCA := newTestCert(t, t.TempDir(), "other-ca", nil)
+	valid := MQTTConnInfo{
+		Username: "gateway",
+		Password: "secret",
+		ClientID: "tls-test",
+		Broker:   MQTTBroker{Scheme: "ssl", Address: "localhost", Port: port},
+		TLS:      MQTTTLSInfo{CACertFile: ca.certFile},
+	}
+
+	tests := []struct {
+		name   string
+		change func(info *MQTTConnInfo)
+	}{
+		{"wrong password", func(info *MQTTConnInfo) { info.Password = "guess" }},
+		{"no credentials", func(info *MQTTConnInfo) { info.Username, info.Password = "", "" }},
+		{"untrusted broker", func(info *MQTTConnInfo) { info.TLS.CACertFile = otherCA.certFile }},
+		{"wrong broker name", func(info *MQTTConnInfo) { info.TLS.ServerName = "somewhere-else" }},
+		{"plain tcp", func(info *MQTTConnInfo) { info.Broker.Scheme = "tcp" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := valid
+			test.change(&info)
+			c, err := ConnectToMQTTBroker(info)
+			if err == nil {
+				c.Disconnect(100)
+				t.Error("connected")
+			}
+		})
+	}
+}
+
+func TestBrokerURL(t *testing.T) {
+	tests := []struct {
+		broker MQTTBroker
+		want   string
+		tls    bool
+	}{
+		{MQTTBroker{Address: "broker", Port: "1883"}, "tcp://broker:1883", false},
+		{MQTTBroker{Scheme: "SSL", Address: "broker", Port: "8883"}, "ssl://broker:8883", true},
+		{MQTTBroker{Scheme: "ws", Address: "broker", Port: "80", Path: "mqtt"}, "ws://broker:80/mqtt", false},
+		{MQTTBroker{Scheme: "wss", Address: "broker", Port: "443", Path: "/mqtt"}, "wss://broker:443/mqtt", true},
+	}
+	for _, test := range tests {
+		url, err := test.broker.URL()
+		if err != nil || url != test.want {
+			t.Errorf("%+v: URL = %s, %v, want %s", test.broker, url, err, test.want)
+		}
+		if test.broker.usesTLS() != test.tls {
+			t.Errorf("%+v: uses TLS = %v, want %v", test.broker, !test.tls, test.tls)
+		}
+	}
+	if _, err := (MQTTBroker{Scheme: "http", Address: "broker", Port: "80"}).URL(); err == nil {
+		t.Error("accepted an http broker")
+	}
+}
@@ -0,0 +1,85 @@
+package topics
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	separator      = "/"
+	singleWildcard = "+"
+	multiWildcard  = "#"
+)
+
+// ValidateFilter checks that a topic filter uses the MQTT wildcards correctly.
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic filter must not be empty")
+	}
+	levels := strings.Split(filter, separator)
+	for i, level := range levels {
+		// Wildcards must occupy a whole level.
+		if level != multiWildcard && strings.Contains(level, multiWildcard) {
+			return errors.New("invalid use of # in topic filter: " + filter)
+		}
+		if level != singleWildcard && strings.Contains(level, singleWildcard) {
+			return errors.New("invalid use of + in topic filter: " + filter)
+		}
+
+		// The multi-level wildcard must be the last level.
+		if level == multiWildcard && i != len(levels)-1 {
+			return errors.New("# must be the last level of topic filter: " + filter)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the topic matches the filter, using the MQTT + (single level) and # (multi level) wildcards.
+func Match(filter string, topic string) bool {
+	// Wildcards at the first level don't match system topics, such as $SYS.
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, singleWildcard) || strings.HasPrefix(filter, multiWildcard)) {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, separator)
+	topicLevels := strings.Split(topic, separator)
+
+	for i, level := range filterLevels {
+		if level == multiWildcard {
+			// # also matches the parent level, so "a/#" matches "a".
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != singleWildcard && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+// ValidateTopic checks that a topic can be published to. Unlike filters, topics can't contain wildcards.
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic must not be empty")
+	}
+	if strings.ContainsAny(topic, singleWildcard+multiWildcard) {
+		return errors.New("topic must not contain wildcards: " + topic)
+	}
+	return nil
+}
+
+// ValidateReplyTopic checks that a topic can be replied to, which is only allowed under reply/, e.g. reply/<client id>.
+// Otherwise callers could make the gateway publish to any topic.
+func ValidateReplyTopic(topic string) error {
+	if err := ValidateTopic(topic); err != nil {
+		return err
+	}
+	prefix := string(Replies) + separator
+	if !strings.HasPrefix(topic, prefix) || len(topic) == len(prefix) {
+		return errors.New("reply topic must be under " + prefix + ": " + topic)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package topics
+
+import "testing"
+
+func TestValidateFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"trigger", true},
+		{"+/set-trigger", true},
+		{"command/+/response", true},
+		{"command/+", true},
+		{"+", true},
+		{"#", true},
+		{"+/#", true},
+		{"command/#", true},
+		{"$SYS/#", true},
+		// Empty levels are allowed.
+		{"/trigger", true},
+		{"trigger/", true},
+		{"command//response", true},
+		{"/", true},
+		{"", false},
+		{"command/#/response", false},
+		{"#/trigger", false},
+		{"command#", false},
+		{"command/set#", false},
+		{"command+", false},
+		{"command/+set", false},
+		{"++", false},
+		{"##", false},
+	}
+	for _, test := range tests {
+		if err := ValidateFilter(test.filter); (err == nil) != test.valid {
+			t.Errorf("ValidateFilter(%q) error = %v, want valid %v", test.filter, err, test.valid)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"trigger", "trigger", true},
+		{"trigger", "trigger-mode", false},
+		{"trigger", "trigger/stage", false},
+		{"trigger/stage", "trigger", false},
+
+		// + at the start, middle and end matches exactly one level.
+		{"+/set-trigger", "gateway/set-trigger", true},
+		{"+/set-trigger", "set-trigger", false},
+		{"+/set-trigger", "a/b/set-trigger", false},
+		{"command/+/response", "command/set-trigger/response", true},
+		{"command/+/response", "command/response", false},
+		{"command/+/response", "command/a/b/response", false},
+		{"command/+", "command/set-trigger", true},
+		{"command/+", "command", false},
+		{"command/+", "command/set-trigger/response", false},
+		{"+", "trigger", true},
+		{"+/+", "command/set-trigger", true},
+
+		// # matches any number of levels, including none.
+		{"command/#", "command/set-trigger", true},
+		{"command/#", "command/set-trigger/response", true},
+		{"command/#", "command", true},
+		{"command/#", "commands", false},
+		{"command/+/#", "command/set-trigger", true},
+		{"command/+/#", "command", false},
+		{"#", "trigger", true},
+		{"#", "command/set-trigger", true},
+
+		// Empty levels are levels like any other.
+		{"command/+/response", "command//response", true},
+		{"+", "", true},
+		{"+/+", "/", true},
+		{"/trigger", "/trigger", true},
+		{"/trigger", "trigger", false},
+		{"+/trigger", "/trigger", true},
+		{"trigger/#", "trigger/", true},
+
+		// Wildcards at the first level don't match topics starting with $.
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+		{"$SYS/+/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/broker/uptime", "$SYS/broker/uptime", true},
+		{"trigger/#", "trigger/$stage", true},
+	}
+	for _, test := range tests {
+		if got := Match(test.filter, test.topic); got != test.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", test.filter, test.topic, got, test.want)
+		}
+	}
+}
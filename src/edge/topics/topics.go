@@ -1,10 +1,67 @@
 package topics
 
+import (
+	"strings"
+	"time"
+)
+
 type TopicName string
 
 const (
 	Trigger       TopicName = "trigger"
+	Schedule      TopicName = "schedule"
 	NewMedia      TopicName = "new-media"
 	MediaFeatures TopicName = "media-features"
+	TriggerMode   TopicName = "trigger-mode"
 	SetTrigger    TopicName = "set-trigger"
+	Commands      TopicName = "command"
+	Replies       TopicName = "reply"
 )
+
+// Policy describes how messages are published to a topic.
+type Policy struct {
+	QoS      byte          // The MQTT quality of service to publish with.
+	Retained bool          // Whether the broker keeps the last message for devices that subscribe later.
+	Expiry   time.Duration // How long a retained message is kept for. Zero keeps it until it's replaced.
+}
+
+// DefaultPolicy is used for any topic without its own policy.
+var DefaultPolicy = Policy{QoS: 0}
+
+// policies holds the publish policy of each topic.
+// The media and trigger mode topics are retained so that devices that join mid-song are immediately in sync.
+var policies = map[TopicName]Policy{
+	Trigger:       {QoS: 0},
+	Schedule:      {QoS: 1},
+	NewMedia:      {QoS: 1, Retained: true, Expiry: time.Hour},
+	MediaFeatures: {QoS: 1, Retained: true, Expiry: time.Hour},
+	TriggerMode:   {QoS: 1, Retained: true},
+	SetTrigger:    {QoS: 1},
+	Commands:      {QoS: 1},
+	Replies:       {QoS: 1},
+}
+
+// CommandTopic returns the topic the named command is sent to.
+func CommandTopic(name string) TopicName {
+	return Commands + "/" + TopicName(name)
+}
+
+// GroupTopic returns the topic that a group of devices receives messages for the given topic on.
+func GroupTopic(topic TopicName, group string) TopicName {
+	return topic + "/" + TopicName(group)
+}
+
+// PolicyFor returns the publish policy of the topic.
+// Topics without their own policy use the policy of their parent, so trigger/stage is published like trigger.
+func PolicyFor(topic TopicName) Policy {
+	for {
+		if policy, exists := policies[topic]; exists {
+			return policy
+		}
+		parent := strings.LastIndex(string(topic), separator)
+		if parent < 0 {
+			return DefaultPolicy
+		}
+		topic = topic[:parent]
+	}
+}
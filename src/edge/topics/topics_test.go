@@ -0,0 +1,42 @@
+package topics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyFor(t *testing.T) {
+	tests := []struct {
+		topic TopicName
+		want  Policy
+	}{
+		{Trigger, Policy{QoS: 0}},
+		{NewMedia, Policy{QoS: 1, Retained: true, Expiry: time.Hour}},
+		{TriggerMode, Policy{QoS: 1, Retained: true}},
+
+		// Topics without their own policy use their parent's.
+		{GroupTopic(Trigger, "stage"), Policy{QoS: 0}},
+		{GroupTopic(Schedule, "stage"), Policy{QoS: 1}},
+		{GroupTopic(NewMedia, "stage"), Policy{QoS: 1, Retained: true, Expiry: time.Hour}},
+		{CommandTopic("set-trigger"), Policy{QoS: 1}},
+		{"reply/browser/42", Policy{QoS: 1}},
+		{"new-media/stage/extra", Policy{QoS: 1, Retained: true, Expiry: time.Hour}},
+
+		// Filters use the policy of the levels before their wildcards.
+		{"command/+", Policy{QoS: 1}},
+		{"command/#", Policy{QoS: 1}},
+		{"+/set-trigger", DefaultPolicy},
+		{"#", DefaultPolicy},
+
+		// Only whole levels are parents.
+		{"new-media-extra", DefaultPolicy},
+		{"unknown", DefaultPolicy},
+		{"unknown/new-media", DefaultPolicy},
+		{"", DefaultPolicy},
+	}
+	for _, test := range tests {
+		if got := PolicyFor(test.topic); got != test.want {
+			t.Errorf("PolicyFor(%q) = %+v, want %+v", test.topic, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package edge
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+// Transport carries messages from the gateway to the edge devices.
+type Transport interface {
+	// Send delivers a message to the devices. Each transport chooses how to encode the message, and can ignore topics
+	// it doesn't carry.
+	Send(topic topics.TopicName, envelope messages.Envelope, body interface{}) error
+
+	// Close stops the transport.
+	Close() error
+}
+
+// transports holds every transport messages are sent over, by name.
+var transports = map[string]Transport{}
+var transportsMu sync.RWMutex
+
+// AddTransport sends every message over the transport as well as the existing transports.
+// A transport with the same name as an existing one replaces it.
+func AddTransport(name string, transport Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = transport
+}
+
+// RemoveTransport stops sending messages over the named transport, and closes it.
+func RemoveTransport(name string) error {
+	transportsMu.Lock()
+	transport, exists := transports[name]
+	delete(transports, name)
+	transportsMu.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return transport.Close()
+}
+
+// sendToTransports sends the message over every transport.
+func sendToTransports(topic topics.TopicName, envelope messages.Envelope, body interface{}) {
+	transportsMu.RLock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selected := make([]Transport, len(names))
+	for i, name := range names {
+		selected[i] = transports[name]
+	}
+	transportsMu.RUnlock()
+
+	for i, transport := range selected {
+		if err := transport.Send(topic, envelope, body); err != nil {
+			log.Printf("Failed to send %s over %s: %v\n", topic, names[i], err)
+		}
+	}
+}
+
+// mqttTransport sends messages to the MQTT broker as JSON, and to each device group in the group's encoding.
+type mqttTransport struct{}
+
+func (mqttTransport) Send(topic topics.TopicName, envelope messages.Envelope, body interface{}) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if err := publish(topic, payload); err != nil {
+		return err
+	}
+	if topic == topics.Trigger || topic == topics.Schedule {
+		return publishToGroups(topic, envelope, body, payload)
+	}
+	return nil
+}
+
+func (mqttTransport) Close() error {
+	client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,319 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+)
+
+const (
+	// wsSendBuffer is how many events can be queued for a browser before it's considered too slow and dropped.
+	wsSendBuffer = 64
+
+	// wsWriteTimeout is how long a browser has to accept an event.
+	wsWriteTimeout = 5 * time.Second
+
+	// wsPingInterval is how often browsers are pinged to check they're still there.
+	wsPingInterval = 30 * time.Second
+
+	// wsMaxMessageSize is the largest message a browser can send.
+	wsMaxMessageSize = 64 * 1024
+)
+
+// WebSocketEvent is a single message sent to or from a browser over the websocket.
+type WebSocketEvent struct {
+	Topic   topics.TopicName `json:"topic"`
+	Message json.RawMessage  `json:"message"` // The message envelope, exactly as it's sent over MQTT.
+}
+
+// WebSocketTransport streams every message to browsers over a websocket at /events, so web pages can render a
+// visualizer in sync with the lights. Browsers can also send set-trigger messages and commands the same way.
+type WebSocketTransport struct {
+	server   *http.Server
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	clients  map[*wsClient]struct{}
+	retained map[topics.TopicName]retainedEvent // The last event of each retained topic, for browsers that connect later.
+}
+
+// retainedEvent is an event kept for browsers that connect later, until it expires.
+type retainedEvent struct {
+	event   []byte
+	expires time.Time // Zero if the event never expires.
+}
+
+// wsClient is a single connected browser.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// NewWebSocketTransport starts serving the websocket on the address, e.g. ":8081".
+// Browsers on other sites can only connect if their origin (e.g. "http://visualizer.local:3000") is allowed.
+func NewWebSocketTransport(address string, allowedOrigins []string) (*WebSocketTransport, error) {
+	t := &WebSocketTransport{
+		clients:  map[*wsClient]struct{}{},
+		retained: map[topics.TopicName]retainedEvent{},
+	}
+	t.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(r, allowedOrigins)
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", t.handleEvents)
+	mux.HandleFunc("/", serveVisualizer)
+	t.server = &http.Server{Addr: address, Handler: mux}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Serving websocket events on", listener.Addr())
+	go func() {
+		if err := t.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}()
+	return t, nil
+}
+
+// checkOrigin allows browsers on the gateway's own site, and on any of the allowed origins.
+func checkOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser.
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "http://"), "https://"), r.Host)
+}
+
+// Send streams the message to every connected browser.
+func (t *WebSocketTransport) Send(topic topics.TopicName, envelope messages.Envelope, body interface{}) error {
+	message, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	event, err := json.Marshal(WebSocketEvent{Topic: topic, Message: message})
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if policy := topics.PolicyFor(topic); policy.Retained {
+		retained := retainedEvent{event: event}
+		if policy.Expiry > 0 {
+			retained.expires = time.Now().Add(policy.Expiry)
+		}
+		t.retained[topic] = retained
+	}
+	for c := range t.clients {
+		t.queue(c, event)
+	}
+	return nil
+}
+
+// queue sends the event to the browser, disconnecting it if it can't keep up. t.mu must be held.
+func (t *WebSocketTransport) queue(c *wsClient, event []byte) {
+	select {
+	case c.send <- event:
+	default:
+		log.Println("Websocket client too slow, disconnecting", c.conn.RemoteAddr())
+		delete(t.clients, c)
+		close(c.send)
+	}
+}
+
+// Close disconnects every browser and stops the server.
+func (t *WebSocketTransport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+	err := t.server.Shutdown(ctx)
+
+	// Hijacked websocket connections aren't closed by Shutdown.
+	t.mu.Lock()
+	for c := range t.clients {
+		delete(t.clients, c)
+		close(c.send)
+	}
+	t.mu.Unlock()
+	return err
+}
+
+func (t *WebSocketTransport) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with the error.
+		log.Println("Websocket upgrade failed:", err)
+		return
+	}
+	log.Println("Websocket client connected", conn.RemoteAddr())
+
+	c := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer)}
+	t.mu.Lock()
+	t.clients[c] = struct{}{}
+	// Catch the browser up on the retained topics, as MQTT would, leaving out any that have expired.
+	now := time.Now()
+	for topic, retained := range t.retained {
+		if !retained.expires.IsZero() && now.After(retained.expires) {
+			delete(t.retained, topic)
+			continue
+		}
+		t.queue(c, retained.event)
+	}
+	t.mu.Unlock()
+
+	go c.writeEvents()
+	t.readEvents(c)
+}
+
+// writeEvents sends queued events to the browser until the send channel is closed.
+func (c *wsClient) writeEvents() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case event, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readEvents passes the browser's messages to the topic handlers until it disconnects.
+func (t *WebSocketTransport) readEvents(c *wsClient) {
+	defer func() {
+		t.mu.Lock()
+		if _, exists := t.clients[c]; exists {
+			delete(t.clients, c)
+			close(c.send)
+		}
+		t.mu.Unlock()
+		log.Println("Websocket client disconnected", c.conn.RemoteAddr())
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPingInterval * 2))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPingInterval * 2))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPingInterval * 2))
+
+		var event WebSocketEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Println("Invalid websocket event:", err)
+			continue
+		}
+		if err := checkInboundTopic(event.Topic); err != nil {
+			log.Println("Rejected websocket event:", err)
+			go SendError(event.Topic, 0, err)
+			continue
+		}
+		Dispatch(event.Topic, event.Message)
+	}
+}
+
+// checkInboundTopic only lets browsers send the messages devices are allowed to send.
+func checkInboundTopic(topic topics.TopicName) error {
+	if topic == topics.SetTrigger || topics.Match(string(topics.Commands)+"/+", string(topic)) {
+		return nil
+	}
+	return errors.New("browsers can't send messages to " + string(topic))
+}
+
+// serveVisualizer serves a simple page that flashes in time with the triggers.
+func serveVisualizer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(visualizerPage))
+}
+
+const visualizerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LightBeat</title>
+<style>
+  body { margin: 0; height: 100vh; display: flex; flex-direction: column; align-items: center; justify-content: center;
+         font-family: sans-serif; color: #fff; background: #000; transition: background 80ms; }
+  button { margin: 0.5em; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+<h1 id="media">Waiting for media...</h1>
+<p id="trigger"></p>
+<div>
+  <button onclick="setTrigger('beat')">Beat</button>
+  <button onclick="setTrigger('bar')">Bar</button>
+</div>
+<script>
+  const socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/events");
+  let sequence = 0;
+
+  socket.onmessage = (e) => {
+    const event = JSON.parse(e.data);
+    const body = event.message.body;
+    switch (event.topic) {
+      case "new-media":
+        document.getElementById("media").textContent = body.item.name;
+        break;
+      case "trigger-mode":
+        document.getElementById("trigger").textContent = "Trigger: " + body.trigger;
+        break;
+      case "trigger":
+        document.body.style.background = "#fff";
+        setTimeout(() => document.body.style.background = "#000", Math.min(body.duration / 2, 150));
+        break;
+    }
+  };
+
+  function setTrigger(trigger) {
+    const message = { version: 1, gateway_id: "browser", sequence: ++sequence, sent_at: Date.now(), body: { trigger } };
+    socket.send(JSON.stringify({ topic: "set-trigger", message }));
+  }
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,82 @@
+package edge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// connectWebSocket connects a browser to the transport's events, returning the topics of the events received
+// before the connection goes quiet.
+func connectWebSocket(t *testing.T, transport *WebSocketTransport) []topics.TopicName {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(transport.handleEvents))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var received []topics.TopicName
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return received
+		}
+		var event WebSocketEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, event.Topic)
+	}
+}
+
+func TestWebSocketReplaysRetainedEvents(t *testing.T) {
+	transport := &WebSocketTransport{
+		clients:  map[*wsClient]struct{}{},
+		retained: map[topics.TopicName]retainedEvent{},
+	}
+	send := func(topic topics.TopicName, body interface{}) {
+		envelope, err := messages.New("gateway", 1, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := transport.Send(topic, envelope, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(topics.NewMedia, models.Media{})
+	send(topics.TriggerMode, messages.TriggerMode{})
+	send(topics.Trigger, models.Trigger{Number: 1})
+
+	received := connectWebSocket(t, transport)
+	if len(received) != 2 {
+		t.Fatalf("received %v, want the new-media and trigger-mode events", received)
+	}
+
+	// Once the media has expired it isn't replayed, but the trigger mode never expires.
+	transport.mu.Lock()
+	retained := transport.retained[topics.NewMedia]
+	retained.expires = time.Now().Add(-time.Second)
+	transport.retained[topics.NewMedia] = retained
+	transport.mu.Unlock()
+
+	received = connectWebSocket(t, transport)
+	if len(received) != 1 || received[0] != topics.TriggerMode {
+		t.Fatalf("received %v, want only the trigger-mode event", received)
+	}
+	if _, exists := transport.retained[topics.NewMedia]; exists {
+		t.Error("expired event still retained")
+	}
+}
@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/eclipse/paho.mqtt.golang v1.3.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/ikester/blinkt v0.0.0-20170818135857-609ac315477e
 	github.com/ikester/gpio v0.0.0-20170408010935-fe62e5880568 // indirect
 	github.com/joho/godotenv v1.3.0
@@ -0,0 +1,62 @@
+package hardware
+
+import (
+	"github.com/ikester/blinkt"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// blinktPixels is how many pixels a Blinkt has.
+const blinktPixels = 8
+
+// Blinkt drives a Pimoroni Blinkt on a Raspberry Pi's GPIO pins.
+type Blinkt struct {
+	bl    blinkt.Blinkt
+	gamma *colors.GammaTable
+}
+
+// NewBlinkt sets up the Blinkt, correcting colors with the gamma table.
+func NewBlinkt(gamma *colors.GammaTable) *Blinkt {
+	b := &Blinkt{bl: blinkt.NewBlinkt(0.75), gamma: gamma}
+	b.bl.Setup()
+	return b
+}
+
+// NumPixels returns how many pixels are on the Blinkt.
+func (b *Blinkt) NumPixels() int {
+	return blinktPixels
+}
+
+// SetPixel sets the color of a pixel.
+func (b *Blinkt) SetPixel(pixel int, r, g, bl uint8) {
+	c := b.gamma.Correct(colors.Color{R: r, G: g, B: bl})
+	b.bl.SetPixel(pixel, int(c.R), int(c.G), int(c.B))
+}
+
+// SetBrightness sets the brightness of every pixel.
+func (b *Blinkt) SetBrightness(brightness float64) {
+	// The library exits if the brightness is out of range.
+	if brightness < 0 {
+		brightness = 0
+	} else if brightness > 1 {
+		brightness = 1
+	}
+	b.bl.SetBrightness(brightness)
+}
+
+// Show updates the Blinkt.
+func (b *Blinkt) Show() error {
+	b.bl.Show()
+	return nil
+}
+
+// Clear turns every pixel off.
+func (b *Blinkt) Clear() {
+	b.bl.Clear()
+}
+
+// Close turns the Blinkt off, and releases the GPIO pins.
+func (b *Blinkt) Close() error {
+	b.bl.ClearOnExit = true
+	b.bl.Cleanup()
+	return nil
+}
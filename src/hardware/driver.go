@@ -0,0 +1,90 @@
+package hardware
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// LightDriver is a strip of pixels the lightshow can be shown on.
+type LightDriver interface {
+	// NumPixels returns how many pixels are on the strip.
+	NumPixels() int
+
+	// SetPixel sets the color of a pixel. Show must be called to update the strip.
+	SetPixel(pixel int, r, g, b uint8)
+
+	// SetBrightness sets the brightness of every pixel, from 0 to 1. Show must be called to update the strip.
+	// Brightness scales the gamma corrected colors, so halving it halves the light given off.
+	SetBrightness(brightness float64)
+
+	// Show updates the strip with the pixels that have been set.
+	Show() error
+
+	// Clear turns every pixel off. Show must be called to update the strip.
+	Clear()
+
+	// Close turns the strip off, and releases it.
+	Close() error
+}
+
+// DriverConfig holds the settings of the light drivers. Drivers ignore the settings they don't need.
+type DriverConfig struct {
+	Pixels     int     // How many pixels are on the strip, for drivers that can't tell.
+	Gamma      float64 // The gamma to correct colors with, or 0 for the driver's default.
+	Device     string  // The device the strip is connected to, e.g. /dev/spidev0.0.
+	ColorOrder string  // The order addressable LEDs take their channels in, or empty for the driver's default.
+}
+
+// DriverNames holds the name of every light driver.
+var DriverNames = []string{"blinkt", "simulator", "sk6812", "ws2812"}
+
+// DefaultDriver is the light driver to use if none is configured. The gateway has always driven a Blinkt on
+// Raspberry Pis, and no lights elsewhere.
+var DefaultDriver = defaultDriver(runtime.GOOS, runtime.GOARCH)
+
+func defaultDriver(goos string, goarch string) string {
+	if goos == "linux" && goarch == "arm" {
+		return "blinkt"
+	}
+	return "none"
+}
+
+// defaultGammas holds the gamma each driver corrects colors with if the config doesn't say. LEDs need correcting so
+// colors look right to the eye, but terminals already correct the colors they're given.
+var defaultGammas = map[string]float64{
+	"blinkt":    2.2,
+	"simulator": 1,
+	"sk6812":    2.8,
+	"ws2812":    2.8,
+}
+
+// defaultColorOrders holds the order each kind of addressable LED takes its channels in, if the config doesn't say.
+var defaultColorOrders = map[string]string{
+	"sk6812": "GRBW",
+	"ws2812": "GRB",
+}
+
+// NewDriver creates the named light driver.
+func NewDriver(name string, config DriverConfig) (LightDriver, error) {
+	gamma := config.Gamma
+	if gamma <= 0 {
+		gamma = defaultGammas[name]
+	}
+	switch name {
+	case "blinkt":
+		return NewBlinkt(colors.NewGammaTable(gamma)), nil
+	case "simulator":
+		return NewSimulator(os.Stdout, config.Pixels, colors.NewGammaTable(gamma)), nil
+	case "ws2812", "sk6812":
+		order := config.ColorOrder
+		if order == "" {
+			order = defaultColorOrders[name]
+		}
+		return NewWS2812(config.Device, config.Pixels, order, colors.NewGammaTable(gamma))
+	default:
+		return nil, fmt.Errorf("unknown light driver %q, expected one of %v", name, DriverNames)
+	}
+}
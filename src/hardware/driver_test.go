@@ -0,0 +1,20 @@
+package hardware
+
+import "testing"
+
+func TestDefaultDriver(t *testing.T) {
+	tests := []struct {
+		goos, goarch string
+		driver       string
+	}{
+		{"linux", "arm", "blinkt"},
+		{"linux", "amd64", "none"},
+		{"darwin", "arm64", "none"},
+		{"windows", "arm", "none"},
+	}
+	for _, test := range tests {
+		if driver := defaultDriver(test.goos, test.goarch); driver != test.driver {
+			t.Errorf("%s/%s: default driver = %q, want %q", test.goos, test.goarch, driver, test.driver)
+		}
+	}
+}
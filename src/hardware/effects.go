@@ -0,0 +1,68 @@
+package hardware
+
+import (
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Flash lights every pixel for the duration.
+type Flash struct {
+	Color    colors.Color
+	Duration time.Duration
+}
+
+// Render lights every pixel until the flash is over.
+func (f Flash) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	if elapsed >= f.Duration {
+		return false
+	}
+	for i := range frame {
+		frame[i] = f.Color
+	}
+	return true
+}
+
+// StartUp fades the middle of the strip in, then lights the strip up from the middle outwards.
+type StartUp struct {
+	Color colors.Color
+}
+
+const (
+	startUpFade = 700 * time.Millisecond
+	startUpStep = 80 * time.Millisecond
+)
+
+// Render draws the start up animation, elapsed into it.
+func (s StartUp) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	numPixels := len(frame)
+	middle := numPixels / 2
+	if elapsed >= startUpFade+time.Duration(middle+1)*startUpStep {
+		return false
+	}
+
+	// The fade goes up to half brightness, as the pixels are quite bright.
+	intensity := 0.5
+	lit := 0
+	if elapsed < startUpFade {
+		intensity = 0.5 * float64(elapsed) / float64(startUpFade)
+	} else {
+		lit = int((elapsed-startUpFade)/startUpStep) + 1
+	}
+
+	color := s.Color.Scale(intensity)
+	for i := range frame {
+		// How far the pixel is from the middle of the strip, with both middle pixels of even strips at 0.
+		distance := i - middle
+		if distance < 0 {
+			distance = -distance - 1
+			if numPixels%2 != 0 {
+				distance++
+			}
+		}
+		if distance <= lit {
+			frame[i] = color
+		}
+	}
+	return true
+}
@@ -1,58 +1,178 @@
 package hardware
 
 import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/ikester/blinkt"
-	"github.com/tom-milner/LightBeatGateway/utils"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
 )
 
-var bl blinkt.Blinkt
+// device is a strip the lightshow is shown on.
+type device struct {
+	renderer *Renderer
+	effect   string // The name of the effect the device is showing.
+}
+
+// devices holds every strip the lightshow is shown on, by name.
+var devices = map[string]*device{}
+var devicesMu sync.RWMutex
+
+// paused is whether every device is blanked. devicesMu must be held.
+var paused bool
+
+// SetupLights starts rendering the lightshow on the driver with the config, showing the default effect after the start
+// up animation. A device with the same name as an existing one replaces it.
+func SetupLights(name string, driver LightDriver, config DeviceConfig) error {
+	renderer, err := NewRenderer(driver, config)
+	if err != nil {
+		return err
+	}
+	effect, _ := NewEffect(DefaultEffect, nil)
+	renderer.SetShow(effect)
+	renderer.Play(StartUp{Color: colors.MustParse(colors.Green)}, Over)
+
+	devicesMu.Lock()
+	renderer.SetPaused(paused)
+	old := devices[name]
+	devices[name] = &device{renderer: renderer, effect: DefaultEffect}
+	devicesMu.Unlock()
+	if old != nil {
+		old.renderer.Close()
+	}
+	return nil
+}
+
+// Enabled returns whether any lights have been set up.
+func Enabled() bool {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	return len(devices) > 0
+}
+
+// CloseLights turns every device's lights off.
+func CloseLights() error {
+	devicesMu.Lock()
+	closing := devices
+	devices = map[string]*device{}
+	devicesMu.Unlock()
+
+	var firstErr error
+	for _, d := range closing {
+		if err := d.renderer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SetEffect changes the effect shown on the named device, or on every device if the name is empty.
+func SetEffect(deviceName string, effectName string, params json.RawMessage) error {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	if _, exists := devices[deviceName]; deviceName != "" && !exists {
+		return fmt.Errorf("unknown light device %q", deviceName)
+	}
+
+	for name, d := range devices {
+		if deviceName != "" && name != deviceName {
+			continue
+		}
+		// Each device gets its own effect, so effects can't share state between devices.
+		effect, err := NewEffect(effectName, params)
+		if err != nil {
+			return err
+		}
+		d.renderer.SetShow(effect)
+		d.effect = effectName
+	}
+	return nil
+}
 
-func SetupLights() {
-	bl := blinkt.NewBlinkt(0.75)
-	bl.Setup()
-	bl.ShowInitialAnim()
+// Effects returns the name of the effect each device is showing, by device.
+func Effects() map[string]string {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	effects := make(map[string]string, len(devices))
+	for name, d := range devices {
+		effects[name] = d.effect
+	}
+	return effects
+}
+
+// Trigger moves every device's beat clock on to the trigger, at the brightness from 0 to 1.
+func Trigger(triggerType models.TriggerType, number int, position time.Duration, duration time.Duration,
+	color colors.Color, brightness float64) {
+	beat := Beat{Type: triggerType, Number: number, Position: position, Duration: duration, Color: color}
+	for _, r := range renderers() {
+		r.SetBrightness(brightness)
+		r.Trigger(beat)
+	}
+}
+
+// SetBrightness changes the brightness of every device straight away, from 0 to 1.
+func SetBrightness(brightness float64) {
+	for _, r := range renderers() {
+		r.SetBrightness(brightness)
+	}
+}
+
+// Pause blanks every device while paused.
+func Pause(pause bool) {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	paused = pause
+	for _, d := range devices {
+		d.renderer.SetPaused(pause)
+	}
+}
+
+// Stop stops showing the lightshow on every device until the next trigger, for when the track stops playing.
+func Stop() {
+	for _, r := range renderers() {
+		r.StopShow()
+	}
+}
+
+// SetMedia gives every device the analysis of the playing media, for effects that follow more than the beat.
+func SetMedia(analysis models.MediaAudioAnalysis) {
+	for _, r := range renderers() {
+		r.SetAnalysis(&analysis)
+	}
 }
 
+// ShowLightAnimation lights every device up from the middle outwards, in green.
 func ShowLightAnimation() {
-	bl.ShowInitialAnim()
+	for _, r := range renderers() {
+		r.Play(StartUp{Color: colors.MustParse(colors.Green)}, Over)
+	}
 }
 
+// FlashLights flashes every pixel once, in a random color.
 func FlashLights() {
-	bl.FlashAll(1, utils.GenRandomHexCode())
-}
-
-func FlashSequence(color string, fullTime time.Duration, forward bool) {
-	numPixels := 8
-	pixelDuration := time.Duration(int(fullTime) / 8)
-	bl.SetAll(blinkt.Hex2RGB(color))
-	bl.SetBrightness(0)
-
-	var incr, i int
-	if forward {
-		incr = 1
-		i = 0
-	} else {
-		incr = -1
-		i = numPixels - 1
-	}
-	mainPixelBrightness := 0.5
-	neighbourPixelBrightness := 0.04
-	for c := 0; c < numPixels; c++ {
-		bl.SetBrightness(0)
-		bl.SetPixelBrightness(i, mainPixelBrightness)
-		if i == 0 {
-			bl.SetPixelBrightness(1, neighbourPixelBrightness)
-		} else if i == 7 {
-			bl.SetPixelBrightness(6, neighbourPixelBrightness)
-		} else {
-			bl.SetPixelBrightness(i+1, neighbourPixelBrightness)
-			bl.SetPixelBrightness(i-1, neighbourPixelBrightness)
-		}
-		bl.Show()
-		time.Sleep(pixelDuration)
+	color := colors.HSV(rand.Float64()*360, 1, 1)
+	for _, r := range renderers() {
+		r.Play(Flash{Color: color, Duration: 30 * time.Millisecond}, Over)
+	}
+}
+
+// renderers returns the renderer of every device, in name order.
+func renderers() []*Renderer {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		i += incr
+	renderers := make([]*Renderer, len(names))
+	for i, name := range names {
+		renderers[i] = devices[name].renderer
 	}
+	return renderers
 }
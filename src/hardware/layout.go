@@ -0,0 +1,202 @@
+package hardware
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Layout describes how the pixels of a strip are arranged. Effects draw along a line of pixels, and the layout says
+// which of the strip's pixels each pixel of the line lights, so the same effects work on any strip or matrix. On a
+// matrix, effects that can draw in two dimensions draw on the whole grid instead.
+type Layout struct {
+	Segments []Segment `json:"segments,omitempty"` // The pieces of the strip, in the order effects draw along them. Defaults to the whole strip.
+	Matrix   *Matrix   `json:"matrix,omitempty"`   // The matrix the pixels are wired into, instead of segments.
+	Reverse  bool      `json:"reverse,omitempty"`  // Whether effects draw along the line from the far end.
+	Mirror   bool      `json:"mirror,omitempty"`   // Whether effects draw from the middle of the line out to both ends.
+}
+
+// Segment is a piece of a strip.
+type Segment struct {
+	Start   int  `json:"start"`             // The index of the segment's first pixel on the strip.
+	Length  int  `json:"length"`            // How many pixels are in the segment.
+	Reverse bool `json:"reverse,omitempty"` // Whether effects draw along the segment from its last pixel.
+}
+
+// Matrix is a grid of pixels, wired row by row from the top left. Effects draw along its columns, lighting every
+// pixel in the column, or along its rows if it's vertical. Grid effects draw on every pixel of the grid.
+type Matrix struct {
+	Width      int  `json:"width"`
+	Height     int  `json:"height"`
+	Serpentine bool `json:"serpentine,omitempty"` // Whether every other row is wired right to left.
+	Vertical   bool `json:"vertical,omitempty"`   // Whether effects draw from the top row to the bottom row.
+}
+
+// ParseLayout decodes a layout from JSON, e.g. {"matrix": {"width": 16, "height": 16, "serpentine": true}}.
+func ParseLayout(config string) (Layout, error) {
+	var layout Layout
+	if err := json.Unmarshal([]byte(config), &layout); err != nil {
+		return Layout{}, fmt.Errorf("invalid light layout: %v", err)
+	}
+	return layout, nil
+}
+
+// NumPixels returns how many pixels a strip needs for the layout, or 0 if the layout doesn't say.
+func (l Layout) NumPixels() int {
+	if l.Matrix != nil {
+		return l.Matrix.Width * l.Matrix.Height
+	}
+	pixels := 0
+	for _, s := range l.Segments {
+		if end := s.Start + s.Length; end > pixels {
+			pixels = end
+		}
+	}
+	return pixels
+}
+
+// Mapping holds the pixels of the strip that each pixel of the line effects draw along lights.
+type Mapping [][]int
+
+// Apply lays the frame drawn along the line out on a strip with numPixels pixels. Pixels the layout leaves out are
+// off.
+func (m Mapping) Apply(frame Frame, numPixels int) Frame {
+	pixels := make(Frame, numPixels)
+	for i, p := range frame {
+		for _, pixel := range m[i] {
+			pixels[pixel] = p
+		}
+	}
+	return pixels
+}
+
+// Size returns the width and height of a picture of a strip with numPixels pixels in the layout. Strips are a single
+// row.
+func (l Layout) Size(numPixels int) (width, height int) {
+	if l.Matrix != nil {
+		return l.Matrix.Width, l.Matrix.Height
+	}
+	return numPixels, 1
+}
+
+// Position returns where the pixel is in a picture of the strip, where 0, 0 is the top left.
+func (l Layout) Position(pixel int) (x, y int) {
+	if m := l.Matrix; m != nil {
+		x, y = pixel%m.Width, pixel/m.Width
+		if m.Serpentine && y%2 != 0 {
+			x = m.Width - 1 - x
+		}
+		return x, y
+	}
+	return pixel, 0
+}
+
+// Canvas is what effects draw on for a layout on a strip: a line, and a grid if the pixels are wired into a matrix.
+type Canvas struct {
+	NumPixels int     // How many pixels are on the strip.
+	Line      Mapping // The pixels of the strip each pixel of the line lights.
+	Grid      *Matrix // The matrix grid effects draw on, or nil if there's only the line.
+}
+
+// Canvas works out what effects draw on for a strip with numPixels pixels.
+func (l Layout) Canvas(numPixels int) (Canvas, error) {
+	line, err := l.Map(numPixels)
+	if err != nil {
+		return Canvas{}, err
+	}
+	return Canvas{NumPixels: numPixels, Line: line, Grid: l.Matrix}, nil
+}
+
+// Map works out which pixels of a strip with numPixels pixels each pixel of the line lights.
+func (l Layout) Map(numPixels int) (Mapping, error) {
+	var line Mapping
+	switch {
+	case l.Matrix != nil && len(l.Segments) > 0:
+		return nil, fmt.Errorf("light layout can't have both segments and a matrix")
+	case l.Matrix != nil:
+		m := l.Matrix
+		if m.Width <= 0 || m.Height <= 0 || m.Width*m.Height > numPixels {
+			return nil, fmt.Errorf("%dx%d matrix doesn't fit on a strip of %d pixels", m.Width, m.Height, numPixels)
+		}
+		line = m.mapping()
+	case len(l.Segments) > 0:
+		for _, s := range l.Segments {
+			if s.Start < 0 || s.Length <= 0 || s.Start+s.Length > numPixels {
+				return nil, fmt.Errorf("segment of %d pixels from %d doesn't fit on a strip of %d pixels", s.Length,
+					s.Start, numPixels)
+			}
+			for i := 0; i < s.Length; i++ {
+				pixel := s.Start + i
+				if s.Reverse {
+					pixel = s.Start + s.Length - 1 - i
+				}
+				line = append(line, []int{pixel})
+			}
+		}
+	default:
+		for i := 0; i < numPixels; i++ {
+			line = append(line, []int{i})
+		}
+	}
+
+	if l.Reverse {
+		for i, j := 0, len(line)-1; i < j; i, j = i+1, j-1 {
+			line[i], line[j] = line[j], line[i]
+		}
+	}
+	if l.Mirror {
+		line = mirror(line)
+	}
+	return line, nil
+}
+
+// mapping returns the pixels in each column of the matrix, or in each row if it's vertical.
+func (m Matrix) mapping() Mapping {
+	length, across := m.Width, m.Height
+	if m.Vertical {
+		length, across = m.Height, m.Width
+	}
+	line := make(Mapping, length)
+	for i := range line {
+		for j := 0; j < across; j++ {
+			x, y := i, j
+			if m.Vertical {
+				x, y = j, i
+			}
+			line[i] = append(line[i], m.pixel(x, y))
+		}
+	}
+	return line
+}
+
+// apply lays the grid out on the strip's pixels.
+func (m Matrix) apply(grid Grid, pixels Frame) {
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			pixels[m.pixel(x, y)] = grid.At(x, y)
+		}
+	}
+}
+
+// pixel returns the index on the strip of the pixel at x, y, where 0, 0 is the top left.
+func (m Matrix) pixel(x, y int) int {
+	if m.Serpentine && y%2 != 0 {
+		x = m.Width - 1 - x
+	}
+	return y*m.Width + x
+}
+
+// mirror folds the line in half, so each pixel lights the pixels either side of the middle the same distance out.
+// The middle pixel of an odd line is lit on its own.
+func mirror(line Mapping) Mapping {
+	half := (len(line) + 1) / 2
+	mirrored := make(Mapping, half)
+	for i := range mirrored {
+		// Counting out from the middle.
+		below, above := half-1-i, len(line)-half+i
+		mirrored[i] = append(append([]int(nil), line[below]...), line[above]...)
+		if below == above {
+			mirrored[i] = line[below]
+		}
+	}
+	return mirrored
+}
@@ -0,0 +1,89 @@
+package hardware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// dot lights a single pixel of the grid, or the pixel at x along the line.
+type dot struct {
+	x, y  int
+	color colors.Color
+}
+
+func (d dot) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	frame[d.x] = d.color
+	return true
+}
+
+func (d dot) RenderGrid(grid Grid, elapsed time.Duration, clock Clock) bool {
+	grid.Set(d.x, d.y, d.color)
+	return true
+}
+
+// lit returns the indexes of the pixels that aren't off.
+func lit(frame Frame) []int {
+	var pixels []int
+	for i, p := range frame {
+		if p != colors.Black {
+			pixels = append(pixels, i)
+		}
+	}
+	return pixels
+}
+
+func TestMatrixCanvas(t *testing.T) {
+	// A 3x2 serpentine matrix is wired 0 1 2 along the top, then 5 4 3 along the bottom.
+	layout := Layout{Matrix: &Matrix{Width: 3, Height: 2, Serpentine: true}}
+	canvas, err := layout.Canvas(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		effect Effect
+		want   []int
+	}{
+		{"grid effects light a single pixel", dot{x: 0, y: 1, color: red}, []int{5}},
+		{"grid effects follow the serpentine", dot{x: 2, y: 1, color: red}, []int{3}},
+		{"line effects light whole columns", fill{red, time.Second}, []int{0, 1, 2, 3, 4, 5}},
+		{"line effects light the column under each pixel", lineOnly{dot{x: 0, color: red}}, []int{0, 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame, _ := RenderFrame(canvas, []Layer{{Effect: test.effect}}, Clock{})
+			got := lit(frame)
+			if len(got) != len(test.want) {
+				t.Fatalf("lit pixels = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("lit pixels = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+// lineOnly hides that an effect can draw on a grid.
+type lineOnly struct {
+	effect Effect
+}
+
+func (l lineOnly) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	return l.effect.Render(frame, elapsed, clock)
+}
+
+func TestGridEffectsOnStrips(t *testing.T) {
+	canvas, err := Layout{}.Canvas(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, _ := RenderFrame(canvas, []Layer{{Effect: dot{x: 2, y: 1, color: red}}}, Clock{})
+	if got := lit(frame); len(got) != 1 || got[0] != 2 {
+		t.Errorf("lit pixels = %v, want the pixel along the line", got)
+	}
+}
@@ -0,0 +1,256 @@
+package hardware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// The effects that can be chosen for a device. Each one plays for as long as it's chosen, following the beat clock.
+var effects = map[string]func() Effect{
+	"pulse":   func() Effect { return &Pulse{Decay: 4} },
+	"chase":   func() Effect { return &Chase{Brightness: 0.5, Tail: 0.08} },
+	"strobe":  func() Effect { return &Strobe{Rate: 15, Length: 0.5} },
+	"rainbow": func() Effect { return &Rainbow{BeatsPerCycle: 4, Spread: 1} },
+	"vu":      func() Effect { return &VU{Floor: -40} },
+	"sparkle": func() Effect { return &Sparkle{Density: 0.25} },
+}
+
+// DefaultEffect is the effect devices start with.
+const DefaultEffect = "chase"
+
+// EffectNames returns the name of every effect that can be chosen, in alphabetical order.
+func EffectNames() []string {
+	names := make([]string, 0, len(effects))
+	for name := range effects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEffect creates the named effect. The params are the JSON encoding of the effect's settings, and can leave
+// settings out to use their defaults.
+func NewEffect(name string, params json.RawMessage) (Effect, error) {
+	newEffect, exists := effects[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown effect %q, expected one of %v", name, EffectNames())
+	}
+	effect := newEffect()
+	if len(params) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(effect); err != nil {
+			return nil, fmt.Errorf("invalid params for effect %s: %v", name, err)
+		}
+	}
+	return effect, nil
+}
+
+// effectColor returns the color of the effect, which is the color of the trigger unless it's been set.
+func effectColor(color *colors.Color, clock Clock) colors.Color {
+	if color != nil {
+		return *color
+	}
+	return clock.Beat.Color
+}
+
+// Pulse lights the whole strip on every trigger, then fades it out.
+type Pulse struct {
+	Color *colors.Color `json:"color,omitempty"` // The hex color of the pulse. Defaults to the color of the trigger.
+	Decay float64       `json:"decay"`           // How quickly the pulse fades. Higher is quicker.
+}
+
+// Render draws the pulse, faded by how far through the trigger the clock is.
+func (p *Pulse) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	if clock.Beat.Duration <= 0 {
+		return true
+	}
+	color := effectColor(p.Color, clock).Scale(math.Exp(-p.Decay * clock.Phase()))
+	for i := range frame {
+		frame[i] = color
+	}
+	return true
+}
+
+// Chase moves a pixel along the strip over every trigger, changing direction every trigger.
+type Chase struct {
+	Color      *colors.Color `json:"color,omitempty"` // The hex color of the pixel. Defaults to the color of the trigger.
+	Brightness float64       `json:"brightness"`      // How bright the pixel is, from 0 to 1. A lone pixel is quite bright.
+	Tail       float64       `json:"tail"`            // How bright the pixel's neighbours glow, relative to the pixel.
+}
+
+// Render draws the pixel where it is through the trigger.
+func (c *Chase) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	if clock.Beat.Duration <= 0 || len(frame) == 0 {
+		return true
+	}
+	i := int(clock.Phase() * float64(len(frame)))
+	if i >= len(frame) {
+		i = len(frame) - 1
+	}
+	if clock.Beat.Number&1 != 0 {
+		i = len(frame) - 1 - i
+	}
+
+	color := effectColor(c.Color, clock).Scale(c.Brightness)
+	frame[i] = color
+	if i > 0 {
+		frame[i-1] = color.Scale(c.Tail)
+	}
+	if i < len(frame)-1 {
+		frame[i+1] = color.Scale(c.Tail)
+	}
+	return true
+}
+
+// Strobe flashes the whole strip at the start of every bar.
+type Strobe struct {
+	Color  *colors.Color `json:"color,omitempty"` // The hex color of the strobe. Defaults to white.
+	Rate   float64       `json:"rate"`            // How many flashes a second.
+	Length float64       `json:"length"`          // How much of the first trigger of the bar to strobe for, from 0 to 1.
+}
+
+// Render draws the strobe, if the clock is at the start of a bar.
+func (s *Strobe) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	// With beat triggers, assume 4 beats to a bar.
+	startOfBar := clock.Beat.Type == models.Bar || clock.Beat.Number%4 == 0
+	if clock.Beat.Duration <= 0 || !startOfBar || clock.Phase() >= s.Length {
+		return true
+	}
+	if int(clock.Since.Seconds()*s.Rate*2)%2 != 0 {
+		// Between flashes.
+		return true
+	}
+
+	color := colors.White
+	if s.Color != nil {
+		color = *s.Color
+	}
+	for i := range frame {
+		frame[i] = color
+	}
+	return true
+}
+
+// Rainbow spreads a rainbow along the strip, cycling through it in time with the triggers.
+type Rainbow struct {
+	BeatsPerCycle float64 `json:"beats_per_cycle"` // How many triggers it takes to cycle through the rainbow.
+	Spread        float64 `json:"spread"`          // How much of the rainbow is on the strip at once, from 0 to 1.
+}
+
+// Render draws the rainbow, moved on by how many triggers there have been.
+func (r *Rainbow) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	beats := float64(clock.Beat.Number) + clock.Phase()
+	if r.BeatsPerCycle > 0 {
+		beats /= r.BeatsPerCycle
+	}
+	for i := range frame {
+		frame[i] = colors.HSV(360*(beats+r.Spread*float64(i)/float64(len(frame))), 1, 1)
+	}
+	return true
+}
+
+// VU lights the strip like a VU meter, following the loudness of the track.
+type VU struct {
+	Floor float64 `json:"floor"` // The loudness, in dB, that lights nothing. 0 dB lights the whole strip.
+}
+
+// Render draws the meter at the loudness of the track where the clock is.
+func (v *VU) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	if clock.Analysis == nil || v.Floor >= 0 {
+		return true
+	}
+	loudness, ok := loudnessAt(clock.Analysis.Segments, clock.Position().Seconds())
+	if !ok {
+		return true
+	}
+	level := (loudness - v.Floor) / -v.Floor * float64(len(frame))
+
+	for i := range frame {
+		intensity := math.Min(1, level-float64(i))
+		if intensity <= 0 {
+			break
+		}
+		// Green at the bottom, through yellow to red at the top.
+		position := float64(i) / float64(len(frame))
+		frame[i] = colors.HSV(120*(1-position), 1, 1).Scale(intensity)
+	}
+	return true
+}
+
+// loudnessAt returns the loudness of the track at the time, in dB. Within each segment, the loudness rises to the
+// segment's peak, then falls towards the start of the next segment.
+func loudnessAt(segments []models.Segment, seconds float64) (float64, bool) {
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].Start > seconds
+	}) - 1
+	if i < 0 || seconds >= segments[i].Start+segments[i].Duration {
+		return 0, false
+	}
+
+	s := segments[i]
+	into := seconds - s.Start
+	if into < s.LoudnessMaxTime {
+		return s.LoudnessStart + (s.LoudnessMax-s.LoudnessStart)*into/s.LoudnessMaxTime, true
+	}
+	end := s.LoudnessMax
+	if i+1 < len(segments) {
+		end = segments[i+1].LoudnessStart
+	}
+	fall := s.Duration - s.LoudnessMaxTime
+	if fall <= 0 {
+		return s.LoudnessMax, true
+	}
+	return s.LoudnessMax + (end-s.LoudnessMax)*(into-s.LoudnessMaxTime)/fall, true
+}
+
+// Sparkle lights random pixels on every tatum, the smallest beat subdivision of the track, fading them out over it.
+type Sparkle struct {
+	Color   *colors.Color `json:"color,omitempty"` // The hex color of the sparkles. Defaults to the color of the trigger.
+	Density float64       `json:"density"`         // How many of the pixels sparkle on each tatum, from 0 to 1.
+}
+
+// Render draws the sparkles of the tatum the clock is in.
+func (s *Sparkle) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	if clock.Analysis == nil {
+		return true
+	}
+	tatums := clock.Analysis.Tatums
+	seconds := clock.Position().Seconds()
+	t := sort.Search(len(tatums), func(i int) bool {
+		return tatums[i].Start > seconds
+	}) - 1
+	if t < 0 || tatums[t].Duration <= 0 || seconds >= tatums[t].Start+tatums[t].Duration {
+		return true
+	}
+
+	color := effectColor(s.Color, clock).Scale(1 - (seconds-tatums[t].Start)/tatums[t].Duration)
+	for i := range frame {
+		// The same pixels sparkle whenever a tatum is rendered, so frames only depend on the clock.
+		if random(t, i) < s.Density {
+			frame[i] = color
+		}
+	}
+	return true
+}
+
+// RenderGrid draws the sparkles on every pixel of the grid, rather than on whole columns.
+func (s *Sparkle) RenderGrid(grid Grid, elapsed time.Duration, clock Clock) bool {
+	return s.Render(grid.Frame, elapsed, clock)
+}
+
+// random returns a number from 0 to 1 that's always the same for the same arguments.
+func random(a, b int) float64 {
+	x := uint64(a)*0x9E3779B97F4A7C15 ^ uint64(b)*0xC2B2AE3D27D4EB4F
+	x ^= x >> 31
+	x *= 0xBF58476D1CE4E5B9
+	x ^= x >> 29
+	return float64(x>>11) / (1 << 53)
+}
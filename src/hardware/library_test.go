@@ -0,0 +1,295 @@
+package hardware
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// render draws the effect into a new frame of n pixels.
+func render(effect Effect, n int, clock Clock) Frame {
+	frame := make(Frame, n)
+	effect.Render(frame, 0, clock)
+	return frame
+}
+
+// filled returns a frame of n pixels of the color.
+func filled(n int, color colors.Color) Frame {
+	frame := make(Frame, n)
+	for i := range frame {
+		frame[i] = color
+	}
+	return frame
+}
+
+func TestPulse(t *testing.T) {
+	red := colors.Color{R: 200}
+	blue := colors.Color{B: 200}
+	beat := Beat{Duration: time.Second, Color: red}
+	tests := []struct {
+		name  string
+		pulse Pulse
+		clock Clock
+		want  colors.Color
+	}{
+		{"start of the trigger", Pulse{Decay: 4}, Clock{Beat: beat}, red},
+		{"half way", Pulse{Decay: 4}, Clock{Beat: beat, Since: 500 * time.Millisecond}, red.Scale(math.Exp(-2))},
+		{"after the trigger", Pulse{Decay: 4}, Clock{Beat: beat, Since: 2 * time.Second}, red.Scale(math.Exp(-4))},
+		{"no decay", Pulse{}, Clock{Beat: beat, Since: 900 * time.Millisecond}, red},
+		{"own color", Pulse{Color: &blue, Decay: 4}, Clock{Beat: beat}, blue},
+		{"no trigger", Pulse{Decay: 4}, Clock{}, colors.Black},
+	}
+	for _, test := range tests {
+		if got := render(&test.pulse, 3, test.clock); !reflect.DeepEqual(got, filled(3, test.want)) {
+			t.Errorf("%s: frame = %v, want all %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStrobe(t *testing.T) {
+	beat := func(number int) Beat {
+		return Beat{Type: models.Beat, Number: number, Duration: time.Second}
+	}
+	red := colors.Color{R: 255}
+	tests := []struct {
+		name   string
+		strobe Strobe
+		clock  Clock
+		want   colors.Color
+	}{
+		// With beat triggers, only the first beat of each bar of 4 strobes.
+		{"first beat of the bar", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(0)}, colors.White},
+		{"second beat", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(1)}, colors.Black},
+		{"third beat", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(2)}, colors.Black},
+		{"fourth beat", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(3)}, colors.Black},
+		{"first beat of the next bar", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(4)}, colors.White},
+		// Every bar trigger is the start of a bar.
+		{"bar", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: Beat{Type: models.Bar, Number: 3, Duration: time.Second}}, colors.White},
+
+		// At 15 flashes a second, each flash is on for the first 1/30s of every 1/15s.
+		{"between flashes", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(0), Since: 40 * time.Millisecond}, colors.Black},
+		{"second flash", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(0), Since: 70 * time.Millisecond}, colors.White},
+		{"after the length", Strobe{Rate: 15, Length: 0.5}, Clock{Beat: beat(0), Since: 600 * time.Millisecond}, colors.Black},
+		{"own color", Strobe{Color: &red, Rate: 15, Length: 0.5}, Clock{Beat: beat(0)}, red},
+		{"no trigger", Strobe{Rate: 15, Length: 0.5}, Clock{}, colors.Black},
+	}
+	for _, test := range tests {
+		if got := render(&test.strobe, 3, test.clock); !reflect.DeepEqual(got, filled(3, test.want)) {
+			t.Errorf("%s: frame = %v, want all %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRainbow(t *testing.T) {
+	hues := func(hues ...float64) Frame {
+		frame := make(Frame, len(hues))
+		for i, hue := range hues {
+			frame[i] = colors.HSV(hue, 1, 1)
+		}
+		return frame
+	}
+	clock := func(number int, since time.Duration) Clock {
+		return Clock{Beat: Beat{Number: number, Duration: time.Second}, Since: since}
+	}
+	tests := []struct {
+		name    string
+		rainbow Rainbow
+		clock   Clock
+		want    Frame
+	}{
+		{"whole rainbow", Rainbow{BeatsPerCycle: 4, Spread: 1}, clock(0, 0), hues(0, 90, 180, 270)},
+		{"half the rainbow", Rainbow{BeatsPerCycle: 4, Spread: 0.5}, clock(0, 0), hues(0, 45, 90, 135)},
+		{"one trigger on", Rainbow{BeatsPerCycle: 4, Spread: 1}, clock(1, 0), hues(90, 180, 270, 0)},
+		{"through a trigger", Rainbow{BeatsPerCycle: 4, Spread: 0.5}, clock(1, 500*time.Millisecond), hues(135, 180, 225, 270)},
+		// Hues past the end of the rainbow wrap around to the start.
+		{"wrapping", Rainbow{BeatsPerCycle: 4, Spread: 1}, clock(3, 500*time.Millisecond), hues(315, 45, 135, 225)},
+		{"a whole cycle on", Rainbow{BeatsPerCycle: 4, Spread: 1}, clock(4, 0), hues(0, 90, 180, 270)},
+		{"many cycles on", Rainbow{BeatsPerCycle: 4, Spread: 1}, clock(401, 0), hues(90, 180, 270, 0)},
+		{"a cycle every trigger", Rainbow{Spread: 1}, clock(7, 250*time.Millisecond), hues(90, 180, 270, 0)},
+	}
+	for _, test := range tests {
+		if got := render(&test.rainbow, 4, test.clock); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: frame = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// testSegments rises from -30dB to a peak of -10dB, falls to the -20dB start of the next segment, rises to -5dB, then
+// falls towards the -40dB start of the last segment, after a gap.
+var testSegments = []models.Segment{
+	{Start: 1, Duration: 1, LoudnessStart: -30, LoudnessMax: -10, LoudnessMaxTime: 0.25},
+	{Start: 2, Duration: 2, LoudnessStart: -20, LoudnessMax: -5, LoudnessMaxTime: 1},
+	{Start: 5, Duration: 1, LoudnessStart: -40, LoudnessMax: -20, LoudnessMaxTime: 0.5},
+}
+
+func TestLoudnessAt(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    float64
+		ok      bool
+	}{
+		{0, 0, false},
+		{0.99, 0, false},
+		{1, -30, true},
+		{1.125, -20, true},
+		{1.25, -10, true},
+		// Falling from the peak towards the start of the next segment.
+		{1.625, -15, true},
+		{2, -20, true},
+		{2.5, -12.5, true},
+		{3, -5, true},
+		{3.5, -22.5, true},
+		// Gaps between segments have no loudness.
+		{4, 0, false},
+		{4.5, 0, false},
+		{5.25, -30, true},
+		{5.5, -20, true},
+		// The last segment stays at its peak, as there's no next segment to fall towards.
+		{5.75, -20, true},
+		{6, 0, false},
+		{100, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := loudnessAt(testSegments, test.seconds)
+		if ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("loudnessAt(%v) = %v, %v, want %v, %v", test.seconds, got, ok, test.want, test.ok)
+		}
+	}
+	if _, ok := loudnessAt(nil, 1); ok {
+		t.Error("found the loudness without any segments")
+	}
+}
+
+func TestVU(t *testing.T) {
+	analysis := &models.MediaAudioAnalysis{Segments: testSegments}
+	at := func(seconds float64) Clock {
+		// The position is where the trigger started plus how long ago that was.
+		return Clock{Beat: Beat{Position: time.Second}, Since: time.Duration(seconds*float64(time.Second)) - time.Second, Analysis: analysis}
+	}
+	// The meter goes from green at the bottom to red at the top.
+	meter := func(pixels int, top float64) Frame {
+		frame := make(Frame, 10)
+		for i := 0; i < pixels; i++ {
+			frame[i] = colors.HSV(120*(1-float64(i)/10), 1, 1)
+		}
+		if top > 0 {
+			frame[pixels] = colors.HSV(120*(1-float64(pixels)/10), 1, 1).Scale(top)
+		}
+		return frame
+	}
+	tests := []struct {
+		name  string
+		vu    VU
+		clock Clock
+		want  Frame
+	}{
+		{"at -20dB", VU{Floor: -40}, at(2), meter(5, 0)},
+		{"at -10dB", VU{Floor: -40}, at(1.25), meter(7, 0.5)},
+		{"at -5dB", VU{Floor: -40}, at(3), meter(8, 0.75)},
+		{"at -30dB", VU{Floor: -40}, at(1), meter(2, 0.5)},
+		{"at the floor", VU{Floor: -30}, at(1), meter(0, 0)},
+		{"before the first segment", VU{Floor: -40}, at(0.5), meter(0, 0)},
+		{"after the last segment", VU{Floor: -40}, at(7), meter(0, 0)},
+		{"no analysis", VU{Floor: -40}, Clock{Beat: Beat{Position: 2 * time.Second}}, meter(0, 0)},
+		{"no floor", VU{}, at(2), meter(0, 0)},
+	}
+	for _, test := range tests {
+		if got := render(&test.vu, 10, test.clock); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: frame = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSparkle(t *testing.T) {
+	analysis := &models.MediaAudioAnalysis{Tatums: []models.TimeInterval{{Start: 1, Duration: 0.5}, {Start: 1.5, Duration: 0.5}}}
+	white := colors.White
+	at := func(seconds float64) Clock {
+		return Clock{Beat: Beat{Position: time.Duration(seconds * float64(time.Second)), Color: white}, Analysis: analysis}
+	}
+	sparkles := func(frame Frame) []int {
+		var lit []int
+		for i, p := range frame {
+			if p != colors.Black {
+				lit = append(lit, i)
+			}
+		}
+		return lit
+	}
+
+	sparkle := &Sparkle{Density: 0.25}
+	first := render(sparkle, 200, at(1))
+	if n := len(sparkles(first)); n < 25 || n > 75 {
+		t.Errorf("%d of 200 pixels sparkle, want about 50", n)
+	}
+	for _, i := range sparkles(first) {
+		if first[i] != white {
+			t.Fatalf("pixel %d = %v at the start of the tatum, want %v", i, first[i], white)
+		}
+	}
+
+	// The same pixels sparkle throughout a tatum, fading out.
+	fading := render(sparkle, 200, at(1.25))
+	if !reflect.DeepEqual(sparkles(fading), sparkles(first)) {
+		t.Error("different pixels sparkle within a tatum")
+	}
+	for _, i := range sparkles(fading) {
+		if fading[i] != white.Scale(0.5) {
+			t.Fatalf("pixel %d = %v half way through the tatum, want %v", i, fading[i], white.Scale(0.5))
+		}
+	}
+
+	// Rendering a tatum again gives the same frame, even from a new effect.
+	if again := render(&Sparkle{Density: 0.25}, 200, at(1)); !reflect.DeepEqual(again, first) {
+		t.Error("rendering the same tatum again gave a different frame")
+	}
+	// Other tatums sparkle on other pixels.
+	if next := render(sparkle, 200, at(1.5)); reflect.DeepEqual(sparkles(next), sparkles(first)) {
+		t.Error("the next tatum sparkles on the same pixels")
+	}
+
+	tests := []struct {
+		name    string
+		sparkle Sparkle
+		clock   Clock
+		lit     int
+	}{
+		{"none", Sparkle{Density: 0}, at(1), 0},
+		{"all", Sparkle{Density: 1}, at(1), 200},
+		{"before the first tatum", Sparkle{Density: 1}, at(0.5), 0},
+		{"after the last tatum", Sparkle{Density: 1}, at(2), 0},
+		{"no analysis", Sparkle{Density: 1}, Clock{Beat: Beat{Position: time.Second}}, 0},
+	}
+	for _, test := range tests {
+		if got := len(sparkles(render(&test.sparkle, 200, test.clock))); got != test.lit {
+			t.Errorf("%s: %d pixels sparkle, want %d", test.name, got, test.lit)
+		}
+	}
+}
+
+func TestNewEffect(t *testing.T) {
+	for _, name := range EffectNames() {
+		if _, err := NewEffect(name, nil); err != nil {
+			t.Errorf("NewEffect(%s) error = %v", name, err)
+		}
+	}
+
+	effect, err := NewEffect("pulse", []byte(`{"color":"FF0000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Settings left out keep their defaults.
+	if pulse := effect.(*Pulse); pulse.Color == nil || *pulse.Color != (colors.Color{R: 255}) || pulse.Decay != 4 {
+		t.Errorf("pulse = %+v, want red with the default decay", pulse)
+	}
+
+	if _, err := NewEffect("unknown", nil); err == nil {
+		t.Error("created an unknown effect")
+	}
+	if _, err := NewEffect("pulse", []byte(`{"speed":2}`)); err == nil {
+		t.Error("created an effect with unknown params")
+	}
+}
@@ -0,0 +1,101 @@
+package hardware
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultMilliampsPerChannel is how much current a channel of a pixel draws at full brightness, if the config doesn't
+// say. It's about right for WS2812, SK6812 and APA102 LEDs.
+const DefaultMilliampsPerChannel = 20
+
+// PowerBudget limits how much current a strip draws, so bright frames on long strips don't brown out the supply.
+type PowerBudget struct {
+	MilliampsPerChannel float64 // The current a channel of a pixel draws at full brightness.
+	IdleMilliamps       float64 // The current a pixel draws when it's off.
+	SupplyMilliamps     float64 // The most current the strip can draw, or 0 for no limit.
+}
+
+// Draw estimates the current the frame draws at the brightness, in mA. It ignores gamma correction, which only
+// lowers the draw, so it errs on the safe side.
+func (b PowerBudget) Draw(frame Frame, brightness float64) float64 {
+	var channels float64
+	for _, p := range frame {
+		channels += float64(int(p.R) + int(p.G) + int(p.B))
+	}
+	return b.IdleMilliamps*float64(len(frame)) + channels/255*b.MilliampsPerChannel*brightness
+}
+
+// Limit returns the brightness the frame can be shown at within the budget, which is the brightness unless the frame
+// would draw too much.
+func (b PowerBudget) Limit(frame Frame, brightness float64) float64 {
+	if b.SupplyMilliamps <= 0 {
+		return brightness
+	}
+	idle := b.IdleMilliamps * float64(len(frame))
+	draw := b.Draw(frame, brightness) - idle
+	if available := b.SupplyMilliamps - idle; draw > available {
+		if available <= 0 {
+			return 0
+		}
+		return brightness * available / draw
+	}
+	return brightness
+}
+
+// Limits caps the brightness of every device's lights, whatever effects and commands ask for.
+type Limits struct {
+	MaxBrightness   float64 // The brightest the lights get, from 0 to 1.
+	NightBrightness float64 // The brightest the lights get at night, from 0 to 1.
+	NightStart      int     // The hour night starts, from 0 to 23.
+	NightEnd        int     // The hour night ends, from 0 to 23. Night is off if it's the same as the start.
+}
+
+// Brightness returns the brightest the lights can be at the time.
+func (l Limits) Brightness(now time.Time) float64 {
+	if l.night(now.Hour()) && l.NightBrightness < l.MaxBrightness {
+		return l.NightBrightness
+	}
+	return l.MaxBrightness
+}
+
+// night returns whether the hour is at night, which can run past midnight.
+func (l Limits) night(hour int) bool {
+	if l.NightStart <= l.NightEnd {
+		return hour >= l.NightStart && hour < l.NightEnd
+	}
+	return hour >= l.NightStart || hour < l.NightEnd
+}
+
+var limits = Limits{MaxBrightness: 1, NightBrightness: 1}
+var limitsMu sync.RWMutex
+
+// SetLimits sets the caps on the brightness of every device's lights. Brightnesses outside 0 to 1 are clamped.
+func SetLimits(l Limits) error {
+	for _, hour := range []int{l.NightStart, l.NightEnd} {
+		if hour < 0 || hour > 23 {
+			return fmt.Errorf("night hours must be from 0 to 23, got %d", hour)
+		}
+	}
+	l.MaxBrightness = clamp(l.MaxBrightness)
+	l.NightBrightness = clamp(l.NightBrightness)
+
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	limits = l
+	return nil
+}
+
+// clamp keeps the brightness from 0 to 1.
+func clamp(brightness float64) float64 {
+	return math.Max(0, math.Min(1, brightness))
+}
+
+// currentLimits returns the caps on the brightness of every device's lights.
+func currentLimits() Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return limits
+}
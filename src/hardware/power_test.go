@@ -0,0 +1,159 @@
+package hardware
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+func TestSetLimits(t *testing.T) {
+	t.Cleanup(func() { limits = Limits{MaxBrightness: 1, NightBrightness: 1} })
+
+	tests := []struct {
+		name   string
+		limits Limits
+		want   Limits
+		valid  bool
+	}{
+		{"in range", Limits{0.8, 0.2, 22, 7}, Limits{0.8, 0.2, 22, 7}, true},
+		{"brightnesses clamped", Limits{1.5, -0.5, 0, 0}, Limits{1, 0, 0, 0}, true},
+		{"last hour", Limits{1, 1, 23, 23}, Limits{1, 1, 23, 23}, true},
+		{"start after the last hour", Limits{1, 1, 24, 7}, Limits{}, false},
+		{"negative end", Limits{1, 1, 22, -1}, Limits{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits = Limits{MaxBrightness: 1, NightBrightness: 1}
+			err := SetLimits(test.limits)
+			if (err == nil) != test.valid {
+				t.Fatalf("SetLimits(%+v) error = %v, want valid %v", test.limits, err, test.valid)
+			}
+			if !test.valid {
+				if got := currentLimits(); got != (Limits{MaxBrightness: 1, NightBrightness: 1}) {
+					t.Errorf("limits = %+v, want them left as they were", got)
+				}
+				return
+			}
+			if got := currentLimits(); got != test.want {
+				t.Errorf("limits = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLimitsBrightness(t *testing.T) {
+	overnight := Limits{MaxBrightness: 0.8, NightBrightness: 0.2, NightStart: 22, NightEnd: 7}
+	tests := []struct {
+		limits Limits
+		hour   int
+		want   float64
+	}{
+		{overnight, 21, 0.8},
+		{overnight, 22, 0.2},
+		{overnight, 3, 0.2},
+		{overnight, 7, 0.8},
+		{Limits{MaxBrightness: 0.5, NightBrightness: 1, NightStart: 1, NightEnd: 5}, 3, 0.5},
+		{Limits{MaxBrightness: 0.8, NightBrightness: 0.2}, 0, 0.8},
+	}
+	for _, test := range tests {
+		now := time.Date(2021, 1, 1, test.hour, 30, 0, 0, time.Local)
+		if got := test.limits.Brightness(now); got != test.want {
+			t.Errorf("%+v at %d:30: brightness = %v, want %v", test.limits, test.hour, got, test.want)
+		}
+	}
+}
+
+func TestPowerBudgetDraw(t *testing.T) {
+	budget := PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1}
+	tests := []struct {
+		name       string
+		frame      Frame
+		brightness float64
+		want       float64
+	}{
+		{"off", Frame{colors.Black, colors.Black}, 1, 2},
+		{"white", Frame{colors.White, colors.White}, 1, 122},
+		{"white at half brightness", Frame{colors.White, colors.White}, 0.5, 62},
+		{"one channel", Frame{{R: 255}, colors.Black}, 1, 22},
+	}
+	for _, test := range tests {
+		if got := budget.Draw(test.frame, test.brightness); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: draw = %vmA, want %vmA", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPowerBudgetLimit(t *testing.T) {
+	white := Frame{colors.White, colors.White}
+	tests := []struct {
+		name       string
+		budget     PowerBudget
+		frame      Frame
+		brightness float64
+		want       float64
+	}{
+		{"no supply limit", PowerBudget{MilliampsPerChannel: 20}, white, 1, 1},
+		{"under budget", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 200}, white, 1, 1},
+		{"exactly on budget", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 122}, white, 1, 1},
+		// 2mA idle leaves 60mA for the 120mA the pixels would draw.
+		{"over budget", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 62}, white, 1, 0.5},
+		{"over budget when dimmed", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 62}, white, 0.8, 0.5},
+		{"dim enough already", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 62}, white, 0.4, 0.4},
+		{"off within the idle current", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 2}, Frame{colors.Black, colors.Black}, 1, 1},
+		{"idle current uses the whole supply", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 1, SupplyMilliamps: 2}, white, 1, 0},
+		{"idle current over the supply", PowerBudget{MilliampsPerChannel: 20, IdleMilliamps: 40, SupplyMilliamps: 62}, white, 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.budget.Limit(test.frame, test.brightness)
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Fatalf("brightness = %v, want %v", got, test.want)
+			}
+			// Dimmed frames draw exactly what the supply can give.
+			if got < test.brightness && got > 0 {
+				if draw := test.budget.Draw(test.frame, got); math.Abs(draw-test.budget.SupplyMilliamps) > 1e-9 {
+					t.Errorf("dimmed frame draws %vmA, want %vmA", draw, test.budget.SupplyMilliamps)
+				}
+			}
+		})
+	}
+}
+
+func TestRendererLimitsBrightness(t *testing.T) {
+	t.Cleanup(func() { limits = Limits{MaxBrightness: 1, NightBrightness: 1} })
+
+	tests := []struct {
+		name   string
+		supply float64
+		max    float64
+		want   float64
+	}{
+		{"within budget", 0, 1, 1},
+		{"over budget", 60, 1, 0.5},
+		{"capped under budget", 60, 0.4, 0.4},
+		{"capped over budget", 30, 0.4, 0.25},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := SetLimits(Limits{MaxBrightness: test.max, NightBrightness: 1}); err != nil {
+				t.Fatal(err)
+			}
+			r, driver := newTestRenderer(t, 2)
+			r.power = PowerBudget{MilliampsPerChannel: 20, SupplyMilliamps: test.supply}
+			r.SetShow(fill{colors.White, 0})
+			r.Trigger(Beat{Duration: time.Second})
+			r.renderFrame(time.Now())
+
+			shown, brightness := driver.Shown()
+			if math.Abs(brightness-test.want) > 1e-9 {
+				t.Errorf("driver brightness = %v, want %v", brightness, test.want)
+			}
+			// The pixels are left as they are, for the driver to dim.
+			if shown[0] != colors.White {
+				t.Errorf("pixel = %v, want white", shown[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package hardware
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// DefaultFPS is how many frames a second are rendered if the config doesn't say.
+const DefaultFPS = 60
+
+// Frame holds the color of every pixel on the line effects draw along.
+type Frame []colors.Color
+
+// Grid is a frame laid out in rows, for effects that draw on a matrix in two dimensions.
+type Grid struct {
+	Frame  // The pixels, row by row from the top left.
+	Width  int
+	Height int
+}
+
+// At returns the color of the pixel at x, y, where 0, 0 is the top left.
+func (g Grid) At(x, y int) colors.Color {
+	return g.Frame[y*g.Width+x]
+}
+
+// Set sets the color of the pixel at x, y, where 0, 0 is the top left.
+func (g Grid) Set(x, y int, color colors.Color) {
+	g.Frame[y*g.Width+x] = color
+}
+
+// Beat is a single trigger of the lightshow, which effects are timed against.
+type Beat struct {
+	Type     models.TriggerType
+	Number   int           // The index of the trigger in the track.
+	Position time.Duration // How far through the track the trigger is.
+	Duration time.Duration // How long the trigger lasts.
+	Color    colors.Color  // The color of the trigger.
+}
+
+// Clock says where the lightshow is relative to the beat when a frame is rendered.
+type Clock struct {
+	Beat     Beat                       // The latest trigger.
+	Since    time.Duration              // How long ago the latest trigger started.
+	Analysis *models.MediaAudioAnalysis // The analysis of the playing media, or nil if it isn't known.
+}
+
+// Position returns how far through the track the clock is.
+func (c Clock) Position() time.Duration {
+	return c.Beat.Position + c.Since
+}
+
+// Phase returns how far through the latest trigger the clock is, from 0 to 1.
+func (c Clock) Phase() float64 {
+	if c.Beat.Duration <= 0 || c.Since >= c.Beat.Duration {
+		return 1
+	}
+	return float64(c.Since) / float64(c.Beat.Duration)
+}
+
+// Effect is an animation drawn into frames.
+type Effect interface {
+	// Render draws the effect into the frame, elapsed since the effect started. It must only depend on its
+	// arguments. It returns false once the effect has finished.
+	Render(frame Frame, elapsed time.Duration, clock Clock) bool
+}
+
+// GridEffect is an effect that can also draw on a matrix in two dimensions. Other effects are drawn along the line,
+// lighting a whole column of the matrix with each pixel.
+type GridEffect interface {
+	Effect
+
+	// RenderGrid draws the effect into the grid, as Render draws into a frame.
+	RenderGrid(grid Grid, elapsed time.Duration, clock Clock) bool
+}
+
+// Blend is how a layer is mixed with the layers below it.
+type Blend int
+
+const (
+	Over Blend = iota // The layer's lit pixels cover the pixels below.
+	Add               // The layer's pixels are added to the pixels below.
+	Max               // Each channel takes the brightest of the layer and the pixels below.
+)
+
+// Layer is an effect being played.
+type Layer struct {
+	Effect  Effect
+	Blend   Blend
+	Elapsed time.Duration // How long the effect has been playing.
+}
+
+// RenderFrame draws the layers, from the bottom up, into a new frame of the pixels on the canvas's strip. It also
+// returns whether each layer is still playing.
+func RenderFrame(canvas Canvas, layers []Layer, clock Clock) (Frame, []bool) {
+	frame := make(Frame, canvas.NumPixels)
+	line := make(Frame, len(canvas.Line))
+	var grid Grid
+	if canvas.Grid != nil {
+		grid = Grid{Frame: make(Frame, canvas.Grid.Width*canvas.Grid.Height), Width: canvas.Grid.Width, Height: canvas.Grid.Height}
+	}
+
+	playing := make([]bool, len(layers))
+	for i, layer := range layers {
+		var layerFrame Frame
+		if gridEffect, ok := layer.Effect.(GridEffect); ok && canvas.Grid != nil {
+			blank(grid.Frame)
+			playing[i] = gridEffect.RenderGrid(grid, layer.Elapsed, clock)
+			layerFrame = make(Frame, canvas.NumPixels)
+			canvas.Grid.apply(grid, layerFrame)
+		} else {
+			blank(line)
+			playing[i] = layer.Effect.Render(line, layer.Elapsed, clock)
+			layerFrame = canvas.Line.Apply(line, canvas.NumPixels)
+		}
+		blend(frame, layerFrame, layer.Blend)
+	}
+	return frame, playing
+}
+
+// blank turns every pixel of the frame off.
+func blank(frame Frame) {
+	for i := range frame {
+		frame[i] = colors.Black
+	}
+}
+
+// blend mixes the layer into the frame.
+func blend(frame Frame, layer Frame, mode Blend) {
+	for i, p := range layer {
+		switch mode {
+		case Over:
+			if p != colors.Black {
+				frame[i] = p
+			}
+		case Add:
+			frame[i] = frame[i].Add(p)
+		case Max:
+			frame[i] = frame[i].Max(p)
+		}
+	}
+}
+
+// playingLayer is a layer being played by the renderer.
+type playingLayer struct {
+	effect  Effect
+	blend   Blend
+	started time.Time
+}
+
+// Renderer draws effects onto a light driver at a fixed frame rate. It owns the driver, so effects triggered at the
+// same time are layered rather than fighting over the strip.
+type Renderer struct {
+	driver  LightDriver
+	canvas  Canvas
+	fps     int
+	power   PowerBudget
+	limited bool // Whether the last frame was dimmed to stay within the power budget.
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu          sync.Mutex
+	show        *playingLayer // The effect that plays underneath all the others, until it's replaced.
+	showing     bool          // Whether the show is drawn, which it is from the first trigger until it's stopped.
+	layers      []*playingLayer
+	beat        Beat
+	triggeredAt time.Time
+	analysis    *models.MediaAudioAnalysis
+	brightness  float64
+	paused      bool // Whether the strip is blanked.
+}
+
+// DeviceConfig holds the settings of a device the lightshow is rendered on.
+type DeviceConfig struct {
+	Layout Layout      // How the device's pixels are arranged.
+	FPS    int         // How many frames a second are rendered.
+	Power  PowerBudget // How much current the device can draw.
+}
+
+// NewRenderer starts rendering to the driver with the config.
+func NewRenderer(driver LightDriver, config DeviceConfig) (*Renderer, error) {
+	canvas, err := config.Layout.Canvas(driver.NumPixels())
+	if err != nil {
+		return nil, err
+	}
+	if config.FPS <= 0 {
+		config.FPS = DefaultFPS
+	}
+	if config.Power.MilliampsPerChannel <= 0 {
+		config.Power.MilliampsPerChannel = DefaultMilliampsPerChannel
+	}
+	r := &Renderer{
+		driver:      driver,
+		canvas:      canvas,
+		fps:         config.FPS,
+		power:       config.Power,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		triggeredAt: time.Now(),
+		brightness:  1,
+	}
+	go r.run()
+	return r, nil
+}
+
+// Trigger moves the beat clock on to the trigger, showing the show if it has been stopped.
+func (r *Renderer) Trigger(beat Beat) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.beat = beat
+	r.triggeredAt = time.Now()
+	r.showing = true
+}
+
+// Play starts the effect on top of the effects already playing.
+func (r *Renderer) Play(effect Effect, blend Blend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.layers = append(r.layers, &playingLayer{effect: effect, blend: blend, started: time.Now()})
+}
+
+// SetShow replaces the effect that plays underneath all the others.
+func (r *Renderer) SetShow(effect Effect) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.show = &playingLayer{effect: effect, blend: Over, started: time.Now()}
+}
+
+// SetAnalysis sets the analysis of the playing media, for effects that follow more than the beat.
+func (r *Renderer) SetAnalysis(analysis *models.MediaAudioAnalysis) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.analysis = analysis
+}
+
+// Stop stops every effect, other than the show.
+func (r *Renderer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.layers = nil
+}
+
+// StopShow stops drawing the show until the next trigger, for when the track stops playing.
+func (r *Renderer) StopShow() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.showing = false
+	r.beat = Beat{}
+}
+
+// SetPaused blanks the strip while paused. Effects carry on playing underneath, so they're where they should be when
+// the strip is resumed.
+func (r *Renderer) SetPaused(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = paused
+}
+
+// SetBrightness sets the brightness of the strip, from 0 to 1.
+func (r *Renderer) SetBrightness(brightness float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.brightness = brightness
+}
+
+// Close stops rendering, turns the strip off and closes the driver.
+func (r *Renderer) Close() error {
+	close(r.stop)
+	<-r.done
+	r.driver.Clear()
+	r.driver.Show()
+	return r.driver.Close()
+}
+
+func (r *Renderer) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(time.Second / time.Duration(r.fps))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			r.renderFrame(now)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// renderFrame renders the playing effects at the time, and shows them on the strip.
+func (r *Renderer) renderFrame(now time.Time) {
+	r.mu.Lock()
+	playing := r.layers
+	if r.show != nil && r.showing {
+		playing = append([]*playingLayer{r.show}, playing...)
+	}
+	layers := make([]Layer, len(playing))
+	for i, l := range playing {
+		layers[i] = Layer{Effect: l.effect, Blend: l.blend, Elapsed: now.Sub(l.started)}
+	}
+	clock := Clock{Beat: r.beat, Since: now.Sub(r.triggeredAt), Analysis: r.analysis}
+	brightness, paused := r.brightness, r.paused
+	r.mu.Unlock()
+
+	pixels, stillPlaying := RenderFrame(r.canvas, layers, clock)
+	if paused {
+		pixels = make(Frame, len(pixels))
+	}
+
+	// Drop the finished effects. Effects may have been played or stopped while the frame was rendered.
+	finished := map[*playingLayer]bool{}
+	for i, l := range playing {
+		if !stillPlaying[i] {
+			finished[l] = true
+		}
+	}
+	r.mu.Lock()
+	var remaining []*playingLayer
+	for _, l := range r.layers {
+		if !finished[l] {
+			remaining = append(remaining, l)
+		}
+	}
+	r.layers = remaining
+	r.mu.Unlock()
+
+	// Cap the brightness, then dim the frame further if it would draw too much current.
+	brightness = math.Min(brightness, currentLimits().Brightness(now))
+	limited := r.power.Limit(pixels, brightness)
+	if overBudget := limited < brightness; overBudget != r.limited {
+		r.limited = overBudget
+		if overBudget {
+			log.Printf("Dimming lights to stay within %.0fmA\n", r.power.SupplyMilliamps)
+		}
+	}
+
+	r.driver.SetBrightness(limited)
+	for i, p := range pixels {
+		r.driver.SetPixel(i, p.R, p.G, p.B)
+	}
+	if err := r.driver.Show(); err != nil {
+		log.Println("Failed to show lights:", err)
+	}
+}
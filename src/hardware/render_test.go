@@ -0,0 +1,338 @@
+package hardware
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+var (
+	red   = colors.Color{R: 255}
+	blue  = colors.Color{B: 255}
+	dim   = colors.Color{R: 100, G: 100, B: 100}
+	mixed = colors.Color{R: 200, G: 50, B: 10}
+)
+
+// recordingDriver is a light driver that remembers what was last shown.
+type recordingDriver struct {
+	mu         sync.Mutex
+	pixels     []colors.Color
+	shown      []colors.Color
+	brightness float64
+	shows      int
+}
+
+func newRecordingDriver(numPixels int) *recordingDriver {
+	return &recordingDriver{pixels: make([]colors.Color, numPixels), shown: make([]colors.Color, numPixels)}
+}
+
+func (d *recordingDriver) NumPixels() int { return len(d.pixels) }
+
+func (d *recordingDriver) SetPixel(pixel int, r, g, b uint8) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pixels[pixel] = colors.Color{R: r, G: g, B: b}
+}
+
+func (d *recordingDriver) SetBrightness(brightness float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.brightness = brightness
+}
+
+func (d *recordingDriver) Show() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.shown, d.pixels)
+	d.shows++
+	return nil
+}
+
+func (d *recordingDriver) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.pixels {
+		d.pixels[i] = colors.Black
+	}
+}
+
+func (d *recordingDriver) Close() error { return nil }
+
+// Shown returns the pixels last shown, and the brightness they were shown at.
+func (d *recordingDriver) Shown() ([]colors.Color, float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]colors.Color(nil), d.shown...), d.brightness
+}
+
+// fill lights every pixel in a color until it has played for its length.
+type fill struct {
+	color  colors.Color
+	length time.Duration
+}
+
+func (f fill) Render(frame Frame, elapsed time.Duration, clock Clock) bool {
+	for i := range frame {
+		frame[i] = f.color
+	}
+	return elapsed < f.length
+}
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		name  string
+		below colors.Color
+		layer colors.Color
+		mode  Blend
+		want  colors.Color
+	}{
+		{"over covers", dim, red, Over, red},
+		{"over leaves black pixels", dim, colors.Black, Over, dim},
+		{"add", dim, mixed, Add, colors.Color{R: 255, G: 150, B: 110}},
+		{"add black", dim, colors.Black, Add, dim},
+		{"max", dim, mixed, Max, colors.Color{R: 200, G: 100, B: 100}},
+		{"max black", dim, colors.Black, Max, dim},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := Frame{test.below, test.below}
+			blend(frame, Frame{test.layer, test.layer}, test.mode)
+			for i, p := range frame {
+				if p != test.want {
+					t.Errorf("pixel %d = %v, want %v", i, p, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		layers  []Layer
+		want    colors.Color
+		playing []bool
+	}{
+		{"no layers", nil, colors.Black, []bool{}},
+		{
+			name:    "layers blend from the bottom up",
+			layers:  []Layer{{Effect: fill{red, time.Second}}, {Effect: fill{blue, time.Second}, Blend: Add}},
+			want:    colors.Color{R: 255, B: 255},
+			playing: []bool{true, true},
+		},
+		{
+			name:    "over covers the layers below",
+			layers:  []Layer{{Effect: fill{red, time.Second}}, {Effect: fill{dim, time.Second}, Blend: Over}},
+			want:    dim,
+			playing: []bool{true, true},
+		},
+		{
+			name: "finished layers are drawn one last time",
+			layers: []Layer{
+				{Effect: fill{dim, time.Second}, Elapsed: 2 * time.Second},
+				{Effect: fill{mixed, time.Second}, Blend: Max},
+			},
+			want:    colors.Color{R: 200, G: 100, B: 100},
+			playing: []bool{false, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame, playing := RenderFrame(Canvas{NumPixels: 3, Line: Mapping{{0}, {1}, {2}}}, test.layers, Clock{})
+			if len(frame) != 3 {
+				t.Fatalf("frame has %d pixels, want 3", len(frame))
+			}
+			for i, p := range frame {
+				if p != test.want {
+					t.Errorf("pixel %d = %v, want %v", i, p, test.want)
+				}
+			}
+			if len(playing) != len(test.playing) {
+				t.Fatalf("playing = %v, want %v", playing, test.playing)
+			}
+			for i := range playing {
+				if playing[i] != test.playing[i] {
+					t.Errorf("playing = %v, want %v", playing, test.playing)
+				}
+			}
+		})
+	}
+}
+
+// newTestRenderer returns a renderer that only renders frames when the test asks.
+func newTestRenderer(t *testing.T, numPixels int) (*Renderer, *recordingDriver) {
+	t.Helper()
+	driver := newRecordingDriver(numPixels)
+	canvas, err := Layout{}.Canvas(numPixels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Renderer{
+		driver:      driver,
+		canvas:      canvas,
+		fps:         DefaultFPS,
+		power:       PowerBudget{MilliampsPerChannel: DefaultMilliampsPerChannel},
+		triggeredAt: time.Now(),
+		brightness:  1,
+	}
+	return r, driver
+}
+
+func TestRendererDropsFinishedLayers(t *testing.T) {
+	r, driver := newTestRenderer(t, 4)
+	start := time.Now()
+	r.SetShow(fill{dim, 0})
+	r.Trigger(Beat{Duration: time.Second})
+	r.Play(fill{red, 100 * time.Millisecond}, Over)
+	r.Play(fill{blue, time.Second}, Add)
+
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != (colors.Color{R: 255, B: 255}) {
+		t.Errorf("pixel = %v, want both layers", shown[0])
+	}
+
+	// The red layer finishes, and is dropped after being drawn one last time.
+	r.renderFrame(start.Add(200 * time.Millisecond))
+	r.renderFrame(start.Add(300 * time.Millisecond))
+	if len(r.layers) != 1 {
+		t.Fatalf("%d layers still playing, want 1", len(r.layers))
+	}
+	if shown, _ := driver.Shown(); shown[0] != (colors.Color{R: 100, G: 100, B: 255}) {
+		t.Errorf("pixel = %v, want the show with the blue layer added", shown[0])
+	}
+
+	// The show is never dropped, even though its effect has finished.
+	r.renderFrame(start.Add(2 * time.Second))
+	r.renderFrame(start.Add(2100 * time.Millisecond))
+	if len(r.layers) != 0 {
+		t.Fatalf("%d layers still playing, want 0", len(r.layers))
+	}
+	if shown, _ := driver.Shown(); shown[0] != dim {
+		t.Errorf("pixel = %v, want the show", shown[0])
+	}
+}
+
+func TestRendererPauseAndStop(t *testing.T) {
+	r, driver := newTestRenderer(t, 2)
+	start := time.Now()
+	r.SetShow(fill{dim, 0})
+
+	// The show isn't drawn until the first trigger.
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != colors.Black {
+		t.Errorf("pixel = %v before the first trigger, want black", shown[0])
+	}
+	r.Trigger(Beat{Duration: time.Second})
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != dim {
+		t.Errorf("pixel = %v after a trigger, want the show", shown[0])
+	}
+
+	// Pausing blanks everything, including effects played while paused.
+	r.SetPaused(true)
+	r.Play(fill{red, time.Hour}, Over)
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != colors.Black {
+		t.Errorf("pixel = %v while paused, want black", shown[0])
+	}
+	r.SetPaused(false)
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != red {
+		t.Errorf("pixel = %v once resumed, want the effect", shown[0])
+	}
+
+	// Stopping the show leaves other effects playing, until the next trigger brings the show back.
+	r.Stop()
+	r.StopShow()
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != colors.Black {
+		t.Errorf("pixel = %v once stopped, want black", shown[0])
+	}
+	r.Trigger(Beat{Duration: time.Second})
+	r.renderFrame(start)
+	if shown, _ := driver.Shown(); shown[0] != dim {
+		t.Errorf("pixel = %v after the next trigger, want the show", shown[0])
+	}
+}
+
+func TestDevicesBrightnessAndPause(t *testing.T) {
+	r, driver := newTestRenderer(t, 2)
+	devicesMu.Lock()
+	devices = map[string]*device{"test": {renderer: r}}
+	devicesMu.Unlock()
+	t.Cleanup(func() {
+		devicesMu.Lock()
+		devices = map[string]*device{}
+		devicesMu.Unlock()
+	})
+
+	SetBrightness(0.25)
+	r.renderFrame(time.Now())
+	if _, brightness := driver.Shown(); brightness != 0.25 {
+		t.Errorf("brightness = %v before the next trigger, want 0.25", brightness)
+	}
+
+	r.Play(fill{red, time.Hour}, Over)
+	Pause(true)
+	r.renderFrame(time.Now())
+	if shown, _ := driver.Shown(); shown[0] != colors.Black {
+		t.Errorf("pixel = %v while paused, want black", shown[0])
+	}
+	Pause(false)
+	r.renderFrame(time.Now())
+	if shown, _ := driver.Shown(); shown[0] != red {
+		t.Errorf("pixel = %v once resumed, want the effect", shown[0])
+	}
+}
+
+func TestStartUpFinishes(t *testing.T) {
+	green := colors.Color{G: 255}
+	tests := []struct {
+		name    string
+		pixels  int
+		elapsed time.Duration
+		lit     []bool
+		playing bool
+	}{
+		{"fading in, odd strip", 5, startUpFade / 2, []bool{false, false, true, false, false}, true},
+		{"fading in, even strip", 4, startUpFade / 2, []bool{false, true, true, false}, true},
+		{"spreading out", 5, startUpFade, []bool{false, true, true, true, false}, true},
+		{"fully lit", 5, startUpFade + 2*startUpStep, []bool{true, true, true, true, true}, true},
+		{"finished", 5, startUpFade + 3*startUpStep, []bool{false, false, false, false, false}, false},
+		{"finished, even strip", 4, startUpFade + 3*startUpStep, []bool{false, false, false, false}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := make(Frame, test.pixels)
+			playing := StartUp{Color: green}.Render(frame, test.elapsed, Clock{})
+			if playing != test.playing {
+				t.Errorf("playing = %v, want %v", playing, test.playing)
+			}
+			for i, p := range frame {
+				if lit := p != colors.Black; lit != test.lit[i] {
+					t.Errorf("pixel %d = %v, want lit %v", i, p, test.lit[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChaseBrightness(t *testing.T) {
+	chase, err := NewEffect("chase", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make(Frame, 10)
+	clock := Clock{Beat: Beat{Duration: time.Second, Color: colors.Color{R: 200, G: 100}}}
+	chase.Render(frame, 0, clock)
+
+	// Beat sequences have always been shown at half brightness, with faint neighbours.
+	if frame[0] != (colors.Color{R: 100, G: 50}) {
+		t.Errorf("pixel = %v, want half the trigger's color", frame[0])
+	}
+	if frame[1] != (colors.Color{R: 100, G: 50}).Scale(0.08) {
+		t.Errorf("neighbour = %v, want a faint glow", frame[1])
+	}
+}
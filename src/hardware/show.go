@@ -0,0 +1,52 @@
+package hardware
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Show is the lightshow of a whole track, worked out from its analysis, so effects can be rendered at any point in
+// the track without playing it.
+type Show struct {
+	Analysis models.MediaAudioAnalysis
+	Trigger  models.TriggerType
+	Palette  []colors.Color // The colors the triggers cycle through.
+}
+
+// Duration returns how long the track is.
+func (s Show) Duration() time.Duration {
+	return time.Duration(s.Analysis.Track.Duration * float64(time.Second))
+}
+
+// ClockAt returns the beat clock at the time into the track, as the renderer would have it while the track plays.
+func (s Show) ClockAt(at time.Duration) Clock {
+	triggers := s.Analysis.Beats
+	if s.Trigger == models.Bar {
+		triggers = s.Analysis.Bars
+	}
+	seconds := at.Seconds()
+	i := sort.Search(len(triggers), func(i int) bool {
+		return triggers[i].Start > seconds
+	}) - 1
+
+	clock := Clock{Since: at, Analysis: &s.Analysis}
+	if i < 0 {
+		// Before the first trigger.
+		return clock
+	}
+	start := time.Duration(triggers[i].Start * float64(time.Second))
+	clock.Beat = Beat{
+		Type:     s.Trigger,
+		Number:   i,
+		Position: start,
+		Duration: time.Duration(triggers[i].Duration * float64(time.Second)),
+	}
+	if len(s.Palette) > 0 {
+		clock.Beat.Color = s.Palette[i%len(s.Palette)]
+	}
+	clock.Since = at - start
+	return clock
+}
@@ -0,0 +1,140 @@
+package hardware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// DefaultSimulatorPixels is how many pixels the simulator shows if the config doesn't say, as many as a Blinkt.
+const DefaultSimulatorPixels = 8
+
+// Simulator shows the strip in the terminal, so the lightshow can be run without a Raspberry Pi. Terminals get a
+// line of truecolor blocks that's redrawn every frame. Anything else, such as a log file, gets a line of hex colors
+// every time the strip changes.
+type Simulator struct {
+	out   io.Writer
+	tty   bool
+	gamma *colors.GammaTable
+	start time.Time
+
+	mu         sync.Mutex
+	pixels     []colors.Color
+	brightness float64
+	last       string // The last frame written, so unchanged frames aren't logged again.
+}
+
+// NewSimulator creates a simulated strip with the number of pixels, shown on the file with colors corrected by the
+// gamma table.
+func NewSimulator(out *os.File, numPixels int, gamma *colors.GammaTable) *Simulator {
+	return newSimulator(out, isTerminal(out), numPixels, gamma)
+}
+
+// newSimulator creates a simulated strip shown on the writer, drawn for a terminal if tty is true.
+func newSimulator(out io.Writer, tty bool, numPixels int, gamma *colors.GammaTable) *Simulator {
+	if numPixels <= 0 {
+		numPixels = DefaultSimulatorPixels
+	}
+	return &Simulator{
+		out:        out,
+		tty:        tty,
+		gamma:      gamma,
+		start:      time.Now(),
+		pixels:     make([]colors.Color, numPixels),
+		brightness: 1,
+	}
+}
+
+// isTerminal returns whether the file is a terminal, rather than a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// NumPixels returns how many pixels are on the simulated strip.
+func (s *Simulator) NumPixels() int {
+	return len(s.pixels)
+}
+
+// SetPixel sets the color of a pixel.
+func (s *Simulator) SetPixel(pixel int, r, g, b uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pixel >= 0 && pixel < len(s.pixels) {
+		s.pixels[pixel] = colors.Color{R: r, G: g, B: b}
+	}
+}
+
+// SetBrightness sets the brightness of every pixel. Like the real drivers, it scales the gamma corrected colors.
+func (s *Simulator) SetBrightness(brightness float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if brightness < 0 {
+		brightness = 0
+	} else if brightness > 1 {
+		brightness = 1
+	}
+	s.brightness = brightness
+}
+
+// Show draws the strip.
+func (s *Simulator) Show() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var frame strings.Builder
+	if s.tty {
+		// Go back to the start of the line, and draw over the last frame.
+		frame.WriteString("\r")
+		for _, p := range s.pixels {
+			c := s.gamma.Correct(p).Scale(s.brightness)
+			fmt.Fprintf(&frame, "\x1b[38;2;%d;%d;%dm██", c.R, c.G, c.B)
+		}
+		frame.WriteString("\x1b[0m")
+	} else {
+		for i, p := range s.pixels {
+			if i > 0 {
+				frame.WriteString(" ")
+			}
+			frame.WriteString(s.gamma.Correct(p).Scale(s.brightness).Hex())
+		}
+		if frame.String() == s.last {
+			return nil
+		}
+		s.last = frame.String()
+		frame.WriteString("\n")
+		return s.write(fmt.Sprintf("%9.3fs %s", time.Since(s.start).Seconds(), frame.String()))
+	}
+	return s.write(frame.String())
+}
+
+func (s *Simulator) write(frame string) error {
+	_, err := io.WriteString(s.out, frame)
+	return err
+}
+
+// Clear turns every pixel off.
+func (s *Simulator) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.pixels {
+		s.pixels[i] = colors.Black
+	}
+}
+
+// Close clears the strip, and moves terminals on to a new line.
+func (s *Simulator) Close() error {
+	s.Clear()
+	if err := s.Show(); err != nil {
+		return err
+	}
+	if s.tty {
+		return s.write("\n")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package hardware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+func TestSimulatorLog(t *testing.T) {
+	var out bytes.Buffer
+	s := newSimulator(&out, false, 3, colors.NewGammaTable(2.2))
+	s.SetPixel(0, 255, 128, 0)
+	s.SetPixel(1, 255, 255, 255)
+	s.SetPixel(5, 255, 255, 255) // Off the end of the strip.
+	s.SetBrightness(0.5)
+	if err := s.Show(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Gamma correction turns 128 into 56, then the brightness halves every channel.
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], "s 801C00 808080 000000") {
+		t.Fatalf("output = %q, want one line of gamma corrected then dimmed colors", out.String())
+	}
+
+	// Unchanged frames aren't logged again.
+	if err := s.Show(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetBrightness(2)
+	s.Clear()
+	s.SetPixel(2, 0, 0, 255)
+	if err := s.Show(); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], "s 000000 000000 0000FF") {
+		t.Fatalf("output = %q, want a second line with full brightness", out.String())
+	}
+}
+
+func TestSimulatorTerminal(t *testing.T) {
+	var out bytes.Buffer
+	s := newSimulator(&out, true, 2, colors.NewGammaTable(2.2))
+	s.SetPixel(0, 128, 64, 1)
+	s.SetBrightness(0.5)
+	if err := s.Show(); err != nil {
+		t.Fatal(err)
+	}
+	want := "\r\x1b[38;2;28;6;0m██\x1b[38;2;0;0;0m██\x1b[0m"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	// Terminals are redrawn every frame, and moved on to a new line when closed.
+	out.Reset()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\r\x1b[38;2;0;0;0m██\x1b[38;2;0;0;0m██\x1b[0m\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestSimulatorDefaultPixels(t *testing.T) {
+	if n := newSimulator(&bytes.Buffer{}, false, 0, colors.NewGammaTable(1)).NumPixels(); n != DefaultSimulatorPixels {
+		t.Errorf("%d pixels, want %d", n, DefaultSimulatorPixels)
+	}
+}
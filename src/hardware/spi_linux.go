@@ -0,0 +1,38 @@
+package hardware
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The spidev ioctls that set up the bus, from linux/spi/spidev.h.
+const (
+	spiIOCWrMode        = 0x40016b01
+	spiIOCWrBitsPerWord = 0x40016b03
+	spiIOCWrMaxSpeedHz  = 0x40046b04
+)
+
+// openSPI opens the spidev device in mode 0, with 8 bit words, at the speed in Hz.
+func openSPI(device string, speed uint32) (io.WriteCloser, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	mode, bits := uint8(0), uint8(8)
+	for _, setting := range []struct {
+		request uintptr
+		value   unsafe.Pointer
+	}{
+		{spiIOCWrMode, unsafe.Pointer(&mode)},
+		{spiIOCWrBitsPerWord, unsafe.Pointer(&bits)},
+		{spiIOCWrMaxSpeedHz, unsafe.Pointer(&speed)},
+	} {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), setting.request, uintptr(setting.value)); errno != 0 {
+			f.Close()
+			return nil, errno
+		}
+	}
+	return f, nil
+}
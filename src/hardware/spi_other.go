@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package hardware
+
+import (
+	"errors"
+	"io"
+)
+
+// openSPI fails, as spidev devices are only on Linux.
+func openSPI(device string, speed uint32) (io.WriteCloser, error) {
+	return nil, errors.New("SPI is only supported on Linux")
+}
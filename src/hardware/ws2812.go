@@ -0,0 +1,181 @@
+package hardware
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+const (
+	// DefaultSPIDevice is the SPI device addressable LEDs are driven on if the config doesn't say. On a Raspberry Pi,
+	// its data pin is GPIO 10.
+	DefaultSPIDevice = "/dev/spidev0.0"
+
+	// WS2812SPISpeed is the clock speed of the SPI bus. At 2.4MHz, 3 SPI bits take as long as one LED bit, 1.25µs.
+	WS2812SPISpeed = 2400000
+
+	// ws2812ResetBytes is how long the bus is held low after a frame so the LEDs latch it, 280µs at 2.4MHz. Older
+	// LEDs only need 50µs, but newer ones need the longer reset.
+	ws2812ResetBytes = 84
+)
+
+// ws2812Bits holds the SPI bits for every byte sent to the LEDs. Each bit of the byte is sent as 3 SPI bits: 100 for
+// a 0, with a short high pulse, and 110 for a 1, with a long one.
+var ws2812Bits = func() (table [256][3]byte) {
+	for value := range table {
+		var bits uint32
+		for bit := 7; bit >= 0; bit-- {
+			bits <<= 3
+			if value&(1<<uint(bit)) != 0 {
+				bits |= 0x6 // 110
+			} else {
+				bits |= 0x4 // 100
+			}
+		}
+		table[value] = [3]byte{byte(bits >> 16), byte(bits >> 8), byte(bits)}
+	}
+	return table
+}()
+
+// WS2812Encoder encodes frames into the SPI bytes that drive WS2812 and SK6812 LEDs.
+type WS2812Encoder struct {
+	order string // The order the LEDs take the channels in, e.g. GRB.
+}
+
+// NewWS2812Encoder creates an encoder for LEDs that take the channels in the order, e.g. GRB for WS2812s or GRBW for
+// RGBW SK6812s. LEDs with a white channel light it with the white in the color.
+func NewWS2812Encoder(order string) (WS2812Encoder, error) {
+	order = strings.ToUpper(order)
+	valid := len(order) == 3 || len(order) == 4
+	for _, channel := range "RGB" {
+		valid = valid && strings.Count(order, string(channel)) == 1
+	}
+	if len(order) == 4 {
+		valid = valid && strings.Count(order, "W") == 1
+	}
+	if !valid {
+		return WS2812Encoder{}, fmt.Errorf("invalid color order %q, expected an order of RGB or RGBW, e.g. GRB", order)
+	}
+	return WS2812Encoder{order: order}, nil
+}
+
+// Channels returns how many channels each LED has.
+func (e WS2812Encoder) Channels() int {
+	return len(e.order)
+}
+
+// Encode returns the SPI bytes that show the pixels, followed by the reset that latches them.
+func (e WS2812Encoder) Encode(pixels []colors.Color) []byte {
+	data := make([]byte, 0, len(pixels)*e.Channels()*3+ws2812ResetBytes)
+	for _, p := range pixels {
+		r, g, b, w := p.R, p.G, p.B, uint8(0)
+		if e.Channels() == 4 {
+			// The white LED takes over the part of the color every channel shares.
+			w = r
+			if g < w {
+				w = g
+			}
+			if b < w {
+				w = b
+			}
+			r, g, b = r-w, g-w, b-w
+		}
+		for _, channel := range e.order {
+			value := w
+			switch channel {
+			case 'R':
+				value = r
+			case 'G':
+				value = g
+			case 'B':
+				value = b
+			}
+			data = append(data, ws2812Bits[value][:]...)
+		}
+	}
+	return append(data, make([]byte, ws2812ResetBytes)...)
+}
+
+// WS2812 drives a strip of WS2812 or SK6812 LEDs from the data line of an SPI bus. Large strips need the spidev
+// buffer raised from its 4096 byte default, e.g. spidev.bufsiz=32768 on the kernel command line, as frames are sent
+// in one write.
+type WS2812 struct {
+	spi        io.WriteCloser
+	encoder    WS2812Encoder
+	gamma      *colors.GammaTable
+	pixels     []colors.Color
+	brightness float64
+}
+
+// NewWS2812 opens the SPI device to drive a strip with the number of pixels, which take their channels in the order.
+// Colors are corrected by the gamma table.
+func NewWS2812(device string, numPixels int, order string, gamma *colors.GammaTable) (*WS2812, error) {
+	if numPixels <= 0 {
+		return nil, fmt.Errorf("the number of pixels on the strip is required")
+	}
+	encoder, err := NewWS2812Encoder(order)
+	if err != nil {
+		return nil, err
+	}
+	if device == "" {
+		device = DefaultSPIDevice
+	}
+	spi, err := openSPI(device, WS2812SPISpeed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SPI device %s: %v", device, err)
+	}
+	return &WS2812{
+		spi:        spi,
+		encoder:    encoder,
+		gamma:      gamma,
+		pixels:     make([]colors.Color, numPixels),
+		brightness: 1,
+	}, nil
+}
+
+// NumPixels returns how many pixels are on the strip.
+func (w *WS2812) NumPixels() int {
+	return len(w.pixels)
+}
+
+// SetPixel sets the color of a pixel.
+func (w *WS2812) SetPixel(pixel int, r, g, b uint8) {
+	if pixel >= 0 && pixel < len(w.pixels) {
+		w.pixels[pixel] = colors.Color{R: r, G: g, B: b}
+	}
+}
+
+// SetBrightness sets the brightness of every pixel. The LEDs have no brightness of their own, so it scales the gamma
+// corrected colors, the same as the Blinkt's own brightness does.
+func (w *WS2812) SetBrightness(brightness float64) {
+	if brightness < 0 {
+		brightness = 0
+	} else if brightness > 1 {
+		brightness = 1
+	}
+	w.brightness = brightness
+}
+
+// Show sends the pixels to the strip.
+func (w *WS2812) Show() error {
+	frame := make([]colors.Color, len(w.pixels))
+	for i, p := range w.pixels {
+		frame[i] = w.gamma.Correct(p).Scale(w.brightness)
+	}
+	_, err := w.spi.Write(w.encoder.Encode(frame))
+	return err
+}
+
+// Clear turns every pixel off.
+func (w *WS2812) Clear() {
+	for i := range w.pixels {
+		w.pixels[i] = colors.Black
+	}
+}
+
+// Close releases the SPI device.
+func (w *WS2812) Close() error {
+	return w.spi.Close()
+}
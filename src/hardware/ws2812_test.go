@@ -0,0 +1,97 @@
+package hardware
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+func TestWS2812Encode(t *testing.T) {
+	// Each bit is sent as 3 SPI bits, 100 for a 0 and 110 for a 1.
+	var (
+		off  = []byte{0x92, 0x49, 0x24}
+		full = []byte{0xDB, 0x6D, 0xB6}
+		alt  = []byte{0xD3, 0x4D, 0x34} // 0xAA, 10101010.
+		rest = []byte{0x9A, 0x69, 0xA6} // 0x55, 01010101.
+	)
+	join := func(channels ...[]byte) []byte {
+		return bytes.Join(channels, nil)
+	}
+
+	tests := []struct {
+		name   string
+		order  string
+		pixels []colors.Color
+		want   []byte
+	}{
+		{"nothing", "GRB", nil, nil},
+		{"off", "RGB", []colors.Color{colors.Black}, join(off, off, off)},
+		{"full", "RGB", []colors.Color{colors.White}, join(full, full, full)},
+		{"mixed bits", "RGB", []colors.Color{{R: 0xAA}}, join(alt, off, off)},
+		{"rgb order", "RGB", []colors.Color{{R: 0xFF}}, join(full, off, off)},
+		{"grb order", "GRB", []colors.Color{{R: 0xFF}}, join(off, full, off)},
+		{"lowercase order", "brg", []colors.Color{{R: 0xFF, B: 0xAA}}, join(alt, full, off)},
+		{"pixels in order", "GRB", []colors.Color{{G: 0xFF}, {B: 0xFF}}, join(full, off, off, off, off, full)},
+		{"white extracted", "GRBW", []colors.Color{colors.White}, join(off, off, off, full)},
+		{"shared part extracted", "RGBW", []colors.Color{{R: 0xFF, G: 0xAA, B: 0xAA}}, join(rest, off, off, alt)},
+		{"no white without a white channel", "GRB", []colors.Color{{R: 0xAA, G: 0xAA, B: 0xAA}}, join(alt, alt, alt)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := NewWS2812Encoder(test.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := encoder.Encode(test.pixels)
+			if len(data) != len(test.want)+ws2812ResetBytes {
+				t.Fatalf("encoded %d bytes, want %d and an %d byte reset", len(data), len(test.want), ws2812ResetBytes)
+			}
+			if pixels := data[:len(test.want)]; !bytes.Equal(pixels, test.want) {
+				t.Errorf("pixels = % X, want % X", pixels, test.want)
+			}
+			if reset := data[len(test.want):]; !bytes.Equal(reset, make([]byte, ws2812ResetBytes)) {
+				t.Errorf("reset = % X, want %d zero bytes", reset, ws2812ResetBytes)
+			}
+		})
+	}
+}
+
+func TestWS2812ColorOrders(t *testing.T) {
+	for _, order := range []string{"GRB", "rgb", "GRBW", "WRGB"} {
+		if _, err := NewWS2812Encoder(order); err != nil {
+			t.Errorf("%s: %v", order, err)
+		}
+	}
+	for _, order := range []string{"", "RG", "RRB", "GRBX", "RGBWW", "GRBG"} {
+		if _, err := NewWS2812Encoder(order); err == nil {
+			t.Errorf("%s: accepted an invalid order", order)
+		}
+	}
+}
+
+// spiBuffer records what's written to the SPI device.
+type spiBuffer struct {
+	bytes.Buffer
+}
+
+func (b *spiBuffer) Close() error { return nil }
+
+func TestWS2812Show(t *testing.T) {
+	encoder, err := NewWS2812Encoder("GRB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spi := &spiBuffer{}
+	w := &WS2812{spi: spi, encoder: encoder, gamma: colors.NewGammaTable(2.2), pixels: make([]colors.Color, 2), brightness: 1}
+	w.SetPixel(0, 128, 64, 1)
+	w.SetBrightness(0.5)
+	if err := w.Show(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The colors are gamma corrected to 56, 12, 0, then dimmed, the same as the simulator and Blinkt.
+	if want := encoder.Encode([]colors.Color{{R: 28, G: 6}, colors.Black}); !bytes.Equal(spi.Bytes(), want) {
+		t.Errorf("sent % X, want % X", spi.Bytes(), want)
+	}
+}
@@ -4,45 +4,37 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/joho/godotenv"
 	"github.com/tom-milner/LightBeatGateway/edge"
+	"github.com/tom-milner/LightBeatGateway/edge/broker"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
 	"github.com/tom-milner/LightBeatGateway/edge/topics"
 	"github.com/tom-milner/LightBeatGateway/hardware"
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/palettes"
 	"github.com/tom-milner/LightBeatGateway/spotify"
 	"github.com/tom-milner/LightBeatGateway/spotify/models"
-
-	"github.com/tom-milner/LightBeatGateway/utils/colors"
 )
 
-const enableHardware bool = runtime.GOARCH == "arm"
-
-type TriggerType string
-
-const (
-	Beat TriggerType = "beat"
-	Bar  TriggerType = "bar"
-)
-
-var currentTriggerType = Beat
-
-func init() {
-	if err := godotenv.Load("../.env"); err != nil {
-		log.Fatal("No .env file found.")
-	}
-
-}
-
 func SetTriggerMessageHandler(msg edge.EdgeMessage) {
-	log.Println(msg.Topic())
-	log.Println(msg.Payload())
-	currentTriggerType = TriggerType(msg.Payload())
+	var command messages.SetTrigger
+	envelope, err := messages.Decode(msg.Payload(), &command)
+	if err != nil {
+		log.Println("Rejected set-trigger:", err)
+		go edge.SendError(topics.SetTrigger, envelope.Sequence, err)
+		return
+	}
+	go applyTriggerType(command.Trigger)
 }
 
 // Setup all the various libraries/connections.
@@ -52,8 +44,27 @@ func setup() {
 	spotifyClientSecret := getRequiredEnv("SPOTIFY_CLIENT_SECRET")
 
 	// Get MQTT Environment vars.
-	brokerAddress := getRequiredEnv("MQTT_BROKER_ADDRESS")
-	brokerPort := getRequiredEnv("MQTT_BROKER_PORT")
+	// The embedded broker runs on this machine, so it doesn't need to be told where the broker is.
+	embeddedBroker := getOptionalEnv("MQTT_EMBEDDED_BROKER", "false") == "true"
+	var brokerAddress, brokerPort string
+	if embeddedBroker {
+		brokerAddress = getOptionalEnv("MQTT_BROKER_ADDRESS", "127.0.0.1")
+		brokerPort = getOptionalEnv("MQTT_BROKER_PORT", "1883")
+	} else {
+		brokerAddress = getRequiredEnv("MQTT_BROKER_ADDRESS")
+		brokerPort = getRequiredEnv("MQTT_BROKER_PORT")
+	}
+	brokerScheme := getOptionalEnv("MQTT_BROKER_SCHEME", "tcp")
+	brokerPath := getOptionalEnv("MQTT_BROKER_PATH", "")
+	mqttUsername := getSecretEnv("MQTT_USERNAME")
+	mqttPassword := getSecretEnv("MQTT_PASSWORD")
+	mqttTLS := edge.MQTTTLSInfo{
+		CACertFile:         getOptionalEnv("MQTT_CA_CERT", ""),
+		ClientCertFile:     getOptionalEnv("MQTT_CLIENT_CERT", ""),
+		ClientKeyFile:      getOptionalEnv("MQTT_CLIENT_KEY", ""),
+		ServerName:         getOptionalEnv("MQTT_TLS_SERVER_NAME", ""),
+		InsecureSkipVerify: getOptionalEnv("MQTT_TLS_INSECURE", "false") == "true",
+	}
 
 	log.Println("Environment variables loaded successfully.")
 
@@ -63,30 +74,217 @@ func setup() {
 		log.Fatal("Failed to authorize spotify wrapper")
 	}
 
+	// Keep the analysis of every track played, so effects can be rendered from it with cmd/render.
+	if cacheDir := getOptionalEnv("SPOTIFY_CACHE_DIR", ""); cacheDir != "" {
+		if err := spotify.SetCacheDir(cacheDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Start the embedded broker, so the edge devices don't need a separate one.
+	if embeddedBroker {
+		if brokerScheme != "tcp" {
+			log.Fatal("The embedded MQTT broker only supports tcp.")
+		}
+		mqttBroker := broker.New(broker.Config{
+			Address:  ":" + brokerPort,
+			Username: mqttUsername,
+			Password: mqttPassword,
+		})
+		if err := mqttBroker.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Connect to MQTT broker
-	broker := edge.MQTTBroker{
+	brokerInfo := edge.MQTTBroker{
+		Scheme:  brokerScheme,
 		Address: brokerAddress,
 		Port:    brokerPort,
+		Path:    brokerPath,
 	}
 	info := edge.MQTTConnInfo{
+		Username: mqttUsername,
+		Password: mqttPassword,
 		ClientID: "LightBeatGateway",
-		Broker:   broker,
+		Broker:   brokerInfo,
+		TLS:      mqttTLS,
 	}
 	_, err := edge.ConnectToMQTTBroker(info)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Set the encoding each group of devices wants their triggers in.
+	groupEncodings, err := edge.ParseGroupEncodings(getOptionalEnv("EDGE_GROUPS", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for group, encoding := range groupEncodings {
+		if err := edge.SetGroupEncoding(group, encoding); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Broadcast triggers over UDP multicast as well, for devices that need lower latency than MQTT.
+	if multicastAddress := getOptionalEnv("UDP_MULTICAST_ADDRESS", ""); multicastAddress != "" {
+		redundancy, err := strconv.Atoi(getOptionalEnv("UDP_MULTICAST_REDUNDANCY", "1"))
+		if err != nil {
+			log.Fatal("UDP_MULTICAST_REDUNDANCY must be a number.")
+		}
+		multicast, err := edge.NewMulticastTransport(multicastAddress, redundancy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		edge.AddTransport("multicast", multicast)
+	}
+
+	// Stream every message to browsers over a websocket.
+	if websocketAddress := getOptionalEnv("WEBSOCKET_ADDRESS", ""); websocketAddress != "" {
+		var origins []string
+		if originList := getOptionalEnv("WEBSOCKET_ORIGINS", ""); originList != "" {
+			origins = strings.Split(originList, ",")
+		}
+		websocket, err := edge.NewWebSocketTransport(websocketAddress, origins)
+		if err != nil {
+			log.Fatal(err)
+		}
+		edge.AddTransport("websocket", websocket)
+	}
+
+	setupOutputs()
+
+	// Choose each track's palette from its audio features, e.g. PALETTE_STRATEGY=key, or keep the palette set by
+	// commands with PALETTE_STRATEGY=none.
+	if strategy := getOptionalEnv("PALETTE_STRATEGY", palettes.DefaultStrategy); strategy != "none" {
+		if err := state.SetPaletteStrategy(strategy); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Subscribe to the relevant topics.
-	edge.OnReceive(topics.SetTrigger, SetTriggerMessageHandler)
+	if err := edge.OnReceive(topics.SetTrigger, SetTriggerMessageHandler); err != nil {
+		log.Fatal(err)
+	}
+	registerCommands()
+	edge.SendMessage(topics.TriggerMode, messages.TriggerMode{Trigger: state.TriggerType()})
+
+	// Setup the lights, e.g. LIGHT_DRIVER=blinkt on a Raspberry Pi, or LIGHT_DRIVER=simulator on a laptop. Several
+	// drivers can be given, separated by commas. WS2812 and SK6812 strips need LIGHT_PIXELS, and are driven on
+	// LIGHT_DEVICE, /dev/spidev0.0 by default. Without LIGHT_DRIVER or any other light settings, Raspberry Pis drive a
+	// Blinkt.
+	driverNames, err := lightDriverNames()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if driverNames == "none" {
+		log.Println("WARNING: No lights will be driven. Set LIGHT_DRIVER to one of", hardware.DriverNames)
+	} else {
+		fps, err := strconv.Atoi(getOptionalEnv("LIGHT_FPS", strconv.Itoa(hardware.DefaultFPS)))
+		if err != nil {
+			log.Fatal("LIGHT_FPS must be a number.")
+		}
+		// 0 leaves each driver to correct colors with its own gamma.
+		gamma := parseFloat("LIGHT_GAMMA", "0")
+
+		// Cap the brightness of every driver, e.g. LIGHT_NIGHT_BRIGHTNESS=0.2 between LIGHT_NIGHT_START=22 and
+		// LIGHT_NIGHT_END=7.
+		err = hardware.SetLimits(hardware.Limits{
+			MaxBrightness:   parseFloat("LIGHT_MAX_BRIGHTNESS", "1"),
+			NightBrightness: parseFloat("LIGHT_NIGHT_BRIGHTNESS", "1"),
+			NightStart:      parseInt("LIGHT_NIGHT_START", "0"),
+			NightEnd:        parseInt("LIGHT_NIGHT_END", "0"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, driverName := range strings.Split(driverNames, ",") {
+			driverName = strings.TrimSpace(driverName)
+			// The power budget can be set for all drivers, e.g. LIGHT_SUPPLY_MA=2000, or for one, e.g.
+			// LIGHT_WS2812_SUPPLY_MA=2000.
+			driverKey := "LIGHT_" + strings.ToUpper(driverName) + "_"
+			power := hardware.PowerBudget{
+				MilliampsPerChannel: parseFloat(driverKey+"MA_PER_CHANNEL", getOptionalEnv("LIGHT_MA_PER_CHANNEL", "0")),
+				IdleMilliamps:       parseFloat(driverKey+"IDLE_MA", getOptionalEnv("LIGHT_IDLE_MA", "0")),
+				SupplyMilliamps:     parseFloat(driverKey+"SUPPLY_MA", getOptionalEnv("LIGHT_SUPPLY_MA", "0")),
+			}
 
-	// Setup Blinkt.
-	if enableHardware {
-		hardware.SetupLights()
+			// So is the layout of the pixels, e.g. LIGHT_LAYOUT='{"mirror": true}' or
+			// LIGHT_WS2812_LAYOUT='{"matrix": {"width": 16, "height": 9}}', and how many there are.
+			var layout hardware.Layout
+			if config := getOptionalEnv(driverKey+"LAYOUT", getOptionalEnv("LIGHT_LAYOUT", "")); config != "" {
+				if layout, err = hardware.ParseLayout(config); err != nil {
+					log.Fatalf("%s: %v", driverName, err)
+				}
+			}
+			pixels, err := strconv.Atoi(getOptionalEnv(driverKey+"PIXELS", getOptionalEnv("LIGHT_PIXELS", "0")))
+			if err != nil {
+				log.Fatalf("%sPIXELS must be a number.", driverKey)
+			}
+			if pixels == 0 {
+				pixels = layout.NumPixels()
+			}
+
+			driver, err := hardware.NewDriver(driverName, hardware.DriverConfig{
+				Pixels:     pixels,
+				Gamma:      gamma,
+				Device:     getOptionalEnv("LIGHT_DEVICE", ""),
+				ColorOrder: getOptionalEnv("LIGHT_COLOR_ORDER", ""),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			config := hardware.DeviceConfig{Layout: layout, FPS: fps, Power: power}
+			if err := hardware.SetupLights(driverName, driver, config); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := hardware.SetEffect("", getOptionalEnv("LIGHT_EFFECT", hardware.DefaultEffect), nil); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// lightDriverNames returns the light drivers set by LIGHT_DRIVER. Without it, the default driver for the machine is
+// only used if none of the other light settings are given either, as they'd be meant for a driver that's been left out.
+func lightDriverNames() (string, error) {
+	if names := getOptionalEnv("LIGHT_DRIVER", ""); names != "" {
+		log.Println("Light drivers:", names)
+		return names, nil
+	}
+	for _, env := range os.Environ() {
+		if key := strings.SplitN(env, "=", 2)[0]; strings.HasPrefix(key, "LIGHT_") && key != "LIGHT_DRIVER" {
+			return "", fmt.Errorf("LIGHT_DRIVER must be set to one of %v or none, as %s is", hardware.DriverNames, key)
+		}
 	}
+	log.Printf("Light drivers: %s, the default on %s/%s, as LIGHT_DRIVER isn't set.\n", hardware.DefaultDriver, runtime.GOOS,
+		runtime.GOARCH)
+	return hardware.DefaultDriver, nil
+}
+
+// parseFloat parses the number in the environment variable.
+func parseFloat(key string, fallback string) float64 {
+	number, err := strconv.ParseFloat(getOptionalEnv(key, fallback), 64)
+	if err != nil {
+		log.Fatalf("%s must be a number.", key)
+	}
+	return number
+}
+
+// parseInt parses the whole number in the environment variable.
+func parseInt(key string, fallback string) int {
+	number, err := strconv.Atoi(getOptionalEnv(key, fallback))
+	if err != nil {
+		log.Fatalf("%s must be a whole number.", key)
+	}
+	return number
 }
 
 func main() { // Setup
+	if err := godotenv.Load("../.env"); err != nil {
+		log.Fatal("No .env file found.")
+	}
 	setup()
 	startSpotifySync()
 }
@@ -101,7 +299,10 @@ func startSpotifySync() {
 	var cancel context.CancelFunc
 	isDetecting := false
 
-	lastTrigger := currentTriggerType
+	// Whether the outputs have been told playback has started.
+	outputsPlaying := false
+
+	lastTrigger := state.TriggerType()
 
 	for {
 		<-ticker.C
@@ -109,6 +310,7 @@ func startSpotifySync() {
 		if currPlay.Item.ID == "" {
 			continue
 		}
+		state.SetMedia(currPlay)
 
 		// TODO: Come up with a better way to detect and react to state changes.
 
@@ -126,13 +328,21 @@ func startSpotifySync() {
 		playingWithoutDetection := (!isDetecting && currPlay.IsPlaying)
 
 		// Whether the trigger type has changed.
+		currentTriggerType := state.TriggerType()
 		triggerTypeChanged := currentTriggerType != lastTrigger
 
+		if outputsPlaying && !currPlay.IsPlaying {
+			output.SendPlayState(false, time.Duration(currPlay.Progress)*time.Millisecond)
+			outputsPlaying = false
+		}
+
 		// TODO: Refactor these massive if statements!!!!
 		if ((changeInPlayState && !currPlay.IsPlaying) || changeInMedia || progressChanged || (triggerTypeChanged && currPlay.IsPlaying)) && !playingWithoutDetection {
 			log.Println("Stopping")
 			cancel()
 			isDetecting = false
+			// The show follows the beat, so it stops until the next trigger.
+			hardware.Stop()
 		}
 
 		if ((changeInPlayState && currPlay.IsPlaying) || changeInMedia || progressChanged) || playingWithoutDetection || (triggerTypeChanged && currPlay.IsPlaying) {
@@ -143,15 +353,22 @@ func startSpotifySync() {
 			if err != nil {
 				continue
 			}
-			b, _ := json.Marshal(currPlay)
-			go edge.SendMessage(topics.NewMedia, b)
+			go edge.SendMessage(topics.NewMedia, currPlay)
 
 			mediaFeatures, err := spotify.GetMediaAudioFeatures(currPlay.Item.ID)
 			if err != nil {
 				continue
 			}
-			b, _ = json.Marshal(mediaFeatures)
-			go edge.SendMessage(topics.MediaFeatures, b)
+			go edge.SendMessage(topics.MediaFeatures, mediaFeatures)
+			state.SetFeatures(mediaFeatures)
+			output.SendMedia(currPlay, mediaFeatures, mediaAnalysis)
+			hardware.SetMedia(mediaAnalysis)
+
+			// The outputs are only told playback has started once they have the media, so they don't play the last one.
+			if currPlay.IsPlaying && !outputsPlaying {
+				output.SendPlayState(true, time.Duration(currPlay.Progress)*time.Millisecond)
+				outputsPlaying = true
+			}
 
 			go startTriggerSync(triggerContext, currPlay, mediaAnalysis, currentTriggerType)
 			isDetecting = true
@@ -163,7 +380,7 @@ func startSpotifySync() {
 }
 
 // Sync with the Playing spotify data.
-func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis models.MediaAudioAnalysis, trigger TriggerType) {
+func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis models.MediaAudioAnalysis, trigger models.TriggerType) {
 	log.Println("Tracking triggers.")
 
 	fmt.Println(currPlay.Item.Name)
@@ -176,10 +393,10 @@ func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis
 	var triggers []models.TimeInterval
 	// Use the triggers specified by the user.
 	switch trigger {
-	case Bar:
+	case models.Bar:
 		triggers = make([]models.TimeInterval, len(mediaAnalysis.Bars))
 		triggers = mediaAnalysis.Bars
-	case Beat:
+	case models.Beat:
 		fallthrough
 	default:
 		log.Println("Using beat as trigger")
@@ -208,6 +425,7 @@ func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis
 	fmt.Printf("numTriggers: %v\n", numTriggers)
 
 	fmt.Printf("Time till next trigger: %v\n", timeTillNextTrigger)
+	go sendSchedule(triggers, nextTrigger, progress)
 	ticker := time.NewTicker(timeTillNextTrigger)
 	for nextTrigger < numTriggers-1 {
 		select {
@@ -215,7 +433,12 @@ func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis
 			nextTrigger++
 			triggerDuration := time.Duration((triggers[nextTrigger].Duration) * float64(time.Second))
 			ticker = time.NewTicker(triggerDuration)
-			go onTrigger(nextTrigger, triggerDuration)
+			go onTrigger(trigger, nextTrigger, secondsToDuration(triggers[nextTrigger].Start), triggerDuration)
+
+			// Send the next part of the schedule before the devices run out.
+			if nextTrigger%scheduleLength == 0 {
+				go sendSchedule(triggers, nextTrigger+1, secondsToDuration(triggers[nextTrigger].Start))
+			}
 		case <-ctx.Done():
 			log.Println("Heard cancel. Exiting")
 			return
@@ -223,8 +446,34 @@ func startTriggerSync(ctx context.Context, currPlay models.Media, mediaAnalysis
 	}
 }
 
+// scheduleLength is how many upcoming triggers are sent in each schedule.
+const scheduleLength = 16
+
+// sendSchedule tells the devices when the triggers from first onwards will happen, given the current position in the track.
+func sendSchedule(triggers []models.TimeInterval, first int, position time.Duration) {
+	var schedule models.Schedule
+	for i := first; i < len(triggers) && i < first+scheduleLength; i++ {
+		schedule.Triggers = append(schedule.Triggers, models.ScheduledTrigger{
+			Number:   i,
+			At:       int((secondsToDuration(triggers[i].Start) - position) / time.Millisecond),
+			Duration: int(secondsToDuration(triggers[i].Duration) / time.Millisecond),
+		})
+	}
+	if len(schedule.Triggers) == 0 {
+		return
+	}
+	edge.SendSchedule(schedule)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // Function to run on every beat.
-func onTrigger(triggerNum int, triggerDuration time.Duration) {
+func onTrigger(triggerType models.TriggerType, triggerNum int, position time.Duration, triggerDuration time.Duration) {
+	if state.Paused() {
+		return
+	}
 
 	// Generate json payload.
 	info := &models.Trigger{
@@ -234,9 +483,17 @@ func onTrigger(triggerNum int, triggerDuration time.Duration) {
 
 	message, _ := json.Marshal(info)
 
-	// go edge.SendMessage(topics.Trigger, message)
-	if enableHardware {
-		hardware.FlashSequence(colors.Red, triggerDuration, triggerNum&1 != 0)
+	go edge.SendTrigger(*info)
+	output.SendTrigger(output.Trigger{
+		Type:       triggerType,
+		Number:     triggerNum,
+		Position:   position,
+		Duration:   triggerDuration,
+		Color:      state.Color(triggerNum),
+		Brightness: state.Brightness(),
+	})
+	if hardware.Enabled() {
+		hardware.Trigger(triggerType, triggerNum, position, triggerDuration, state.Color(triggerNum), state.Brightness())
 	}
 	log.Println(string(message))
 }
@@ -248,3 +505,27 @@ func getRequiredEnv(key string) string {
 	}
 	return envVar
 }
+
+func getOptionalEnv(key string, fallback string) string {
+	envVar, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	return envVar
+}
+
+// getSecretEnv gets a secret either directly from the environment, or from the file named by <key>_FILE.
+func getSecretEnv(key string) string {
+	if envVar, exists := os.LookupEnv(key); exists {
+		return envVar
+	}
+	secretFile, exists := os.LookupEnv(key + "_FILE")
+	if !exists {
+		return ""
+	}
+	secret, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.TrimSpace(string(secret))
+}
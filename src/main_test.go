@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/edge"
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/edge/topics"
+	"github.com/tom-milner/LightBeatGateway/hardware"
+)
+
+// setEnv sets the environment variable for the test, restoring it afterwards.
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	previous, existed := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if existed {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestGetSecretEnv(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "password")
+	// Secret files often end with a newline, which isn't part of the secret.
+	if err := ioutil.WriteFile(secretFile, []byte("  from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"unset", nil, ""},
+		{"from the environment", map[string]string{"TEST_SECRET": "from-env"}, "from-env"},
+		{"empty in the environment", map[string]string{"TEST_SECRET": ""}, ""},
+		{"from a file", map[string]string{"TEST_SECRET_FILE": secretFile}, "from-file"},
+		{"environment before the file", map[string]string{"TEST_SECRET": "from-env", "TEST_SECRET_FILE": secretFile}, "from-env"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Unsetenv("TEST_SECRET")
+			os.Unsetenv("TEST_SECRET_FILE")
+			for key, value := range test.env {
+				setEnv(t, key, value)
+			}
+			if got := getSecretEnv("TEST_SECRET"); got != test.want {
+				t.Errorf("getSecretEnv = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// testMessage is a message received on a topic.
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m testMessage) Duplicate() bool   { return false }
+func (m testMessage) Qos() byte         { return 0 }
+func (m testMessage) Retained() bool    { return false }
+func (m testMessage) Topic() string     { return m.topic }
+func (m testMessage) MessageID() uint16 { return 0 }
+func (m testMessage) Payload() []byte   { return m.payload }
+func (m testMessage) Ack()              {}
+
+// sent is a message sent over a recordingTransport.
+type sent struct {
+	topic    topics.TopicName
+	envelope messages.Envelope
+}
+
+// recordingTransport records every message the gateway sends.
+type recordingTransport chan sent
+
+func (r recordingTransport) Send(topic topics.TopicName, envelope messages.Envelope, body interface{}) error {
+	r <- sent{topic, envelope}
+	return nil
+}
+
+func (r recordingTransport) Close() error { return nil }
+
+func TestInvalidSetTriggerIsRejected(t *testing.T) {
+	transport := make(recordingTransport, 10)
+	edge.AddTransport("test", transport)
+	t.Cleanup(func() { edge.RemoveTransport("test") })
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"unsupported trigger", `{"version":1,"sequence":7,"body":{"trigger":"nope"}}`},
+		{"unknown field", `{"version":1,"sequence":7,"body":{"trigger":"bar","extra":1}}`},
+		{"newer version", `{"version":2,"sequence":7,"body":{"trigger":"bar"}}`},
+		{"missing body", `{"version":1,"sequence":7}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetTriggerMessageHandler(testMessage{string(topics.SetTrigger), []byte(test.payload)})
+
+			var reply sent
+			select {
+			case reply = <-transport:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no error reply sent")
+			}
+			if want := messages.ErrorTopic(topics.SetTrigger); reply.topic != want {
+				t.Fatalf("reply sent to %s, want %s", reply.topic, want)
+			}
+			var body messages.Error
+			if err := json.Unmarshal(reply.envelope.Body, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Topic != topics.SetTrigger || body.Sequence != 7 || body.Message == "" {
+				t.Errorf("error reply = %+v", body)
+			}
+		})
+	}
+}
+
+func TestLightDriverNames(t *testing.T) {
+	// Start without any light settings, restoring them afterwards.
+	for _, env := range os.Environ() {
+		if key := strings.SplitN(env, "=", 2)[0]; strings.HasPrefix(key, "LIGHT_") {
+			setEnv(t, key, "")
+			os.Unsetenv(key)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		want  string
+		valid bool
+	}{
+		{"no config", nil, hardware.DefaultDriver, true},
+		{"empty driver", map[string]string{"LIGHT_DRIVER": ""}, hardware.DefaultDriver, true},
+		{"driver", map[string]string{"LIGHT_DRIVER": "ws2812,simulator"}, "ws2812,simulator", true},
+		{"driver with settings", map[string]string{"LIGHT_DRIVER": "ws2812", "LIGHT_PIXELS": "60"}, "ws2812", true},
+		{"none", map[string]string{"LIGHT_DRIVER": "none", "LIGHT_FPS": "30"}, "none", true},
+		// Settings without a driver are for a driver that's been left out, so it isn't guessed.
+		{"settings without a driver", map[string]string{"LIGHT_PIXELS": "60"}, "", false},
+		{"driver specific settings without a driver", map[string]string{"LIGHT_WS2812_SUPPLY_MA": "2000"}, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				setEnv(t, key, value)
+			}
+			names, err := lightDriverNames()
+			if (err == nil) != test.valid {
+				t.Fatalf("error = %v, want valid %v", err, test.valid)
+			}
+			if names != test.want {
+				t.Errorf("drivers = %q, want %q", names, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package dmx
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// ArtNetPort is the UDP port Art-Net nodes listen on.
+const ArtNetPort = 6454
+
+const (
+	artNetOpDMX       = 0x5000
+	artNetVersion     = 14
+	maxArtNetUniverse = 0x7FFF
+)
+
+// artNetSender sends universes as ArtDMX packets.
+type artNetSender struct {
+	conn     *net.UDPConn
+	target   *net.UDPAddr
+	sequence byte
+}
+
+// newArtNetSender sends to the target host[:port], or broadcasts to the local network if there's no target.
+func newArtNetSender(target string) (*artNetSender, error) {
+	if target == "" {
+		target = "255.255.255.255"
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, strconv.Itoa(ArtNetPort))
+	}
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &artNetSender{conn: conn, target: addr}, nil
+}
+
+func (s *artNetSender) send(universe uint16, data []byte) error {
+	// Sequence 0 means sequencing is disabled, so skip it.
+	s.sequence++
+	if s.sequence == 0 {
+		s.sequence = 1
+	}
+	_, err := s.conn.WriteToUDP(encodeArtDMX(universe, s.sequence, data), s.target)
+	return err
+}
+
+func (s *artNetSender) close() error {
+	return s.conn.Close()
+}
+
+// encodeArtDMX builds an ArtDMX packet carrying the universe data.
+func encodeArtDMX(universe uint16, sequence byte, data []byte) []byte {
+	// The data length must be even.
+	length := len(data)
+	if length%2 != 0 {
+		length++
+	}
+
+	packet := make([]byte, 18+length)
+	copy(packet, "Art-Net\x00")
+	binary.LittleEndian.PutUint16(packet[8:], artNetOpDMX)
+	binary.BigEndian.PutUint16(packet[10:], artNetVersion)
+	packet[12] = sequence
+	packet[13] = 0                        // Physical port.
+	packet[14] = byte(universe)           // SubUni: the low 8 bits of the port address.
+	packet[15] = byte(universe>>8) & 0x7F // Net: the high 7 bits of the port address.
+	binary.BigEndian.PutUint16(packet[16:], uint16(length))
+	copy(packet[18:], data)
+	return packet
+}
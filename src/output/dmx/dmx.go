@@ -0,0 +1,332 @@
+// Package dmx drives DMX fixtures from the gateway's triggers, sending universes over Art-Net or sACN (E1.31).
+//
+// The fixtures are described by a patch file, which maps each fixture's channels to an effect, e.g.
+//
+//	{
+//	  "protocol": "artnet",
+//	  "target": "192.168.1.50",
+//	  "fixtures": [
+//	    {"name": "left par", "universe": 0, "address": 1, "effect": "flash",
+//	     "channels": {"dimmer": 1, "red": 2, "green": 3, "blue": 4, "strobe": 5}}
+//	  ]
+//	}
+//
+// Channels are numbered from 1, relative to the fixture's start address, as in most fixture manuals.
+package dmx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// UniverseSize is the number of channels in a DMX universe.
+const UniverseSize = 512
+
+// DefaultFPS is how many times a second the universes are sent if the patch doesn't say.
+const DefaultFPS = 40
+
+const (
+	ArtNet = "artnet"
+	SACN   = "sacn"
+)
+
+// The channels a fixture can have.
+const (
+	Dimmer = "dimmer"
+	Red    = "red"
+	Green  = "green"
+	Blue   = "blue"
+	White  = "white"
+	Strobe = "strobe"
+)
+
+var channelNames = []string{Dimmer, Red, Green, Blue, White, Strobe}
+
+// The effects a fixture can show.
+const (
+	Flash       = "flash"  // Flash on every trigger, fading out over the trigger.
+	Chase       = "chase"  // Flash each chase fixture in turn, one per trigger.
+	StrobeOnBar = "strobe" // Strobe at the start of every bar.
+	Color       = "color"  // Stay lit, changing to the color of each trigger.
+)
+
+var effectNames = []string{Flash, Chase, StrobeOnBar, Color}
+
+// Patch describes the fixtures to drive, and how to reach them.
+type Patch struct {
+	Protocol string    `json:"protocol"` // artnet or sacn.
+	Target   string    `json:"target"`   // The host[:port] to send to. Art-Net broadcasts and sACN multicasts if empty.
+	FPS      int       `json:"fps"`      // How many times a second to send the universes.
+	Fixtures []Fixture `json:"fixtures"`
+}
+
+// Fixture is a single patched light.
+type Fixture struct {
+	Name     string         `json:"name"`
+	Universe uint16         `json:"universe"`
+	Address  int            `json:"address"`  // The first DMX channel of the fixture, from 1 to 512.
+	Channels map[string]int `json:"channels"` // The fixture's channels, numbered from 1.
+	Effect   string         `json:"effect"`
+}
+
+// LoadPatch reads and validates a patch file.
+func LoadPatch(file string) (Patch, error) {
+	var patch Patch
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return patch, err
+	}
+	if err := json.Unmarshal(b, &patch); err != nil {
+		return patch, fmt.Errorf("invalid patch file %s: %v", file, err)
+	}
+	return patch, patch.Validate()
+}
+
+// Validate checks the patch can be sent.
+func (p Patch) Validate() error {
+	if p.Protocol != ArtNet && p.Protocol != SACN {
+		return fmt.Errorf("unsupported DMX protocol: %q", p.Protocol)
+	}
+	if p.FPS < 0 {
+		return errors.New("fps must not be negative")
+	}
+	if len(p.Fixtures) == 0 {
+		return errors.New("patch has no fixtures")
+	}
+
+	for _, f := range p.Fixtures {
+		if p.Protocol == ArtNet && f.Universe > maxArtNetUniverse {
+			return fmt.Errorf("fixture %s: Art-Net universes go up to %d", f.Name, maxArtNetUniverse)
+		}
+		if p.Protocol == SACN && (f.Universe < minSACNUniverse || f.Universe > maxSACNUniverse) {
+			return fmt.Errorf("fixture %s: sACN universes go from %d to %d", f.Name, minSACNUniverse, maxSACNUniverse)
+		}
+		if !contains(effectNames, f.Effect) {
+			return fmt.Errorf("fixture %s: unknown effect %q", f.Name, f.Effect)
+		}
+		if len(f.Channels) == 0 {
+			return fmt.Errorf("fixture %s has no channels", f.Name)
+		}
+		if f.Address < 1 || f.Address > UniverseSize {
+			return fmt.Errorf("fixture %s: address %d is outside the universe", f.Name, f.Address)
+		}
+		for name, channel := range f.Channels {
+			if !contains(channelNames, name) {
+				return fmt.Errorf("fixture %s: unknown channel %q", f.Name, name)
+			}
+			if channel < 1 {
+				return fmt.Errorf("fixture %s: channel %s must be at least 1", f.Name, name)
+			}
+			if f.Address+channel-1 > UniverseSize {
+				return fmt.Errorf("fixture %s: channel %s at address %d doesn't fit in the universe", f.Name, name, f.Address)
+			}
+		}
+	}
+	return nil
+}
+
+// sender sends a universe of DMX data over the network.
+type sender interface {
+	send(universe uint16, data []byte) error
+	close() error
+}
+
+// Output drives the patched fixtures. Universes are resent continuously, as DMX receivers expect.
+type Output struct {
+	patch  Patch
+	sender sender
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu          sync.Mutex
+	trigger     output.Trigger
+	triggeredAt time.Time
+}
+
+// New starts sending the patch's universes.
+func New(patch Patch) (*Output, error) {
+	if err := patch.Validate(); err != nil {
+		return nil, err
+	}
+	if patch.FPS == 0 {
+		patch.FPS = DefaultFPS
+	}
+
+	var s sender
+	var err error
+	switch patch.Protocol {
+	case ArtNet:
+		s, err = newArtNetSender(patch.Target)
+	case SACN:
+		s, err = newSACNSender(patch.Target, "LightBeat")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Output{
+		patch:  patch,
+		sender: s,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+// Trigger starts the fixtures' effects for the trigger.
+func (o *Output) Trigger(trigger output.Trigger) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.trigger = trigger
+	o.triggeredAt = time.Now()
+}
+
+// Close blacks out the fixtures and stops sending.
+func (o *Output) Close() error {
+	close(o.stop)
+	<-o.done
+	return o.sender.close()
+}
+
+func (o *Output) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(time.Second / time.Duration(o.patch.FPS))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			trigger, elapsed := o.trigger, time.Since(o.triggeredAt)
+			o.mu.Unlock()
+			o.send(Render(o.patch, trigger, elapsed))
+		case <-o.stop:
+			// Leave the fixtures dark.
+			o.send(Render(o.patch, output.Trigger{}, 0))
+			return
+		}
+	}
+}
+
+func (o *Output) send(universes map[uint16][]byte) {
+	for universe, data := range universes {
+		if err := o.sender.send(universe, data); err != nil {
+			log.Printf("Failed to send DMX universe %d: %v\n", universe, err)
+		}
+	}
+}
+
+// Render computes the data of every patched universe, elapsed into the trigger.
+func Render(patch Patch, trigger output.Trigger, elapsed time.Duration) map[uint16][]byte {
+	universes := map[uint16][]byte{}
+
+	// Chase fixtures take turns, in the order they're patched.
+	var chaseCount, chaseIndex int
+	for _, f := range patch.Fixtures {
+		if f.Effect == Chase {
+			chaseCount++
+		}
+	}
+
+	for _, f := range patch.Fixtures {
+		data, exists := universes[f.Universe]
+		if !exists {
+			data = make([]byte, UniverseSize)
+			universes[f.Universe] = data
+		}
+
+		var levels map[string]byte
+		if f.Effect == Chase {
+			levels = fixtureLevels(f, trigger, elapsed, chaseIndex, chaseCount)
+			chaseIndex++
+		} else {
+			levels = fixtureLevels(f, trigger, elapsed, 0, 1)
+		}
+		for name, channel := range f.Channels {
+			data[f.Address+channel-2] = levels[name]
+		}
+	}
+	return universes
+}
+
+// fixtureLevels computes the value of each of the fixture's channels, elapsed into the trigger.
+// Chase fixtures also need their position in the chase.
+func fixtureLevels(f Fixture, trigger output.Trigger, elapsed time.Duration, chaseIndex int, chaseCount int) map[string]byte {
+	if trigger.Duration <= 0 {
+		// Nothing has been triggered yet.
+		return map[string]byte{}
+	}
+
+	r, g, b := trigger.Color.R, trigger.Color.G, trigger.Color.B
+
+	// How far through the trigger we are, from 0 to 1.
+	progress := float64(elapsed) / float64(trigger.Duration)
+	if progress > 1 {
+		progress = 1
+	}
+
+	var intensity float64
+	strobe := byte(0)
+	switch f.Effect {
+	case Flash:
+		intensity = 1 - progress
+	case Chase:
+		if trigger.Number%chaseCount == chaseIndex {
+			intensity = 1 - progress
+		}
+	case StrobeOnBar:
+		// With beat triggers, assume 4 beats to a bar.
+		startOfBar := trigger.Type == models.Bar || trigger.Number%4 == 0
+		if startOfBar && progress < 0.5 {
+			intensity = 1
+			strobe = 255
+		}
+	case Color:
+		intensity = 1
+	}
+	intensity *= trigger.Brightness
+
+	levels := map[string]byte{Strobe: strobe}
+	if _, hasDimmer := f.Channels[Dimmer]; hasDimmer {
+		// The dimmer sets the brightness, so the color can stay at full strength.
+		levels[Dimmer] = scale(255, intensity)
+		levels[Red], levels[Green], levels[Blue] = r, g, b
+		levels[White] = min3(r, g, b)
+	} else {
+		levels[Red], levels[Green], levels[Blue] = scale(r, intensity), scale(g, intensity), scale(b, intensity)
+		levels[White] = scale(min3(r, g, b), intensity)
+	}
+	return levels
+}
+
+func scale(value byte, intensity float64) byte {
+	return byte(float64(value)*intensity + 0.5)
+}
+
+func min3(a, b, c byte) byte {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package dmx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// testFixture is an RGB fixture patched part way through universe 1.
+var testFixture = Fixture{
+	Name:     "par",
+	Universe: 1,
+	Address:  10,
+	Channels: map[string]int{Red: 1, Green: 2, Blue: 3},
+	Effect:   Color,
+}
+
+// capture listens on loopback for the packets an output sends, returning the target to send to.
+func capture(t *testing.T) (string, chan []byte) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	packets := make(chan []byte, 1024)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	return conn.LocalAddr().String(), packets
+}
+
+// waitForLevels reads packets until one carries the levels at the fixture's address, returning its universe data.
+// The header is how many bytes come before the data.
+func waitForLevels(t *testing.T, packets chan []byte, header int, levels []byte) []byte {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case packet := <-packets:
+			data := packet[header:]
+			if bytes.Equal(data[testFixture.Address-1:testFixture.Address-1+len(levels)], levels) {
+				return packet
+			}
+		case <-timeout:
+			t.Fatalf("no packet with levels %v", levels)
+		}
+	}
+}
+
+func TestSendLoopback(t *testing.T) {
+	tests := []struct {
+		protocol string
+		header   int
+		check    func(t *testing.T, packet []byte)
+	}{
+		{
+			protocol: ArtNet,
+			header:   18,
+			check: func(t *testing.T, packet []byte) {
+				if !strings.HasPrefix(string(packet), "Art-Net\x00") {
+					t.Errorf("packet id = %q", packet[:8])
+				}
+				if op := binary.LittleEndian.Uint16(packet[8:]); op != artNetOpDMX {
+					t.Errorf("opcode = %#x, want %#x", op, artNetOpDMX)
+				}
+				if packet[12] == 0 {
+					t.Error("sequence is disabled")
+				}
+				if universe := uint16(packet[15])<<8 | uint16(packet[14]); universe != testFixture.Universe {
+					t.Errorf("universe = %d, want %d", universe, testFixture.Universe)
+				}
+				if length := binary.BigEndian.Uint16(packet[16:]); length != UniverseSize {
+					t.Errorf("length = %d, want %d", length, UniverseSize)
+				}
+			},
+		},
+		{
+			protocol: SACN,
+			header:   sacnHeaderSize,
+			check: func(t *testing.T, packet []byte) {
+				if string(packet[4:16]) != "ASC-E1.17\x00\x00\x00" {
+					t.Errorf("packet id = %q", packet[4:16])
+				}
+				if name := string(bytes.TrimRight(packet[44:108], "\x00")); name != "LightBeat" {
+					t.Errorf("source name = %q", name)
+				}
+				if universe := binary.BigEndian.Uint16(packet[113:]); universe != testFixture.Universe {
+					t.Errorf("universe = %d, want %d", universe, testFixture.Universe)
+				}
+				if count := binary.BigEndian.Uint16(packet[123:]); count != UniverseSize+1 {
+					t.Errorf("property count = %d, want %d", count, UniverseSize+1)
+				}
+				if length := int(binary.BigEndian.Uint16(packet[16:]) & 0x0FFF); length != len(packet)-16 {
+					t.Errorf("root layer length = %d, want %d", length, len(packet)-16)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.protocol, func(t *testing.T) {
+			target, packets := capture(t)
+			o, err := New(Patch{Protocol: test.protocol, Target: target, FPS: 200, Fixtures: []Fixture{testFixture}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Until something is triggered, the fixture is dark.
+			packet := waitForLevels(t, packets, test.header, []byte{0, 0, 0})
+			if len(packet) != test.header+UniverseSize {
+				t.Fatalf("packet is %d bytes, want %d", len(packet), test.header+UniverseSize)
+			}
+			test.check(t, packet)
+
+			o.Trigger(output.Trigger{Number: 1, Duration: time.Second, Color: colors.Color{R: 0xFF, G: 0x80}, Brightness: 1})
+			packet = waitForLevels(t, packets, test.header, []byte{255, 128, 0})
+			test.check(t, packet)
+
+			// Closing blacks the fixture out.
+			if err := o.Close(); err != nil {
+				t.Fatal(err)
+			}
+			waitForLevels(t, packets, test.header, []byte{0, 0, 0})
+		})
+	}
+}
+
+func TestValidateAddresses(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  int
+		channels map[string]int
+		valid    bool
+	}{
+		{"first address", 1, map[string]int{Dimmer: 1}, true},
+		{"fills the universe", 509, map[string]int{Red: 1, Green: 2, Blue: 3, White: 4}, true},
+		{"address 0", 0, map[string]int{Dimmer: 1}, false},
+		{"address 0 with later channels", 0, map[string]int{Red: 2, Green: 3, Blue: 4}, false},
+		{"past the universe", 513, map[string]int{Dimmer: 1}, false},
+		{"channels past the universe", 510, map[string]int{Red: 1, Green: 2, Blue: 3, White: 4}, false},
+		{"channel 0", 1, map[string]int{Dimmer: 0}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fixture := Fixture{Name: "fixture", Address: test.address, Channels: test.channels, Effect: Flash}
+			err := Patch{Protocol: ArtNet, Fixtures: []Fixture{fixture}}.Validate()
+			if valid := err == nil; valid != test.valid {
+				t.Errorf("got error %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package dmx
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// SACNPort is the UDP port sACN (E1.31) receivers listen on.
+const SACNPort = 5568
+
+const (
+	sacnRootVector    = 0x00000004
+	sacnFrameVector   = 0x00000002
+	sacnDMPVector     = 0x02
+	sacnPriority      = 100
+	sacnHeaderSize    = 126
+	minSACNUniverse   = 1
+	maxSACNUniverse   = 63999
+	sacnSourceNameLen = 64
+)
+
+// sacnSender sends universes as E1.31 data packets.
+type sacnSender struct {
+	conn      *net.UDPConn
+	target    string // Empty to send to each universe's multicast address.
+	cid       [16]byte
+	name      string
+	sequences map[uint16]byte
+}
+
+// newSACNSender sends to the target host[:port], or to each universe's multicast group if there's no target.
+func newSACNSender(target string, sourceName string) (*sacnSender, error) {
+	s := &sacnSender{name: sourceName, sequences: map[uint16]byte{}}
+	if target != "" {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, strconv.Itoa(SACNPort))
+		}
+		s.target = target
+	}
+
+	// Each source is identified by a random UUID.
+	if _, err := rand.Read(s.cid[:]); err != nil {
+		return nil, err
+	}
+	s.cid[6] = (s.cid[6] & 0x0F) | 0x40
+	s.cid[8] = (s.cid[8] & 0x3F) | 0x80
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+func (s *sacnSender) send(universe uint16, data []byte) error {
+	target := s.target
+	if target == "" {
+		target = sacnMulticastAddress(universe)
+	}
+	addr, err := net.ResolveUDPAddr("udp4", target)
+	if err != nil {
+		return err
+	}
+
+	s.sequences[universe]++
+	_, err = s.conn.WriteToUDP(encodeSACN(s.cid, s.name, universe, s.sequences[universe], data), addr)
+	return err
+}
+
+func (s *sacnSender) close() error {
+	return s.conn.Close()
+}
+
+// sacnMulticastAddress returns the multicast group receivers of the universe listen on.
+func sacnMulticastAddress(universe uint16) string {
+	return fmt.Sprintf("239.255.%d.%d:%d", universe>>8, universe&0xFF, SACNPort)
+}
+
+// encodeSACN builds an E1.31 data packet carrying the universe data.
+func encodeSACN(cid [16]byte, sourceName string, universe uint16, sequence byte, data []byte) []byte {
+	packet := make([]byte, sacnHeaderSize+len(data))
+
+	// Root layer.
+	binary.BigEndian.PutUint16(packet[0:], 0x0010) // Preamble size.
+	binary.BigEndian.PutUint16(packet[2:], 0x0000) // Postamble size.
+	copy(packet[4:], "ASC-E1.17\x00\x00\x00")
+	putFlagsAndLength(packet[16:], len(packet)-16)
+	binary.BigEndian.PutUint32(packet[18:], sacnRootVector)
+	copy(packet[22:], cid[:])
+
+	// Framing layer.
+	putFlagsAndLength(packet[38:], len(packet)-38)
+	binary.BigEndian.PutUint32(packet[40:], sacnFrameVector)
+	name := []byte(sourceName)
+	if len(name) > sacnSourceNameLen-1 {
+		name = name[:sacnSourceNameLen-1]
+	}
+	copy(packet[44:], name)
+	packet[108] = sacnPriority
+	binary.BigEndian.PutUint16(packet[109:], 0) // Synchronization address.
+	packet[111] = sequence
+	packet[112] = 0 // Options.
+	binary.BigEndian.PutUint16(packet[113:], universe)
+
+	// DMP layer.
+	putFlagsAndLength(packet[115:], len(packet)-115)
+	packet[117] = sacnDMPVector
+	packet[118] = 0xA1                                            // Address and data type.
+	binary.BigEndian.PutUint16(packet[119:], 0)                   // First property address.
+	binary.BigEndian.PutUint16(packet[121:], 1)                   // Address increment.
+	binary.BigEndian.PutUint16(packet[123:], uint16(len(data)+1)) // Property value count, including the start code.
+	packet[125] = 0                                               // DMX start code.
+	copy(packet[126:], data)
+	return packet
+}
+
+// putFlagsAndLength writes the length of a PDU, with the flags E1.31 requires.
+func putFlagsAndLength(b []byte, length int) {
+	binary.BigEndian.PutUint16(b, 0x7000|uint16(length&0x0FFF))
+}
@@ -0,0 +1,212 @@
+// Package emulator is a stand-in for an entertainment streaming bridge, so the hue output can be tried out and tested
+// without any hardware. It serves the parts of the REST API the output uses, and records the frames streamed to it.
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/output/hue"
+)
+
+// The error types the REST API replies with.
+const (
+	errorUnauthorized   = 1
+	errorInvalidJSON    = 2
+	errorNotAvailable   = 3
+	errorMethodNotFound = 4
+)
+
+// Config describes the emulated bridge.
+type Config struct {
+	Address  string               // The address to serve the REST API and receive frames on. Defaults to 127.0.0.1:0.
+	Username string               // The only application key the bridge accepts.
+	Groups   map[string]hue.Group // The bridge's groups, by ID.
+}
+
+// Bridge is an emulated bridge.
+type Bridge struct {
+	config   Config
+	listener net.Listener
+	server   *http.Server
+	stream   *net.UDPConn
+
+	mu     sync.Mutex
+	groups map[string]hue.Group
+	lights map[uint16]hue.LightColor // The last color streamed to each light.
+	frames int
+}
+
+// New starts the emulated bridge.
+func New(config Config) (*Bridge, error) {
+	if config.Address == "" {
+		config.Address = "127.0.0.1:0"
+	}
+	b := &Bridge{
+		config: config,
+		groups: map[string]hue.Group{},
+		lights: map[uint16]hue.LightColor{},
+	}
+	for id, group := range config.Groups {
+		b.groups[id] = group
+	}
+
+	var err error
+	if b.listener, err = net.Listen("tcp", config.Address); err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(b.listener.Addr().String())
+	if b.stream, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(host)}); err != nil {
+		b.listener.Close()
+		return nil, err
+	}
+
+	b.server = &http.Server{Handler: http.HandlerFunc(b.handleAPI)}
+	go b.server.Serve(b.listener)
+	go b.receiveFrames()
+	log.Printf("Emulating a bridge on %s, streaming on port %d\n", b.Addr(), b.StreamPort())
+	return b, nil
+}
+
+// Addr returns the host:port of the REST API.
+func (b *Bridge) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// StreamPort returns the UDP port frames are received on.
+func (b *Bridge) StreamPort() int {
+	return b.stream.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Streaming returns whether streaming is switched on for the group.
+func (b *Bridge) Streaming(group string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.groups[group].Stream.Active
+}
+
+// Lights returns the last color streamed to each light.
+func (b *Bridge) Lights() map[uint16]hue.LightColor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lights := make(map[uint16]hue.LightColor, len(b.lights))
+	for id, color := range b.lights {
+		lights[id] = color
+	}
+	return lights
+}
+
+// Frames returns how many frames have been received.
+func (b *Bridge) Frames() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.frames
+}
+
+// Close stops the bridge.
+func (b *Bridge) Close() error {
+	b.stream.Close()
+	return b.server.Close()
+}
+
+// handleAPI serves /api/<username>/groups/<id>.
+func (b *Bridge) handleAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" || parts[1] != b.config.Username {
+		writeError(w, errorUnauthorized, "/", "unauthorized user")
+		return
+	}
+	if len(parts) != 4 || parts[2] != "groups" {
+		writeError(w, errorNotAvailable, r.URL.Path, fmt.Sprintf("resource, %s, not available", r.URL.Path))
+		return
+	}
+	id := parts[3]
+	address := "/groups/" + id
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	group, exists := b.groups[id]
+	if !exists {
+		writeError(w, errorNotAvailable, address, fmt.Sprintf("resource, %s, not available", address))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(group)
+	case http.MethodPut:
+		var body struct {
+			Stream *hue.GroupStream `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Stream == nil {
+			writeError(w, errorInvalidJSON, address, "body contains invalid json")
+			return
+		}
+		group.Stream.Active = body.Stream.Active
+		if group.Stream.Active {
+			group.Stream.Owner = b.config.Username
+		} else {
+			group.Stream.Owner = ""
+		}
+		b.groups[id] = group
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"success": map[string]bool{address + "/stream/active": group.Stream.Active}},
+		})
+	default:
+		writeError(w, errorMethodNotFound, address, fmt.Sprintf("method, %s, not available for resource, %s", r.Method, address))
+	}
+}
+
+func writeError(w http.ResponseWriter, errorType int, address string, description string) {
+	json.NewEncoder(w).Encode([]map[string]hue.APIError{
+		{"error": {Type: errorType, Address: address, Description: description}},
+	})
+}
+
+// receiveFrames records the frames streamed to the lights of streaming groups, ignoring any others as a bridge
+// would.
+func (b *Bridge) receiveFrames() {
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := b.stream.ReadFromUDP(buf)
+		if err != nil {
+			// The connection has been closed.
+			return
+		}
+		_, lights, err := hue.DecodeFrame(buf[:n])
+		if err != nil {
+			log.Printf("Invalid frame from %s: %v\n", from, err)
+			continue
+		}
+
+		b.mu.Lock()
+		b.frames++
+		for _, light := range lights {
+			if b.streamingLight(light.ID) {
+				b.lights[light.ID] = light
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// streamingLight returns whether the light is in a group that's streaming. b.mu must be held.
+func (b *Bridge) streamingLight(id uint16) bool {
+	for _, group := range b.groups {
+		if !group.Stream.Active {
+			continue
+		}
+		for _, light := range group.Lights {
+			if light == fmt.Sprint(id) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package emulator
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/output/hue"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+const testUsername = "gateway"
+
+// newTestBridge starts a bridge with an entertainment group of three lights, left to right, and a room.
+func newTestBridge(t *testing.T) *Bridge {
+	t.Helper()
+	b, err := New(Config{
+		Username: testUsername,
+		Groups: map[string]hue.Group{
+			"1": {
+				Name:   "Living room",
+				Type:   "Entertainment",
+				Lights: []string{"3", "1", "2"},
+				Locations: map[string][3]float64{
+					"1": {-1, 0, 0},
+					"2": {0, 0, 0},
+					"3": {1, 0, 0},
+				},
+			},
+			"2": {Name: "Kitchen", Type: "Room", Lights: []string{"4"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestStreamToBridge(t *testing.T) {
+	b := newTestBridge(t)
+	o, err := hue.New(hue.Config{Bridge: b.Addr(), Username: testUsername, Group: "1", StreamPort: b.StreamPort(), FPS: 200, PlainUDP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Streaming("1") {
+		t.Fatal("streaming wasn't switched on")
+	}
+
+	// The flash starts on the left, so the leftmost light is lit first, in the trigger's color.
+	o.Trigger(output.Trigger{Number: 0, Duration: 2 * time.Second, Color: colors.Color{R: 0xFF}, Brightness: 1})
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Lights()[1].R == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("light 1 never lit")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	lights := b.Lights()
+	if len(lights) != 3 {
+		t.Errorf("bridge received colors for lights %v, want 1, 2 and 3", lights)
+	}
+	if left := lights[1]; left.G != 0 || left.B != 0 {
+		t.Errorf("light 1 = %+v, want red", left)
+	}
+	if right := lights[3]; right.R >= lights[1].R {
+		t.Errorf("light 3 (%d) is as bright as light 1 (%d), but the flash starts on the left", right.R, lights[1].R)
+	}
+	if b.Frames() == 0 {
+		t.Error("no frames counted")
+	}
+
+	// Closing hands the lights back.
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Streaming("1") {
+		t.Error("streaming wasn't switched off")
+	}
+}
+
+func TestBridgeErrors(t *testing.T) {
+	b := newTestBridge(t)
+	tests := []struct {
+		name     string
+		username string
+		group    string
+		apiError int // The API error type expected, or 0 for another error.
+	}{
+		{"unknown username", "someone", "1", errorUnauthorized},
+		{"unknown group", testUsername, "9", errorNotAvailable},
+		{"not an entertainment group", testUsername, "2", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := hue.New(hue.Config{Bridge: b.Addr(), Username: test.username, Group: test.group, StreamPort: b.StreamPort(), PlainUDP: true})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			apiErr, isAPIError := err.(hue.APIError)
+			if test.apiError != 0 && (!isAPIError || apiErr.Type != test.apiError) {
+				t.Errorf("error = %v, want API error %d", err, test.apiError)
+			}
+			if test.apiError == 0 && isAPIError {
+				t.Errorf("error = %v, want the group rejected", err)
+			}
+		})
+	}
+	if b.Streaming("2") {
+		t.Error("streaming switched on for a room")
+	}
+}
+
+func TestDTLSBridgesRejected(t *testing.T) {
+	b := newTestBridge(t)
+	// Without saying the bridge takes plain UDP, it's assumed to be a real bridge that would drop the frames.
+	if _, err := hue.New(hue.Config{Bridge: b.Addr(), Username: testUsername, Group: "1", StreamPort: b.StreamPort()}); err == nil {
+		t.Fatal("streaming to a bridge that needs DTLS")
+	}
+	if b.Streaming("1") {
+		t.Error("streaming switched on for a rejected bridge")
+	}
+}
+
+func TestFramesForOtherGroupsIgnored(t *testing.T) {
+	b := newTestBridge(t)
+	o, err := hue.New(hue.Config{Bridge: b.Addr(), Username: testUsername, Group: "1", StreamPort: b.StreamPort(), FPS: 200, PlainUDP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Trigger(output.Trigger{Number: 0, Duration: time.Second, Color: colors.White, Brightness: 1})
+	o.Close()
+
+	// Once streaming is off, frames change nothing, as on a real bridge.
+	before := b.Lights()
+	frame, err := hue.EncodeFrame(0, []hue.LightColor{{ID: 1, R: 1}, {ID: 4, R: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendFrame(t, b, frame)
+	if after := b.Lights(); after[1] != before[1] || len(after) != len(before) {
+		t.Errorf("lights changed from %v to %v while not streaming", before, after)
+	}
+}
+
+// sendFrame streams the frame to the bridge, waiting until it's been received.
+func sendFrame(t *testing.T, b *Bridge, frame []byte) {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, b.stream.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frames := b.Frames()
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Frames() == frames {
+		if time.Now().After(deadline) {
+			t.Fatal("frame not received")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,300 @@
+// Package hue puts smart bulbs on the beat, using a bridge's entertainment streaming: the lights of an entertainment
+// group are found and streaming is switched on through the bridge's REST API, then color frames are streamed to the
+// bridge over UDP.
+//
+// Frames are sent as plain UDP. Philips Hue bridges only accept them wrapped in DTLS, which isn't supported, so they
+// need a DTLS proxy in between. As frames sent straight to a real bridge are silently dropped, the config has to say
+// the bridge takes plain UDP, as a proxy or an emulator such as the one in the emulator package does.
+package hue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+)
+
+const (
+	// DefaultStreamPort is the UDP port bridges listen for frames on.
+	DefaultStreamPort = 2100
+
+	// DefaultFPS is how many frames a second are sent if the config doesn't say. Bridges pass on about 25 a second.
+	DefaultFPS = 50
+)
+
+// Config describes the bridge and the entertainment group to stream to.
+type Config struct {
+	Bridge      string // The host[:port] of the bridge's REST API.
+	Username    string // The application key the bridge gave the gateway.
+	Group       string // The ID of the entertainment group.
+	StreamPort  int    // The UDP port to stream to.
+	FPS         int    // How many frames a second to send.
+	PlainUDP    bool   // Whether the bridge takes plain UDP frames. Real bridges need DTLS, so they're rejected.
+	HTTPTimeout time.Duration
+}
+
+// Group is an entertainment group, as the bridge describes it.
+type Group struct {
+	Name      string                `json:"name"`
+	Type      string                `json:"type"`
+	Lights    []string              `json:"lights"`
+	Locations map[string][3]float64 `json:"locations"` // The position of each light, from -1 to 1 left to right.
+	Stream    GroupStream           `json:"stream"`
+}
+
+// GroupStream is the streaming state of an entertainment group.
+type GroupStream struct {
+	Active bool   `json:"active"`
+	Owner  string `json:"owner,omitempty"`
+}
+
+// APIError is an error returned by the bridge's REST API.
+type APIError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("bridge error %d at %s: %s", e.Type, e.Address, e.Description)
+}
+
+// Light is a single light being streamed to.
+type Light struct {
+	ID uint16
+	X  float64 // Where the light is, from -1 to 1 left to right.
+}
+
+// Output streams beat effects to the lights of an entertainment group.
+type Output struct {
+	config Config
+	http   *http.Client
+	conn   *net.UDPConn
+	lights []Light
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu          sync.Mutex
+	trigger     output.Trigger
+	triggeredAt time.Time
+}
+
+// New finds the entertainment group's lights, switches streaming on, and starts streaming to them.
+func New(config Config) (*Output, error) {
+	if config.Bridge == "" || config.Username == "" || config.Group == "" {
+		return nil, fmt.Errorf("Hue bridge, username and group are required")
+	}
+	if !config.PlainUDP {
+		return nil, fmt.Errorf("Hue bridge %s must take plain UDP frames, as streaming over DTLS isn't supported. "+
+			"Put a DTLS proxy in front of real bridges", config.Bridge)
+	}
+	if config.StreamPort == 0 {
+		config.StreamPort = DefaultStreamPort
+	}
+	if config.FPS <= 0 {
+		config.FPS = DefaultFPS
+	}
+	if config.HTTPTimeout <= 0 {
+		config.HTTPTimeout = 5 * time.Second
+	}
+
+	o := &Output{
+		config: config,
+		http:   &http.Client{Timeout: config.HTTPTimeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	var group Group
+	if err := o.request(http.MethodGet, nil, &group); err != nil {
+		return nil, err
+	}
+	if group.Type != "Entertainment" {
+		return nil, fmt.Errorf("Hue group %s is a %s group, not an entertainment group", config.Group, group.Type)
+	}
+	lights, err := groupLights(group)
+	if err != nil {
+		return nil, err
+	}
+	o.lights = lights
+
+	host, _, err := net.SplitHostPort(config.Bridge)
+	if err != nil {
+		host = config.Bridge
+	}
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(config.StreamPort)))
+	if err != nil {
+		return nil, err
+	}
+	if o.conn, err = net.DialUDP("udp", nil, addr); err != nil {
+		return nil, err
+	}
+
+	if err := o.setStreaming(true); err != nil {
+		o.conn.Close()
+		return nil, err
+	}
+	log.Printf("Streaming to %d lights of Hue group %s\n", len(o.lights), group.Name)
+	go o.run()
+	return o, nil
+}
+
+// groupLights returns the group's lights, in the order they're placed left to right.
+func groupLights(group Group) ([]Light, error) {
+	if len(group.Lights) == 0 {
+		return nil, fmt.Errorf("Hue group %s has no lights", group.Name)
+	}
+	if len(group.Lights) > MaxStreamLights {
+		return nil, fmt.Errorf("Hue group %s has more than %d lights", group.Name, MaxStreamLights)
+	}
+
+	lights := make([]Light, len(group.Lights))
+	for i, id := range group.Lights {
+		number, err := strconv.ParseUint(id, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Hue light ID %q", id)
+		}
+		lights[i] = Light{ID: uint16(number), X: group.Locations[id][0]}
+	}
+	sort.SliceStable(lights, func(i, j int) bool {
+		return lights[i].X < lights[j].X
+	})
+	return lights, nil
+}
+
+// Trigger starts the beat effect for the trigger.
+func (o *Output) Trigger(trigger output.Trigger) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.trigger = trigger
+	o.triggeredAt = time.Now()
+}
+
+// Close stops streaming, handing the lights back to the bridge.
+func (o *Output) Close() error {
+	close(o.stop)
+	<-o.done
+	err := o.setStreaming(false)
+	o.conn.Close()
+	return err
+}
+
+// setStreaming switches the group's streaming on or off.
+func (o *Output) setStreaming(active bool) error {
+	body := map[string]interface{}{"stream": GroupStream{Active: active}}
+	return o.request(http.MethodPut, body, nil)
+}
+
+// request calls the bridge's REST API for the group. The bridge replies with a list of errors if it fails.
+func (o *Output) request(method string, body interface{}, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	url := fmt.Sprintf("http://%s/api/%s/groups/%s", o.config.Bridge, o.config.Username, o.config.Group)
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var raw json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("invalid response from Hue bridge: %v", err)
+	}
+
+	var errs []struct {
+		Error *APIError `json:"error"`
+	}
+	if json.Unmarshal(raw, &errs) == nil {
+		for _, e := range errs {
+			if e.Error != nil {
+				return *e.Error
+			}
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Hue bridge replied %s", res.Status)
+	}
+	if result != nil {
+		return json.Unmarshal(raw, result)
+	}
+	return nil
+}
+
+func (o *Output) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(time.Second / time.Duration(o.config.FPS))
+	defer ticker.Stop()
+
+	var sequence byte
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			trigger, elapsed := o.trigger, time.Since(o.triggeredAt)
+			o.mu.Unlock()
+
+			frame, err := EncodeFrame(sequence, Render(o.lights, trigger, elapsed))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			sequence++
+			if _, err := o.conn.Write(frame); err != nil {
+				log.Printf("Failed to stream to Hue bridge %s: %v\n", o.config.Bridge, err)
+			}
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Render computes the color of each light, elapsed into the trigger. The flash ripples across the lights from left
+// to right, changing direction every trigger, and fades out over the trigger.
+func Render(lights []Light, trigger output.Trigger, elapsed time.Duration) []LightColor {
+	frame := make([]LightColor, len(lights))
+	r, g, b := trigger.Color.R, trigger.Color.G, trigger.Color.B
+
+	progress := 1.0
+	if trigger.Duration > 0 {
+		progress = float64(elapsed) / float64(trigger.Duration)
+	}
+	for i, l := range lights {
+		frame[i].ID = l.ID
+
+		// The far side of the group lights up half way through the trigger.
+		position := (l.X + 1) / 2
+		if trigger.Number&1 != 0 {
+			position = 1 - position
+		}
+		delay := math.Max(0, math.Min(1, position)) / 2
+		if progress < delay || progress >= 1 {
+			continue
+		}
+		intensity := (1 - (progress-delay)/(1-delay)) * trigger.Brightness
+		frame[i].R, frame[i].G, frame[i].B = scale(r, intensity), scale(g, intensity), scale(b, intensity)
+	}
+	return frame
+}
+
+// scale converts an 8 bit color channel to the 16 bits used by frames, at the intensity.
+func scale(value uint8, intensity float64) uint16 {
+	return uint16(float64(value)*257*intensity + 0.5)
+}
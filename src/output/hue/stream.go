@@ -0,0 +1,84 @@
+package hue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// streamHeader starts every streamed frame.
+const streamHeader = "HueStream"
+
+const (
+	// streamHeaderSize is the size of a frame before its lights: the header, version, sequence, reserved bytes and
+	// color space.
+	streamHeaderSize = len(streamHeader) + 2 + 1 + 2 + 1 + 1
+
+	// streamLightSize is the size of each light in a frame: its type, ID and color.
+	streamLightSize = 1 + 2 + 6
+
+	// MaxStreamLights is the most lights a frame can carry.
+	MaxStreamLights = 10
+
+	colorSpaceRGB = 0x00
+	deviceLight   = 0x00
+)
+
+// LightColor is the color of a single light in a frame, with 16 bits per channel.
+type LightColor struct {
+	ID      uint16
+	R, G, B uint16
+}
+
+// EncodeFrame builds a streamed frame setting the color of each light.
+func EncodeFrame(sequence byte, lights []LightColor) ([]byte, error) {
+	if len(lights) > MaxStreamLights {
+		return nil, fmt.Errorf("frames can only carry %d lights", MaxStreamLights)
+	}
+	b := make([]byte, streamHeaderSize, streamHeaderSize+len(lights)*streamLightSize)
+	copy(b, streamHeader)
+	b[9], b[10] = 1, 0 // Version 1.0.
+	b[11] = sequence
+	b[14] = colorSpaceRGB
+
+	for _, light := range lights {
+		var entry [streamLightSize]byte
+		entry[0] = deviceLight
+		binary.BigEndian.PutUint16(entry[1:], light.ID)
+		binary.BigEndian.PutUint16(entry[3:], light.R)
+		binary.BigEndian.PutUint16(entry[5:], light.G)
+		binary.BigEndian.PutUint16(entry[7:], light.B)
+		b = append(b, entry[:]...)
+	}
+	return b, nil
+}
+
+// DecodeFrame reads a streamed frame, returning the color of each light.
+func DecodeFrame(b []byte) (sequence byte, lights []LightColor, err error) {
+	if len(b) < streamHeaderSize || string(b[:len(streamHeader)]) != streamHeader {
+		return 0, nil, errors.New("not a streamed frame")
+	}
+	if b[9] != 1 {
+		return 0, nil, fmt.Errorf("unsupported stream version %d.%d", b[9], b[10])
+	}
+	if b[14] != colorSpaceRGB {
+		return 0, nil, errors.New("only RGB frames are supported")
+	}
+	body := b[streamHeaderSize:]
+	if len(body)%streamLightSize != 0 || len(body)/streamLightSize > MaxStreamLights {
+		return 0, nil, errors.New("invalid frame length")
+	}
+
+	for ; len(body) > 0; body = body[streamLightSize:] {
+		if body[0] != deviceLight {
+			return 0, nil, fmt.Errorf("unknown device type %d", body[0])
+		}
+		lights = append(lights, LightColor{
+			ID: binary.BigEndian.Uint16(body[1:]),
+			R:  binary.BigEndian.Uint16(body[3:]),
+			G:  binary.BigEndian.Uint16(body[5:]),
+			B:  binary.BigEndian.Uint16(body[7:]),
+		})
+	}
+	return b[11], lights, nil
+}
@@ -0,0 +1,396 @@
+// Package link takes part in Ableton Link sessions, so DAWs and Link-enabled apps on the LAN lock to the tempo and
+// beat phase of the playing track.
+//
+// Link peers find each other by multicasting their state, and agree on a shared "ghost" clock by pinging each other.
+// Each peer's timeline maps ghost time to beats, and the peer whose timeline has the latest beat origin set the tempo
+// most recently, so everyone follows it.
+package link
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultAddress is the multicast group Link peers discover each other on.
+const DefaultAddress = "224.76.78.75:20808"
+
+const (
+	// ttl is how many seconds a peer is remembered for after its last message.
+	ttl = 5
+
+	// aliveInterval is how often the node tells its peers it's still there.
+	aliveInterval = 250 * time.Millisecond
+
+	// remeasureInterval is how long before another session is measured again, to see if it should be joined.
+	remeasureInterval = 30 * time.Second
+
+	// sessionEpsilon is how close two sessions' ghost times must be to count as the same age.
+	sessionEpsilon = 500 * int64(time.Millisecond/time.Microsecond)
+
+	// The tempos Link allows, in beats per minute.
+	minTempo = 20
+	maxTempo = 999
+
+	defaultTempo = 120
+)
+
+// Config holds the settings of the Link node.
+type Config struct {
+	Address   string // The multicast group to discover peers on. Defaults to Link's.
+	Interface string // The network interface to use. Empty to use the system's default multicast interface.
+}
+
+// peer is another node, as last heard from.
+type peer struct {
+	state   nodeState
+	from    *net.UDPAddr
+	expires time.Time
+}
+
+// Link is this gateway's node in a Link session.
+type Link struct {
+	node        NodeID
+	start       time.Time // Host time is measured from here.
+	group       *net.UDPAddr
+	discovery   *net.UDPConn // Sends alive messages to the group, and receives responses.
+	multicast   *net.UDPConn // Receives the group's messages.
+	measurement *net.UDPConn // Answers measurement pings.
+	endpoint    *net.UDPAddr // The address of the measurement connection, as other nodes see it.
+	stop        chan struct{}
+	done        sync.WaitGroup
+
+	mu          sync.Mutex
+	session     NodeID
+	ghostOffset int64 // Ghost time is host time plus this, in microseconds.
+	timeline    Timeline
+	startStop   StartStopState
+	peers       map[NodeID]*peer
+	measured    map[NodeID]time.Time // When each other session was last measured.
+	closed      bool
+}
+
+// Join starts a Link node, which founds its own session until it finds peers.
+func Join(config Config) (*Link, error) {
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	group, err := net.ResolveUDPAddr("udp4", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, errors.New(config.Address + " isn't a multicast address")
+	}
+
+	var iface *net.Interface
+	if config.Interface != "" {
+		if iface, err = net.InterfaceByName(config.Interface); err != nil {
+			return nil, err
+		}
+	}
+	ip, err := localIP(iface, group)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Link{
+		node:     newNodeID(),
+		start:    time.Now(),
+		group:    group,
+		stop:     make(chan struct{}),
+		peers:    map[NodeID]*peer{},
+		measured: map[NodeID]time.Time{},
+	}
+	// A new session's ghost time starts at 0 when it's founded.
+	l.session = l.node
+	l.timeline = Timeline{Tempo: tempoMicros(defaultTempo)}
+
+	if l.multicast, err = net.ListenMulticastUDP("udp4", iface, group); err != nil {
+		return nil, err
+	}
+	if l.discovery, err = net.ListenUDP("udp4", &net.UDPAddr{IP: ip}); err != nil {
+		l.multicast.Close()
+		return nil, err
+	}
+	if l.measurement, err = net.ListenUDP("udp4", &net.UDPAddr{IP: ip}); err != nil {
+		l.multicast.Close()
+		l.discovery.Close()
+		return nil, err
+	}
+	l.endpoint = l.measurement.LocalAddr().(*net.UDPAddr)
+	log.Printf("Joined Link as %s, answering pings on %s\n", l.node, l.endpoint)
+
+	l.done.Add(4)
+	go l.receive(l.multicast)
+	go l.receive(l.discovery)
+	go l.answerPings()
+	go l.keepAlive()
+	return l, nil
+}
+
+// localIP finds the address other nodes can reach this one on.
+func localIP(iface *net.Interface, group *net.UDPAddr) (net.IP, error) {
+	if iface != nil {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return ipNet.IP.To4(), nil
+			}
+		}
+		return nil, errors.New(iface.Name + " has no IPv4 address")
+	}
+
+	// Ask the routing table which address multicast goes out from. Nothing is sent.
+	conn, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Tempo returns the session's tempo, in beats per minute.
+func (l *Link) Tempo() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timeline.BPM()
+}
+
+// BeatAt returns the session's beat at the time.
+func (l *Link) BeatAt(t time.Time) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float64(l.timeline.beatsAt(l.ghostAt(t))) / 1e6
+}
+
+// Playing returns whether the session is playing.
+func (l *Link) Playing() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.startStop.Playing
+}
+
+// Peers returns how many other nodes are in the session.
+func (l *Link) Peers() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, p := range l.peers {
+		if p.state.session == l.session {
+			count++
+		}
+	}
+	return count
+}
+
+// SetTimeline sets the session's tempo, in beats per minute, and lines its phase up so the beat lands at the time.
+// The phase is only matched within the quantum, e.g. 4 to line bars up, so the session's beats never jump backwards.
+func (l *Link) SetTimeline(tempo float64, beat float64, at time.Time, quantum float64) {
+	tempo = math.Max(minTempo, math.Min(maxTempo, tempo))
+	if quantum <= 0 {
+		quantum = 1
+	}
+
+	l.mu.Lock()
+	ghost := l.ghostAt(at)
+	current := float64(l.timeline.beatsAt(ghost)) / 1e6
+	origin := current + math.Mod(math.Mod(beat-current, quantum)+quantum, quantum)
+	l.timeline = Timeline{
+		Tempo:      tempoMicros(tempo),
+		BeatOrigin: int64(math.Round(origin * 1e6)),
+		TimeOrigin: ghost,
+	}
+	l.mu.Unlock()
+	l.broadcast(messageAlive, l.group)
+}
+
+// SetPlaying starts or stops the session, at the time.
+func (l *Link) SetPlaying(playing bool, at time.Time) {
+	l.mu.Lock()
+	ghost := l.ghostAt(at)
+	l.startStop = StartStopState{
+		Playing:   playing,
+		Beats:     l.timeline.beatsAt(ghost),
+		Timestamp: ghost,
+	}
+	l.mu.Unlock()
+	l.broadcast(messageAlive, l.group)
+}
+
+// Close tells the peers the node is leaving.
+func (l *Link) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	l.broadcast(messageByeBye, l.group)
+	close(l.stop)
+	l.multicast.Close()
+	l.discovery.Close()
+	err := l.measurement.Close()
+	l.done.Wait()
+	return err
+}
+
+// hostAt returns the host time of the time, in microseconds.
+func (l *Link) hostAt(t time.Time) int64 {
+	return int64(t.Sub(l.start) / time.Microsecond)
+}
+
+// ghostAt returns the session's ghost time of the time, in microseconds. l.mu must be held.
+func (l *Link) ghostAt(t time.Time) int64 {
+	return l.hostAt(t) + l.ghostOffset
+}
+
+// state returns the node's state, to send to its peers. l.mu must be held.
+func (l *Link) state() nodeState {
+	return nodeState{
+		node:      l.node,
+		session:   l.session,
+		timeline:  l.timeline,
+		startStop: l.startStop,
+		endpoint:  l.endpoint,
+	}
+}
+
+// broadcast sends the node's state to the address.
+func (l *Link) broadcast(kind byte, to *net.UDPAddr) {
+	l.mu.Lock()
+	message := encodeDiscovery(kind, ttl, l.state())
+	l.mu.Unlock()
+	if _, err := l.discovery.WriteToUDP(message, to); err != nil {
+		log.Println("Failed to send Link message:", err)
+	}
+}
+
+// keepAlive tells the peers the node is still there, and forgets peers that have gone quiet.
+func (l *Link) keepAlive() {
+	defer l.done.Done()
+	ticker := time.NewTicker(aliveInterval)
+	defer ticker.Stop()
+
+	l.broadcast(messageAlive, l.group)
+	for {
+		select {
+		case <-ticker.C:
+			l.broadcast(messageAlive, l.group)
+			l.mu.Lock()
+			for id, p := range l.peers {
+				if time.Now().After(p.expires) {
+					delete(l.peers, id)
+				}
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// receive handles the discovery messages arriving on the connection.
+func (l *Link) receive(conn *net.UDPConn) {
+	defer l.done.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection has been closed.
+			return
+		}
+		kind, peerTTL, state, err := decodeDiscovery(buf[:n])
+		if err != nil || state.node == l.node {
+			continue
+		}
+
+		switch kind {
+		case messageByeBye:
+			l.mu.Lock()
+			delete(l.peers, state.node)
+			l.mu.Unlock()
+		case messageAlive:
+			// Let the new peer know about this node straight away, rather than waiting for the next alive.
+			l.broadcast(messageResponse, from)
+			fallthrough
+		case messageResponse:
+			l.sawPeer(state, from, peerTTL)
+		}
+	}
+}
+
+// sawPeer records the peer's state, following its timeline if it's in the same session, or measuring its session
+// if it's in another one.
+func (l *Link) sawPeer(state nodeState, from *net.UDPAddr, peerTTL byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.peers[state.node] = &peer{
+		state:   state,
+		from:    from,
+		expires: time.Now().Add(time.Duration(peerTTL) * time.Second),
+	}
+
+	if state.session == l.session {
+		l.followTimeline(state)
+		return
+	}
+	if state.endpoint == nil || time.Since(l.measured[state.session]) < remeasureInterval {
+		return
+	}
+	l.measured[state.session] = time.Now()
+	go l.measureSession(state)
+}
+
+// followTimeline takes the timeline and start/stop state of a peer in the same session, if they're newer. The
+// newest tempo is the one with the latest beat origin. l.mu must be held.
+func (l *Link) followTimeline(state nodeState) {
+	if state.timeline.BeatOrigin > l.timeline.BeatOrigin {
+		l.timeline = state.timeline
+	}
+	if state.startStop.Timestamp > l.startStop.Timestamp {
+		l.startStop = state.startStop
+	}
+}
+
+// measureSession measures the peer's session, and joins it if it's older than this node's session.
+func (l *Link) measureSession(state nodeState) {
+	offset, err := l.measure(state.endpoint, state.session)
+	if err != nil {
+		log.Println("Failed to measure Link session:", err)
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	// Ghost time starts at 0 when a session is founded, so the older session is further ahead. The older session
+	// wins, with ties going to the lowest ID so both sides agree.
+	diff := (l.hostAt(now) + offset) - l.ghostAt(now)
+	join := diff > sessionEpsilon || (diff > -sessionEpsilon && state.session.less(l.session))
+	if join {
+		log.Printf("Joining Link session %s\n", state.session)
+		l.session = state.session
+		l.ghostOffset = offset
+		l.timeline = state.timeline
+		l.startStop = state.startStop
+		delete(l.measured, state.session)
+	}
+	l.mu.Unlock()
+
+	if join {
+		l.broadcast(messageAlive, l.group)
+	}
+}
+
+// tempoMicros returns the length of a beat at the tempo, in microseconds.
+func tempoMicros(tempo float64) int64 {
+	return int64(math.Round(60e6 / tempo))
+}
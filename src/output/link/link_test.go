@@ -0,0 +1,69 @@
+package link
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testAddress is a different group from Link's, so the test doesn't join real sessions on the network.
+const testAddress = "224.76.78.75:20818"
+
+// joinTest starts a node on the test group.
+func joinTest(t *testing.T) *Link {
+	t.Helper()
+	l, err := Join(Config{Address: testAddress})
+	if err != nil {
+		t.Skip("can't join multicast group:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// eventually waits for the condition to hold, failing the test if it doesn't within a few seconds.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// beatsAgree checks the nodes are on the same beat at the same moment.
+func beatsAgree(a, b *Link) bool {
+	now := time.Now()
+	return math.Abs(a.BeatAt(now)-b.BeatAt(now)) < 0.02
+}
+
+func TestPeersConverge(t *testing.T) {
+	a := joinTest(t)
+	b := joinTest(t)
+
+	eventually(t, "the peers to join one session", func() bool {
+		return a.Peers() == 1 && b.Peers() == 1
+	})
+
+	// A tempo set on either node is followed by the other, with the beats lined up.
+	a.SetTimeline(128, 0, time.Now(), 4)
+	eventually(t, "b to follow a's tempo", func() bool {
+		return b.Tempo() == a.Tempo() && math.Abs(b.Tempo()-128) < 0.01
+	})
+	eventually(t, "the beats to converge", func() bool { return beatsAgree(a, b) })
+
+	at := time.Now().Add(time.Second)
+	b.SetTimeline(95, 2, at, 4)
+	eventually(t, "a to follow b's tempo", func() bool {
+		return a.Tempo() == b.Tempo() && math.Abs(a.Tempo()-95) < 0.01
+	})
+	eventually(t, "the beats to converge", func() bool { return beatsAgree(a, b) })
+	if phase := math.Mod(a.BeatAt(at), 4); math.Abs(phase-2) > 0.02 {
+		t.Errorf("a is at beat %.3f of the bar, want 2", phase)
+	}
+
+	// So is starting the session.
+	a.SetPlaying(true, time.Now())
+	eventually(t, "b to start playing", b.Playing)
+}
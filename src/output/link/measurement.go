@@ -0,0 +1,107 @@
+package link
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	// measurementPings is how many pings are sent to measure a session.
+	measurementPings = 20
+
+	// pingTimeout is how long to wait for each pong before sending the next ping.
+	pingTimeout = 50 * time.Millisecond
+)
+
+// answerPings replies to the measurement pings of nodes joining the session, telling them the session's ghost time.
+func (l *Link) answerPings() {
+	defer l.done.Done()
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := l.measurement.ReadFromUDP(buf)
+		if err != nil {
+			// The connection has been closed.
+			return
+		}
+		kind, p, entries, err := decodeMeasurement(buf[:n])
+		if err != nil || kind != messagePing {
+			continue
+		}
+		if _, ok := p.int64(keyHostTime); !ok {
+			continue
+		}
+
+		// The pong carries the ping's entries back, so the node can tell which ping it answers.
+		l.mu.Lock()
+		session, ghost := l.session, l.ghostAt(time.Now())
+		l.mu.Unlock()
+		reply := appendEntry(nil, keySession, session[:])
+		reply = appendInt64Entry(reply, keyGhostTime, ghost)
+		reply = append(reply, entries...)
+		if _, err := l.measurement.WriteToUDP(encodeMeasurement(messagePong, reply), from); err != nil {
+			log.Println("Failed to answer Link ping:", err)
+		}
+	}
+}
+
+// measure pings a member of the session to find the offset between this node's host time and the session's ghost
+// time, in microseconds.
+func (l *Link) measure(endpoint *net.UDPAddr, session NodeID) (int64, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var offsets []float64
+	var prevGhost int64
+	buf := make([]byte, maxMessageSize)
+	for i := 0; i < measurementPings; i++ {
+		ping := appendInt64Entry(nil, keyHostTime, l.hostAt(time.Now()))
+		if prevGhost != 0 {
+			ping = appendInt64Entry(ping, keyPrevGhostTime, prevGhost)
+		}
+		if _, err := conn.WriteToUDP(encodeMeasurement(messagePing, ping), endpoint); err != nil {
+			return 0, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(pingTimeout))
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				// Lost, so move on to the next ping.
+				break
+			}
+			received := l.hostAt(time.Now())
+			kind, p, _, err := decodeMeasurement(buf[:n])
+			if err != nil || kind != messagePong || !bytes.Equal(p[keySession], session[:]) {
+				continue
+			}
+			ghost, hasGhost := p.int64(keyGhostTime)
+			sent, hasHost := p.int64(keyHostTime)
+			if !hasGhost || !hasHost {
+				continue
+			}
+
+			// The ghost time was read about halfway between the ping being sent and the pong arriving.
+			offsets = append(offsets, float64(ghost)-float64(sent+received)/2)
+			if prev, ok := p.int64(keyPrevGhostTime); ok {
+				// And the ping was sent about halfway between the previous pong and this one being sent.
+				offsets = append(offsets, float64(ghost+prev)/2-float64(sent))
+			}
+			prevGhost = ghost
+			break
+		}
+	}
+
+	if len(offsets) == 0 {
+		return 0, errors.New("no answer to Link pings from " + endpoint.String())
+	}
+	// The median ignores the odd delayed packet.
+	sort.Float64s(offsets)
+	return int64(offsets[len(offsets)/2]), nil
+}
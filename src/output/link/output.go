@@ -0,0 +1,162 @@
+package link
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// Quantum is how many beats the session's phase is lined up over, so bars line up in 4/4 tracks.
+const Quantum = 4
+
+// Output publishes the playing track's tempo and beat phase to the Link session. The timeline is updated at the
+// start of every bar, with the tempo of that bar, so Link follows the track as its tempo drifts.
+type Output struct {
+	link *Link
+
+	mu       sync.Mutex
+	stopBars chan struct{}
+	bars     []barTiming
+}
+
+// barTiming is the tempo of a single bar, and which beat of the track it starts on.
+type barTiming struct {
+	start time.Duration
+	beat  int     // The beat the bar starts on, counting from the first bar.
+	tempo float64 // The tempo of the bar, in beats per minute.
+}
+
+// NewOutput joins the Link session.
+func NewOutput(config Config) (*Output, error) {
+	l, err := Join(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Output{link: l}, nil
+}
+
+// Trigger does nothing, as the timeline follows the bars whatever the trigger type.
+func (o *Output) Trigger(trigger output.Trigger) {}
+
+// Media starts publishing the media's tempo and phase.
+func (o *Output) Media(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis) {
+	bars := barTimings(analysis)
+	o.mu.Lock()
+	o.bars = bars
+	o.mu.Unlock()
+	if media.IsPlaying {
+		o.startBars(time.Duration(media.Progress) * time.Millisecond)
+	}
+}
+
+// PlayState starts and stops the session with playback.
+func (o *Output) PlayState(playing bool, position time.Duration) {
+	o.link.SetPlaying(playing, time.Now())
+	if playing {
+		o.startBars(position)
+	} else {
+		o.stopSendingBars()
+	}
+}
+
+// Close leaves the Link session.
+func (o *Output) Close() error {
+	o.stopSendingBars()
+	return o.link.Close()
+}
+
+// barTimings works out the tempo and first beat of every bar of the analysis.
+func barTimings(analysis models.MediaAudioAnalysis) []barTiming {
+	beats := analysis.Beats
+	var bars []barTiming
+	first := -1
+	for _, bar := range analysis.Bars {
+		// Find the beats in the bar. Bars and beats don't always start at exactly the same time.
+		i := nearestBeat(beats, bar.Start)
+		if i < 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+
+		var length float64
+		count := 0
+		for j := i; j < len(beats) && beats[j].Start < bar.Start+bar.Duration-beats[j].Duration/2; j++ {
+			length += beats[j].Duration
+			count++
+		}
+		if count == 0 || length <= 0 {
+			continue
+		}
+		bars = append(bars, barTiming{
+			start: time.Duration(bar.Start * float64(time.Second)),
+			beat:  i - first,
+			tempo: 60 / (length / float64(count)),
+		})
+	}
+	return bars
+}
+
+// nearestBeat returns the index of the beat starting closest to the time, or -1 if there are no beats.
+func nearestBeat(beats []models.TimeInterval, start float64) int {
+	nearest := -1
+	for i, beat := range beats {
+		if nearest < 0 || math.Abs(beat.Start-start) < math.Abs(beats[nearest].Start-start) {
+			nearest = i
+		}
+	}
+	return nearest
+}
+
+// startBars updates the timeline at the start of each bar after the position, starting with the current bar.
+func (o *Output) startBars(position time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopBars != nil {
+		close(o.stopBars)
+	}
+	stop := make(chan struct{})
+	o.stopBars = stop
+
+	bars := o.bars
+	next := 0
+	for next < len(bars)-1 && bars[next+1].start <= position {
+		next++
+	}
+	if len(bars) == 0 {
+		return
+	}
+
+	startedAt := time.Now().Add(-position)
+	go func() {
+		for _, bar := range bars[next:] {
+			timer := time.NewTimer(time.Until(startedAt.Add(bar.start)))
+			select {
+			case <-timer.C:
+				// Bars that have already started are picked up part way through, so the timeline only moves forwards.
+				at, beat := startedAt.Add(bar.start), float64(bar.beat)
+				if now := time.Now(); now.After(at) {
+					beat += now.Sub(at).Seconds() * bar.tempo / 60
+					at = now
+				}
+				o.link.SetTimeline(bar.tempo, beat, at, Quantum)
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (o *Output) stopSendingBars() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopBars != nil {
+		close(o.stopBars)
+		o.stopBars = nil
+	}
+}
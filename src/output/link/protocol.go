@@ -0,0 +1,286 @@
+package link
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// The headers that start every discovery and measurement message.
+const (
+	discoveryHeader   = "_asdp_v\x01"
+	measurementHeader = "_link_v\x01"
+)
+
+// The types of discovery message.
+const (
+	messageAlive    = 1 // Sent to the multicast group, to say the node is still there.
+	messageResponse = 2 // Sent straight back to a node that is alive.
+	messageByeBye   = 3 // Sent to the multicast group when the node leaves.
+)
+
+// The types of measurement message.
+const (
+	messagePing = 1
+	messagePong = 2
+)
+
+// maxMessageSize is the largest message Link sends.
+const maxMessageSize = 512
+
+// discoveryHeaderSize is the size of a discovery message before its payload: the protocol header, the message type,
+// the time to live, the group ID and the node ID.
+const discoveryHeaderSize = len(discoveryHeader) + 1 + 1 + 2 + len(NodeID{})
+
+// The keys of the payload entries.
+var (
+	keyTimeline      = fourCC("tmln")
+	keySession       = fourCC("sess")
+	keyStartStop     = fourCC("stst")
+	keyEndpoint      = fourCC("mep4")
+	keyHostTime      = fourCC("__ht")
+	keyGhostTime     = fourCC("__gt")
+	keyPrevGhostTime = fourCC("_pgt")
+)
+
+// NodeID identifies a single Link peer. Sessions are identified by the ID of the node that founded them.
+type NodeID [8]byte
+
+// newNodeID creates a random, printable node ID, as Link does.
+func newNodeID() NodeID {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	var id NodeID
+	rand.Read(id[:])
+	for i, b := range id {
+		id[i] = letters[int(b)%len(letters)]
+	}
+	return id
+}
+
+func (id NodeID) String() string {
+	return string(id[:])
+}
+
+// less orders node IDs, to settle ties between sessions.
+func (id NodeID) less(other NodeID) bool {
+	for i := range id {
+		if id[i] != other[i] {
+			return id[i] < other[i]
+		}
+	}
+	return false
+}
+
+// Timeline maps the session's ghost time to beats.
+type Timeline struct {
+	Tempo      int64 // The length of a beat, in microseconds.
+	BeatOrigin int64 // The beat at the time origin, in millionths of a beat.
+	TimeOrigin int64 // The ghost time of the beat origin, in microseconds.
+}
+
+// beatsAt returns the beat at the ghost time, in millionths of a beat.
+func (t Timeline) beatsAt(ghost int64) int64 {
+	return t.BeatOrigin + int64(math.Round(float64(ghost-t.TimeOrigin)*1e6/float64(t.Tempo)))
+}
+
+// timeAt returns the ghost time of the beat, given in millionths of a beat.
+func (t Timeline) timeAt(beats int64) int64 {
+	return t.TimeOrigin + int64(math.Round(float64(beats-t.BeatOrigin)*float64(t.Tempo)/1e6))
+}
+
+// BPM returns the tempo in beats per minute.
+func (t Timeline) BPM() float64 {
+	return 60e6 / float64(t.Tempo)
+}
+
+// StartStopState says whether the session is playing.
+type StartStopState struct {
+	Playing   bool
+	Beats     int64 // The beat playback started or stopped at, in millionths of a beat.
+	Timestamp int64 // The ghost time playback started or stopped, in microseconds.
+}
+
+// nodeState is everything a node tells its peers about itself.
+type nodeState struct {
+	node      NodeID
+	session   NodeID
+	timeline  Timeline
+	startStop StartStopState
+	endpoint  *net.UDPAddr // Where the node answers measurement pings.
+}
+
+// fourCC packs a four character code into a payload key.
+func fourCC(code string) uint32 {
+	return binary.BigEndian.Uint32([]byte(code))
+}
+
+// payload holds the entries of a message, by key.
+type payload map[uint32][]byte
+
+// parsePayload splits a payload into its entries. Entries are a key and a size, followed by the value.
+func parsePayload(b []byte) (payload, error) {
+	p := payload{}
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("truncated payload entry")
+		}
+		key, size := binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+		b = b[8:]
+		if uint32(len(b)) < size {
+			return nil, fmt.Errorf("payload entry %q is truncated", keyName(key))
+		}
+		p[key] = b[:size]
+		b = b[size:]
+	}
+	return p, nil
+}
+
+// int64 returns an entry holding a single number.
+func (p payload) int64(key uint32) (int64, bool) {
+	value, exists := p[key]
+	if !exists || len(value) != 8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(value)), true
+}
+
+func keyName(key uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], key)
+	return string(b[:])
+}
+
+func appendEntry(b []byte, key uint32, value []byte) []byte {
+	b = appendUint32(b, key)
+	b = appendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func appendInt64Entry(b []byte, key uint32, value int64) []byte {
+	return appendEntry(b, key, appendUint64(nil, uint64(value)))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return append(b, buf[:]...)
+}
+
+// encodeDiscovery builds a discovery message carrying the node's state. Bye-byes only carry the header.
+func encodeDiscovery(kind byte, ttl byte, state nodeState) []byte {
+	b := make([]byte, 0, maxMessageSize)
+	b = append(b, discoveryHeader...)
+	b = append(b, kind, ttl, 0, 0) // Group 0, the only one Link uses.
+	b = append(b, state.node[:]...)
+	if kind == messageByeBye {
+		return b
+	}
+
+	timeline := appendUint64(nil, uint64(state.timeline.Tempo))
+	timeline = appendUint64(timeline, uint64(state.timeline.BeatOrigin))
+	timeline = appendUint64(timeline, uint64(state.timeline.TimeOrigin))
+	b = appendEntry(b, keyTimeline, timeline)
+	b = appendEntry(b, keySession, state.session[:])
+
+	startStop := []byte{0}
+	if state.startStop.Playing {
+		startStop[0] = 1
+	}
+	startStop = appendUint64(startStop, uint64(state.startStop.Beats))
+	startStop = appendUint64(startStop, uint64(state.startStop.Timestamp))
+	b = appendEntry(b, keyStartStop, startStop)
+
+	if ip := state.endpoint.IP.To4(); ip != nil {
+		endpoint := append([]byte{}, ip...)
+		endpoint = append(endpoint, byte(state.endpoint.Port>>8), byte(state.endpoint.Port))
+		b = appendEntry(b, keyEndpoint, endpoint)
+	}
+	return b
+}
+
+// decodeDiscovery reads a discovery message. The state is only complete for alive messages and responses.
+func decodeDiscovery(b []byte) (kind byte, ttl byte, state nodeState, err error) {
+	if len(b) < discoveryHeaderSize || string(b[:len(discoveryHeader)]) != discoveryHeader {
+		return 0, 0, state, errors.New("not a Link discovery message")
+	}
+	kind, ttl = b[len(discoveryHeader)], b[len(discoveryHeader)+1]
+	if group := binary.BigEndian.Uint16(b[len(discoveryHeader)+2:]); group != 0 {
+		return 0, 0, state, fmt.Errorf("unknown discovery group %d", group)
+	}
+	copy(state.node[:], b[len(discoveryHeader)+4:])
+	if kind == messageByeBye {
+		return kind, ttl, state, nil
+	}
+	if kind != messageAlive && kind != messageResponse {
+		return 0, 0, state, fmt.Errorf("unknown discovery message type %d", kind)
+	}
+
+	p, err := parsePayload(b[discoveryHeaderSize:])
+	if err != nil {
+		return 0, 0, state, err
+	}
+
+	timeline, exists := p[keyTimeline]
+	if !exists || len(timeline) != 24 {
+		return 0, 0, state, errors.New("missing timeline")
+	}
+	state.timeline = Timeline{
+		Tempo:      int64(binary.BigEndian.Uint64(timeline)),
+		BeatOrigin: int64(binary.BigEndian.Uint64(timeline[8:])),
+		TimeOrigin: int64(binary.BigEndian.Uint64(timeline[16:])),
+	}
+	if state.timeline.Tempo <= 0 {
+		return 0, 0, state, errors.New("invalid tempo")
+	}
+
+	session, exists := p[keySession]
+	if !exists || len(session) != len(state.session) {
+		return 0, 0, state, errors.New("missing session")
+	}
+	copy(state.session[:], session)
+
+	// Older peers don't share their start/stop state.
+	if startStop, exists := p[keyStartStop]; exists && len(startStop) == 17 {
+		state.startStop = StartStopState{
+			Playing:   startStop[0] != 0,
+			Beats:     int64(binary.BigEndian.Uint64(startStop[1:])),
+			Timestamp: int64(binary.BigEndian.Uint64(startStop[9:])),
+		}
+	}
+
+	if endpoint, exists := p[keyEndpoint]; exists && len(endpoint) == 6 {
+		state.endpoint = &net.UDPAddr{
+			IP:   net.IPv4(endpoint[0], endpoint[1], endpoint[2], endpoint[3]),
+			Port: int(binary.BigEndian.Uint16(endpoint[4:])),
+		}
+	}
+	return kind, ttl, state, nil
+}
+
+// encodeMeasurement builds a ping or pong, with the payload entries already encoded.
+func encodeMeasurement(kind byte, entries []byte) []byte {
+	b := make([]byte, 0, len(measurementHeader)+1+len(entries))
+	b = append(b, measurementHeader...)
+	b = append(b, kind)
+	return append(b, entries...)
+}
+
+// decodeMeasurement reads a ping or pong, returning its raw entries as well so pings can be echoed back.
+func decodeMeasurement(b []byte) (kind byte, p payload, entries []byte, err error) {
+	if len(b) < len(measurementHeader)+1 || string(b[:len(measurementHeader)]) != measurementHeader {
+		return 0, nil, nil, errors.New("not a Link measurement message")
+	}
+	kind = b[len(measurementHeader)]
+	entries = b[len(measurementHeader)+1:]
+	p, err = parsePayload(entries)
+	return kind, p, entries, err
+}
@@ -0,0 +1,118 @@
+package midi
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// grid converts between times in the track and positions in beats, following the beats of the analysis rather than
+// a fixed tempo, so the clock speeds up and slows down with the track.
+type grid struct {
+	beats []models.TimeInterval
+	bars  []models.TimeInterval
+}
+
+// newGrid creates the beat grid of the analysis. It returns false if the analysis has no beats.
+func newGrid(analysis models.MediaAudioAnalysis) (grid, bool) {
+	var g grid
+	for _, beat := range analysis.Beats {
+		if beat.Duration > 0 {
+			g.beats = append(g.beats, beat)
+		}
+	}
+	g.bars = analysis.Bars
+	return g, len(g.beats) > 0
+}
+
+// beatAt returns how many beats after the first beat the time is. Times outside the analysed beats carry on at the
+// tempo of the nearest beat.
+func (g grid) beatAt(seconds float64) float64 {
+	first, last := g.beats[0], g.beats[len(g.beats)-1]
+	if seconds < first.Start {
+		return (seconds - first.Start) / first.Duration
+	}
+	i := sort.Search(len(g.beats), func(i int) bool {
+		return g.beats[i].Start > seconds
+	}) - 1
+	if i == len(g.beats)-1 {
+		return float64(i) + (seconds-last.Start)/last.Duration
+	}
+	// Beats can leave gaps or overlap slightly, so measure up to the start of the next beat.
+	length := g.beats[i+1].Start - g.beats[i].Start
+	return float64(i) + (seconds-g.beats[i].Start)/length
+}
+
+// timeAt returns the time, in seconds, of the position in beats. It is the inverse of beatAt.
+func (g grid) timeAt(beat float64) float64 {
+	first, last := g.beats[0], g.beats[len(g.beats)-1]
+	if beat < 0 {
+		return first.Start + beat*first.Duration
+	}
+	i := int(beat)
+	if i >= len(g.beats)-1 {
+		return last.Start + (beat-float64(len(g.beats)-1))*last.Duration
+	}
+	length := g.beats[i+1].Start - g.beats[i].Start
+	return g.beats[i].Start + (beat-float64(i))*length
+}
+
+// tempoAt returns the length of the beat at the position, in seconds.
+func (g grid) tempoAt(beat int) float64 {
+	switch {
+	case beat < 0:
+		return g.beats[0].Duration
+	case beat >= len(g.beats)-1:
+		return g.beats[len(g.beats)-1].Duration
+	default:
+		return g.beats[beat+1].Start - g.beats[beat].Start
+	}
+}
+
+// event is a MIDI message to send at a time in the track.
+type event struct {
+	at      time.Duration
+	message []byte
+	clock   bool
+}
+
+// events returns every clock tick and note of the track, in the order they're sent.
+func (g grid) events(config Config) []event {
+	var events []event
+
+	// The clock ticks from the start of the track, including the lead in before the first beat.
+	start := g.beatAt(0)
+	for tick := int(math.Ceil(start * ClocksPerBeat)); ; tick++ {
+		beat := float64(tick) / ClocksPerBeat
+		if beat >= float64(len(g.beats)) {
+			break
+		}
+		events = append(events, event{at: seconds(g.timeAt(beat)), message: []byte{TimingClock}, clock: true})
+	}
+
+	// Every note lasts half a beat.
+	note := func(number byte, startSeconds float64) {
+		end := g.timeAt(g.beatAt(startSeconds) + 0.5)
+		events = append(events,
+			event{at: seconds(startSeconds), message: noteOn(config.Channel, number, config.Velocity)},
+			event{at: seconds(end), message: noteOff(config.Channel, number)},
+		)
+	}
+	for _, beat := range g.beats {
+		note(config.BeatNote, beat.Start)
+	}
+	for _, bar := range g.bars {
+		note(config.BarNote, bar.Start)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].at < events[j].at
+	})
+	return events
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
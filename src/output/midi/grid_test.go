@@ -0,0 +1,74 @@
+package midi
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+func TestClockSpacing(t *testing.T) {
+	// Half a beat of lead in, a half second beat, then a slower beat.
+	g, ok := newGrid(models.MediaAudioAnalysis{
+		Beats: []models.TimeInterval{{Start: 0.25, Duration: 0.5}, {Start: 0.75, Duration: 0.6}},
+	})
+	if !ok {
+		t.Fatal("no beats in the grid")
+	}
+
+	var clocks []time.Duration
+	for _, e := range g.events(Config{Channel: 1}) {
+		if e.clock {
+			if !bytes.Equal(e.message, []byte{TimingClock}) {
+				t.Fatalf("clock message = % X, want F8", e.message)
+			}
+			clocks = append(clocks, e.at)
+		}
+	}
+
+	// 24 clocks a beat, for the half beat lead in and the two beats.
+	if len(clocks) != 12+2*ClocksPerBeat {
+		t.Fatalf("%d clocks, want %d", len(clocks), 12+2*ClocksPerBeat)
+	}
+	if clocks[0] != 0 {
+		t.Errorf("first clock at %v, want the start of the track", clocks[0])
+	}
+	for i, at := range clocks {
+		// The lead in and first beat are half a second long, and the second beat, from clock 36 at 0.75s, is 0.6s.
+		want := seconds(float64(i) * 0.5 / ClocksPerBeat)
+		if i > 12+ClocksPerBeat {
+			want = seconds(0.75 + float64(i-12-ClocksPerBeat)*0.6/ClocksPerBeat)
+		}
+		if diff := at - want; diff < -time.Microsecond || diff > time.Microsecond {
+			t.Errorf("clock %d at %v, want %v", i, at, want)
+		}
+	}
+}
+
+func TestBeatAtAndTimeAt(t *testing.T) {
+	g, _ := newGrid(models.MediaAudioAnalysis{
+		Beats: []models.TimeInterval{{Start: 1, Duration: 0.5}, {Start: 1.5, Duration: 1}, {Start: 2.5, Duration: 1}},
+	})
+	tests := []struct {
+		seconds, beat float64
+	}{
+		// Before the first beat, the first beat's tempo carries on backwards.
+		{0, -2},
+		{1, 0},
+		{1.25, 0.5},
+		{1.5, 1},
+		{2, 1.5},
+		{2.5, 2},
+		// After the last beat, its tempo carries on.
+		{4.5, 4},
+	}
+	for _, test := range tests {
+		if got := g.beatAt(test.seconds); got != test.beat {
+			t.Errorf("beatAt(%v) = %v, want %v", test.seconds, got, test.beat)
+		}
+		if got := g.timeAt(test.beat); got != test.seconds {
+			t.Errorf("timeAt(%v) = %v, want %v", test.beat, got, test.seconds)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Package midi lets drum machines and sequencers follow the playing track. It sends MIDI clock at 24 pulses per
+// quarter note, start and stop as playback starts and stops, and a note on every beat and bar, to a raw MIDI device
+// such as /dev/snd/midiC1D0. It can also export the beat grid of each track as a standard MIDI file, to import into a
+// DAW.
+//
+// The clock follows the beats of the track's analysis rather than a fixed tempo, so it drifts along with the music.
+package midi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// ClocksPerBeat is how many timing clock messages are sent every beat, as the MIDI spec requires.
+const ClocksPerBeat = 24
+
+// clocksPerSongPosition is how many clocks make up one step of a song position pointer, a sixteenth note.
+const clocksPerSongPosition = 6
+
+// MIDI message status bytes.
+const (
+	NoteOff         = 0x80
+	NoteOn          = 0x90
+	ControlChange   = 0xB0
+	SongPosition    = 0xF2
+	TimingClock     = 0xF8
+	Start           = 0xFA
+	Continue        = 0xFB
+	Stop            = 0xFC
+	allNotesOff     = 123
+	maxSongPosition = 1<<14 - 1
+)
+
+// The defaults used if the config doesn't say.
+const (
+	DefaultChannel  = 10 // The General MIDI drum channel.
+	DefaultBeatNote = 36 // Bass drum.
+	DefaultBarNote  = 49 // Crash cymbal.
+	DefaultVelocity = 100
+)
+
+// Config holds the settings of the MIDI output.
+type Config struct {
+	Device    string // The raw MIDI device to send to. Empty to only export files.
+	ExportDir string // The directory to export a standard MIDI file of each track to. Empty to not export.
+	Channel   byte   // The channel the notes are sent on, from 1 to 16.
+	BeatNote  byte   // The note played on every beat.
+	BarNote   byte   // The note played at the start of every bar.
+	Velocity  byte   // How hard the notes are played, from 1 to 127.
+}
+
+// Output sends MIDI clock and notes in time with the playing track.
+type Output struct {
+	config Config
+	device *os.File
+
+	mu         sync.Mutex
+	events     []event // Every clock tick and note of the playing track.
+	stopEvents chan struct{}
+}
+
+// New opens the MIDI device.
+func New(config Config) (*Output, error) {
+	if config.Device == "" && config.ExportDir == "" {
+		return nil, fmt.Errorf("MIDI output needs a device or an export directory")
+	}
+	if config.Channel == 0 {
+		config.Channel = DefaultChannel
+	}
+	if config.Channel > 16 {
+		return nil, fmt.Errorf("MIDI channel %d isn't between 1 and 16", config.Channel)
+	}
+	if config.BeatNote == 0 {
+		config.BeatNote = DefaultBeatNote
+	}
+	if config.BarNote == 0 {
+		config.BarNote = DefaultBarNote
+	}
+	if config.Velocity == 0 {
+		config.Velocity = DefaultVelocity
+	}
+	if config.BeatNote > 127 || config.BarNote > 127 || config.Velocity > 127 {
+		return nil, fmt.Errorf("MIDI notes and velocity go up to 127")
+	}
+
+	o := &Output{config: config}
+	if config.Device != "" {
+		device, err := os.OpenFile(config.Device, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		o.device = device
+	}
+	return o, nil
+}
+
+// Trigger does nothing, as the notes follow the beat grid whatever the trigger type.
+func (o *Output) Trigger(trigger output.Trigger) {}
+
+// Media exports the track's beat grid, and starts sending its clock and notes if it's playing.
+func (o *Output) Media(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis) {
+	g, ok := newGrid(analysis)
+	if !ok {
+		log.Println("No beats to send over MIDI for", media.Item.Name)
+		o.stopSendingEvents(nil)
+		o.mu.Lock()
+		o.events = nil
+		o.mu.Unlock()
+		return
+	}
+
+	if o.config.ExportDir != "" {
+		go func() {
+			file := filepath.Join(o.config.ExportDir, media.Item.ID+".mid")
+			if err := WriteFile(file, media.Item.Name, analysis, o.config); err != nil {
+				log.Println("Failed to export MIDI file:", err)
+			}
+		}()
+	}
+
+	o.mu.Lock()
+	o.events = g.events(o.config)
+	o.mu.Unlock()
+	if media.IsPlaying {
+		o.startEvents(time.Duration(media.Progress) * time.Millisecond)
+	}
+}
+
+// PlayState starts and stops the clock. Playback starting does nothing until Media has been called with the track, or
+// if Media has already started the clock.
+func (o *Output) PlayState(playing bool, position time.Duration) {
+	if !playing {
+		o.stopSendingEvents(o.stopMessages())
+		return
+	}
+	o.mu.Lock()
+	running := o.stopEvents != nil
+	o.mu.Unlock()
+	if !running {
+		o.startEvents(position)
+	}
+}
+
+// Close stops the clock, and closes the MIDI device.
+func (o *Output) Close() error {
+	o.stopSendingEvents(o.stopMessages())
+	if o.device == nil {
+		return nil
+	}
+	return o.device.Close()
+}
+
+// startEvents starts the sequencers from the position, then sends each clock tick and note when it's due.
+func (o *Output) startEvents(position time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.device == nil || len(o.events) == 0 {
+		return
+	}
+	if o.stopEvents != nil {
+		close(o.stopEvents)
+	}
+	stop := make(chan struct{})
+	o.stopEvents = stop
+
+	// Skip to the position, counting the clocks already missed.
+	events := o.events
+	next := sort.Search(len(events), func(i int) bool {
+		return events[i].at >= position
+	})
+	clocks := 0
+	for _, e := range events[:next] {
+		if e.clock {
+			clocks++
+		}
+	}
+
+	// Start only plays from the top, so other positions need a song position pointer first.
+	// The pointer counts sixteenths, so the sequencer waits for the next one before continuing.
+	if clocks == 0 {
+		o.write(Start)
+	} else {
+		sixteenths := (clocks + clocksPerSongPosition - 1) / clocksPerSongPosition
+		if sixteenths > maxSongPosition {
+			sixteenths = maxSongPosition
+		}
+		o.write(SongPosition, byte(sixteenths&0x7F), byte(sixteenths>>7))
+		o.write(Continue)
+		for next < len(events) && (!events[next].clock || clocks%clocksPerSongPosition != 0) {
+			if events[next].clock {
+				clocks++
+			}
+			next++
+		}
+	}
+
+	startedAt := time.Now().Add(-position)
+	go func() {
+		for _, e := range events[next:] {
+			timer := time.NewTimer(time.Until(startedAt.Add(e.at)))
+			select {
+			case <-timer.C:
+				o.write(e.message...)
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// stopSendingEvents stops sending clock and notes, then sends the messages.
+func (o *Output) stopSendingEvents(messages [][]byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopEvents == nil {
+		return
+	}
+	close(o.stopEvents)
+	o.stopEvents = nil
+	for _, message := range messages {
+		o.write(message...)
+	}
+}
+
+// stopMessages returns the messages that stop the sequencers, and silence any notes still playing.
+func (o *Output) stopMessages() [][]byte {
+	return [][]byte{
+		{Stop},
+		{ControlChange | (o.config.Channel - 1), allNotesOff, 0},
+	}
+}
+
+// write sends a message to the MIDI device.
+func (o *Output) write(message ...byte) {
+	if o.device == nil {
+		return
+	}
+	if _, err := o.device.Write(message); err != nil {
+		log.Printf("Failed to send MIDI message to %s: %v\n", o.config.Device, err)
+	}
+}
+
+func noteOn(channel byte, note byte, velocity byte) []byte {
+	return []byte{NoteOn | (channel - 1), note, velocity}
+}
+
+func noteOff(channel byte, note byte) []byte {
+	return []byte{NoteOff | (channel - 1), note, 0}
+}
@@ -0,0 +1,88 @@
+package midi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// newTestOutput returns an output writing to a file standing in for the MIDI device, and a function that counts the
+// Start messages written so far.
+func newTestOutput(t *testing.T) (*Output, func() int) {
+	t.Helper()
+	device := filepath.Join(t.TempDir(), "midi")
+	if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	o, err := New(Config{Device: device})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+
+	// Data bytes are below 0x80, so any 0xFA is a Start message.
+	starts := func() int {
+		written, err := ioutil.ReadFile(device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Count(written, []byte{Start})
+	}
+	return o, starts
+}
+
+// testAnalysis returns an analysis with a minute of beats at 120 BPM.
+func testAnalysis() models.MediaAudioAnalysis {
+	var analysis models.MediaAudioAnalysis
+	for i := 0; i < 120; i++ {
+		analysis.Beats = append(analysis.Beats, models.TimeInterval{Start: float64(i) / 2, Duration: 0.5})
+	}
+	return analysis
+}
+
+func TestPlayStateBeforeMedia(t *testing.T) {
+	o, starts := newTestOutput(t)
+
+	// Without a track there's nothing to start.
+	o.PlayState(true, 0)
+	if n := starts(); n != 0 {
+		t.Fatalf("%d Start messages sent before the media, want 0", n)
+	}
+
+	var media models.Media
+	media.IsPlaying = true
+	o.Media(media, models.MediaAudioFeatures{}, testAnalysis())
+	if n := starts(); n != 1 {
+		t.Fatalf("%d Start messages sent for the media, want 1", n)
+	}
+
+	// The clock is already running, so playback starting doesn't restart it.
+	o.PlayState(true, 0)
+	if n := starts(); n != 1 {
+		t.Fatalf("%d Start messages sent after the play state, want 1", n)
+	}
+
+	// Once stopped, playing starts the clock again.
+	o.PlayState(false, time.Second)
+	o.PlayState(true, 0)
+	if n := starts(); n != 2 {
+		t.Fatalf("%d Start messages sent after playing again, want 2", n)
+	}
+}
+
+func TestPlayStateAfterMediaWithoutBeats(t *testing.T) {
+	o, starts := newTestOutput(t)
+	var media models.Media
+	o.Media(media, models.MediaAudioFeatures{}, testAnalysis())
+
+	// The new media has no beats, so the last media's mustn't be played.
+	o.Media(media, models.MediaAudioFeatures{}, models.MediaAudioAnalysis{})
+	o.PlayState(true, 0)
+	if n := starts(); n != 0 {
+		t.Fatalf("%d Start messages sent, want 0", n)
+	}
+}
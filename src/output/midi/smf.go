@@ -0,0 +1,119 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// ticksPerBeat is the resolution of exported files.
+const ticksPerBeat = 480
+
+// Meta event types.
+const (
+	metaTrackName  = 0x03
+	metaEndOfTrack = 0x2F
+	metaTempo      = 0x51
+)
+
+// maxTempo is the longest beat a tempo event can hold, in microseconds.
+const maxTempo = 1<<24 - 1
+
+// trackEvent is a single event of a standard MIDI file track.
+type trackEvent struct {
+	tick int
+	data []byte // The event, without its delta time.
+}
+
+// WriteFile exports the beat grid of the analysis as a standard MIDI file, with a tempo change on every beat so the
+// file lines up with the track when imported into a DAW.
+func WriteFile(file string, name string, analysis models.MediaAudioAnalysis, config Config) error {
+	b, err := EncodeFile(name, analysis, config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// EncodeFile encodes the beat grid of the analysis as a format 0 standard MIDI file.
+func EncodeFile(name string, analysis models.MediaAudioAnalysis, config Config) ([]byte, error) {
+	g, ok := newGrid(analysis)
+	if !ok {
+		return nil, errors.New("the analysis has no beats")
+	}
+
+	// The file starts at the start of the track, which is usually part way through a beat.
+	lead := -g.beatAt(0)
+	tickAt := func(seconds float64) int {
+		return int(math.Round((g.beatAt(seconds) + lead) * ticksPerBeat))
+	}
+
+	events := []trackEvent{{tick: 0, data: metaEvent(metaTrackName, []byte(name))}}
+	tempo := func(tick int, beat int) {
+		microseconds := int(math.Round(g.tempoAt(beat) * 1e6))
+		if microseconds > maxTempo {
+			microseconds = maxTempo
+		}
+		data := []byte{byte(microseconds >> 16), byte(microseconds >> 8), byte(microseconds)}
+		events = append(events, trackEvent{tick: tick, data: metaEvent(metaTempo, data)})
+	}
+	tempo(0, -1)
+	for i := range g.beats {
+		tempo(int(math.Round((float64(i)+lead)*ticksPerBeat)), i)
+	}
+
+	for _, e := range g.events(config) {
+		if !e.clock {
+			events = append(events, trackEvent{tick: tickAt(e.at.Seconds()), data: e.message})
+		}
+	}
+
+	// Tempo changes come before the notes they time.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].tick < events[j].tick
+	})
+	last := events[len(events)-1].tick
+	events = append(events, trackEvent{tick: last, data: metaEvent(metaEndOfTrack, nil)})
+
+	var track bytes.Buffer
+	tick := 0
+	for _, e := range events {
+		track.Write(appendVarInt(nil, uint32(e.tick-tick)))
+		track.Write(e.data)
+		tick = e.tick
+	}
+
+	var file bytes.Buffer
+	file.WriteString("MThd")
+	binary.Write(&file, binary.BigEndian, uint32(6))
+	binary.Write(&file, binary.BigEndian, uint16(0)) // Format 0, a single track.
+	binary.Write(&file, binary.BigEndian, uint16(1))
+	binary.Write(&file, binary.BigEndian, uint16(ticksPerBeat))
+	file.WriteString("MTrk")
+	binary.Write(&file, binary.BigEndian, uint32(track.Len()))
+	file.Write(track.Bytes())
+	return file.Bytes(), nil
+}
+
+func metaEvent(kind byte, data []byte) []byte {
+	event := append([]byte{0xFF, kind}, appendVarInt(nil, uint32(len(data)))...)
+	return append(event, data...)
+}
+
+// appendVarInt appends the number in the variable length format of standard MIDI files, 7 bits at a time with the
+// top bit set on all but the last byte.
+func appendVarInt(b []byte, n uint32) []byte {
+	var groups [5]byte
+	i := len(groups) - 1
+	groups[i] = byte(n & 0x7F)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		groups[i] = byte(n&0x7F) | 0x80
+	}
+	return append(b, groups[i:]...)
+}
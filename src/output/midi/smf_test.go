@@ -0,0 +1,94 @@
+package midi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+func TestAppendVarInt(t *testing.T) {
+	tests := []struct {
+		n    uint32
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x40, []byte{0x40}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0x81, 0x00}},
+		{240, []byte{0x81, 0x70}},
+		{0x2000, []byte{0xC0, 0x00}},
+		{0x3FFF, []byte{0xFF, 0x7F}},
+		{0x4000, []byte{0x81, 0x80, 0x00}},
+		{0x1FFFFF, []byte{0xFF, 0xFF, 0x7F}},
+		{0x200000, []byte{0x81, 0x80, 0x80, 0x00}},
+		{0x0FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+	for _, test := range tests {
+		if got := appendVarInt([]byte{0xAA}, test.n); !bytes.Equal(got, append([]byte{0xAA}, test.want...)) {
+			t.Errorf("appendVarInt(%#x) = % X, want AA % X", test.n, got, test.want)
+		}
+	}
+}
+
+func TestEncodeFile(t *testing.T) {
+	// Two beats a quarter of a second into the track, the second one slower, in a single bar.
+	analysis := models.MediaAudioAnalysis{
+		Beats: []models.TimeInterval{{Start: 0.25, Duration: 0.5}, {Start: 0.75, Duration: 0.6}},
+		Bars:  []models.TimeInterval{{Start: 0.25, Duration: 1.1}},
+	}
+	config := Config{Channel: 10, BeatNote: 36, BarNote: 49, Velocity: 100}
+
+	// The file starts at the start of the track, half a beat (240 ticks) before the first beat. Deltas of 240 ticks
+	// take two bytes.
+	track := []byte{
+		0x00, 0xFF, 0x03, 0x01, 't', // Track name.
+		0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20, // 500000µs a beat for the lead in.
+		0x81, 0x70, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20, // 500000µs for the first beat.
+		0x00, 0x99, 36, 100, // Beat note on, on channel 10.
+		0x00, 0x99, 49, 100, // Bar note on.
+		0x81, 0x70, 0x89, 36, 0, // Both notes off half a beat later.
+		0x00, 0x89, 49, 0,
+		0x81, 0x70, 0xFF, 0x51, 0x03, 0x09, 0x27, 0xC0, // 600000µs for the second beat.
+		0x00, 0x99, 36, 100,
+		0x81, 0x70, 0x89, 36, 0,
+		0x00, 0xFF, 0x2F, 0x00, // End of track.
+	}
+	want := []byte{
+		'M', 'T', 'h', 'd',
+		0, 0, 0, 6, // Header length.
+		0, 0, // Format 0.
+		0, 1, // One track.
+		0x01, 0xE0, // 480 ticks a beat.
+		'M', 'T', 'r', 'k',
+		0, 0, 0, byte(len(track)),
+	}
+	want = append(want, track...)
+
+	got, err := EncodeFile("t", analysis, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("file =\n% X\nwant\n% X", got, want)
+	}
+}
+
+func TestEncodeFileLimitsTempo(t *testing.T) {
+	// A beat longer than a tempo event can hold is clamped to the longest tempo.
+	analysis := models.MediaAudioAnalysis{Beats: []models.TimeInterval{{Start: 0, Duration: 20}}}
+	got, err := EncodeFile("", analysis, Config{Channel: 1, BeatNote: 36, Velocity: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte{0xFF, 0x51, 0x03, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("file = % X, want the longest tempo", got)
+	}
+}
+
+func TestEncodeFileWithoutBeats(t *testing.T) {
+	analysis := models.MediaAudioAnalysis{Beats: []models.TimeInterval{{Start: 1, Duration: 0}}}
+	if _, err := EncodeFile("t", analysis, Config{}); err == nil {
+		t.Error("encoded a file without any beats")
+	}
+}
@@ -0,0 +1,159 @@
+// Package osc sends the gateway's beats, bars, sections and audio features to VJ software such as Resolume and
+// TouchDesigner using Open Sound Control, and receives OSC commands.
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Message is a single OSC message. Arguments can be int32, float32, string, []byte blobs or bool.
+type Message struct {
+	Address   string
+	Arguments []interface{}
+}
+
+// MarshalBinary encodes the message.
+func (m Message) MarshalBinary() ([]byte, error) {
+	if !strings.HasPrefix(m.Address, "/") {
+		return nil, errors.New("OSC address must start with /: " + m.Address)
+	}
+
+	var tags strings.Builder
+	var args bytes.Buffer
+	tags.WriteByte(',')
+	for _, arg := range m.Arguments {
+		switch a := arg.(type) {
+		case int32:
+			tags.WriteByte('i')
+			binary.Write(&args, binary.BigEndian, a)
+		case int:
+			tags.WriteByte('i')
+			binary.Write(&args, binary.BigEndian, int32(a))
+		case float32:
+			tags.WriteByte('f')
+			binary.Write(&args, binary.BigEndian, math.Float32bits(a))
+		case float64:
+			tags.WriteByte('f')
+			binary.Write(&args, binary.BigEndian, math.Float32bits(float32(a)))
+		case string:
+			tags.WriteByte('s')
+			args.Write(padString(a))
+		case []byte:
+			tags.WriteByte('b')
+			binary.Write(&args, binary.BigEndian, int32(len(a)))
+			args.Write(a)
+			args.Write(make([]byte, padding(len(a))))
+		case bool:
+			if a {
+				tags.WriteByte('T')
+			} else {
+				tags.WriteByte('F')
+			}
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument type %T", arg)
+		}
+	}
+
+	var b bytes.Buffer
+	b.Write(padString(m.Address))
+	b.Write(padString(tags.String()))
+	b.Write(args.Bytes())
+	return b.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the message.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	address, rest, err := readString(data)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(address, "/") {
+		return errors.New("not an OSC message")
+	}
+	*m = Message{Address: address}
+
+	// Messages without type tags have no arguments.
+	if len(rest) == 0 {
+		return nil
+	}
+	tags, rest, err := readString(rest)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(tags, ",") {
+		return errors.New("invalid OSC type tags: " + tags)
+	}
+
+	for _, tag := range tags[1:] {
+		switch tag {
+		case 'i':
+			if len(rest) < 4 {
+				return errors.New("OSC message too short")
+			}
+			m.Arguments = append(m.Arguments, int32(binary.BigEndian.Uint32(rest)))
+			rest = rest[4:]
+		case 'f':
+			if len(rest) < 4 {
+				return errors.New("OSC message too short")
+			}
+			m.Arguments = append(m.Arguments, math.Float32frombits(binary.BigEndian.Uint32(rest)))
+			rest = rest[4:]
+		case 's':
+			var s string
+			if s, rest, err = readString(rest); err != nil {
+				return err
+			}
+			m.Arguments = append(m.Arguments, s)
+		case 'b':
+			if len(rest) < 4 {
+				return errors.New("OSC message too short")
+			}
+			size := int(int32(binary.BigEndian.Uint32(rest)))
+			rest = rest[4:]
+			if size < 0 || size+padding(size) > len(rest) {
+				return errors.New("OSC blob too short")
+			}
+			blob := make([]byte, size)
+			copy(blob, rest)
+			m.Arguments = append(m.Arguments, blob)
+			rest = rest[size+padding(size):]
+		case 'T':
+			m.Arguments = append(m.Arguments, true)
+		case 'F':
+			m.Arguments = append(m.Arguments, false)
+		default:
+			return fmt.Errorf("unsupported OSC type tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// padString encodes the string with a null terminator, padded to a multiple of 4 bytes.
+func padString(s string) []byte {
+	b := make([]byte, (len(s)/4+1)*4)
+	copy(b, s)
+	return b
+}
+
+// padding returns how many bytes pad a blob of the size to a multiple of 4 bytes.
+func padding(size int) int {
+	return (4 - size%4) % 4
+}
+
+// readString reads a padded string, returning the rest of the data after it.
+func readString(data []byte) (string, []byte, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return "", nil, errors.New("unterminated OSC string")
+	}
+	padded := (end/4 + 1) * 4
+	if padded > len(data) {
+		return "", nil, errors.New("OSC string not padded")
+	}
+	return string(data[:end]), data[padded:], nil
+}
@@ -0,0 +1,130 @@
+package osc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want []byte
+	}{
+		{
+			name: "no arguments",
+			msg:  Message{Address: "/ab"},
+			want: []byte("/ab\x00,\x00\x00\x00"),
+		},
+		{
+			name: "address padded to 4 bytes",
+			msg:  Message{Address: "/beat"},
+			want: []byte("/beat\x00\x00\x00,\x00\x00\x00"),
+		},
+		{
+			name: "int",
+			msg:  Message{Address: "/a", Arguments: []interface{}{int32(-2)}},
+			want: []byte("/a\x00\x00,i\x00\x00\xff\xff\xff\xfe"),
+		},
+		{
+			name: "float",
+			msg:  Message{Address: "/a", Arguments: []interface{}{float32(0.5)}},
+			want: []byte("/a\x00\x00,f\x00\x00\x3f\x00\x00\x00"),
+		},
+		{
+			name: "string padded with its terminator",
+			msg:  Message{Address: "/a", Arguments: []interface{}{"bar"}},
+			want: []byte("/a\x00\x00,s\x00\x00bar\x00"),
+		},
+		{
+			name: "string of 4 bytes padded to 8",
+			msg:  Message{Address: "/a", Arguments: []interface{}{"beat"}},
+			want: []byte("/a\x00\x00,s\x00\x00beat\x00\x00\x00\x00"),
+		},
+		{
+			name: "blob padded without a terminator",
+			msg:  Message{Address: "/a", Arguments: []interface{}{[]byte{1, 2, 3, 4, 5}}},
+			want: []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x05\x01\x02\x03\x04\x05\x00\x00\x00"),
+		},
+		{
+			name: "booleans have no data",
+			msg:  Message{Address: "/a", Arguments: []interface{}{true, false}},
+			want: []byte("/a\x00\x00,TF\x00"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := test.msg.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, test.want) {
+				t.Errorf("encoded % x, want % x", b, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := Message{Address: "/lightbeat/media", Arguments: []interface{}{
+		int32(7), float32(-1.25), "Song 2", []byte{}, []byte{0xde, 0xad, 0xbe}, "", true, int32(1 << 30), false,
+	}}
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b)%4 != 0 {
+		t.Errorf("message is %d bytes, want a multiple of 4", len(b))
+	}
+	var decoded Message
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("decoded %#v, want %#v", decoded, msg)
+	}
+
+	// Go ints and floats are sent as their 32 bit OSC types.
+	b, err = Message{Address: "/a", Arguments: []interface{}{3, 0.25}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int32(3), float32(0.25)}; !reflect.DeepEqual(decoded.Arguments, want) {
+		t.Errorf("decoded %#v, want %#v", decoded.Arguments, want)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	if _, err := (Message{Address: "beat"}).MarshalBinary(); err == nil {
+		t.Error("encoded an address without a leading /")
+	}
+	if _, err := (Message{Address: "/a", Arguments: []interface{}{int64(1)}}).MarshalBinary(); err == nil {
+		t.Error("encoded an unsupported argument")
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unterminated address", []byte("/abc")},
+		{"not an address", []byte("abc\x00")},
+		{"unpadded address", []byte("/abcd\x00")},
+		{"missing type tag comma", []byte("/a\x00\x00i\x00\x00\x00\x00\x00\x00\x01")},
+		{"short int", []byte("/a\x00\x00,i\x00\x00\x00\x01")},
+		{"short float", []byte("/a\x00\x00,f\x00\x00")},
+		{"short blob", []byte("/a\x00\x00,b\x00\x00\x00\x00\x00\x08\x01\x02\x03\x04")},
+		{"negative blob", []byte("/a\x00\x00,b\x00\x00\xff\xff\xff\xff")},
+		{"unknown tag", []byte("/a\x00\x00,x\x00\x00")},
+	}
+	for _, test := range tests {
+		var msg Message
+		if err := msg.UnmarshalBinary(test.data); err == nil {
+			t.Errorf("%s: decoded %#v", test.name, msg)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package osc
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// DefaultPrefix is the start of the address of every message, if the config doesn't say.
+const DefaultPrefix = "/lightbeat"
+
+// Config holds the settings of the OSC output.
+type Config struct {
+	Targets []string // The host:port of each OSC receiver.
+	Prefix  string   // The start of the address of every message.
+}
+
+// Output sends messages to the OSC receivers:
+//
+//	<prefix>/beat, <prefix>/bar and <prefix>/section with the index and duration (in seconds) of each one as it starts
+//	<prefix>/trigger with the type and number of each of the gateway's triggers
+//	<prefix>/media with the name and Spotify ID of new media
+//	<prefix>/energy, /valence, /danceability, /tempo and /loudness with the audio features of new media
+//	<prefix>/playing with 1 when playback starts and 0 when it stops
+type Output struct {
+	prefix  string
+	conn    *net.UDPConn
+	targets []*net.UDPAddr
+
+	mu         sync.Mutex
+	events     []event // Every beat, bar and section of the playing track.
+	stopEvents chan struct{}
+}
+
+// event is a beat, bar or section starting.
+type event struct {
+	kind     string
+	index    int
+	start    time.Duration
+	duration time.Duration
+}
+
+// New creates an OSC output sending to the targets.
+func New(config Config) (*Output, error) {
+	if config.Prefix == "" {
+		config.Prefix = DefaultPrefix
+	}
+	o := &Output{prefix: config.Prefix}
+	for _, target := range config.Targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			return nil, err
+		}
+		o.targets = append(o.targets, addr)
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	o.conn = conn
+	return o, nil
+}
+
+// Trigger sends the trigger.
+func (o *Output) Trigger(trigger output.Trigger) {
+	o.send("/trigger", string(trigger.Type), trigger.Number)
+}
+
+// Media sends the media's audio features, and starts sending its beats, bars and sections if it's playing.
+func (o *Output) Media(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis) {
+	o.send("/media", media.Item.Name, media.Item.ID)
+	o.send("/energy", features.Energy)
+	o.send("/valence", features.Valence)
+	o.send("/danceability", features.Danceability)
+	o.send("/tempo", features.Tempo)
+	o.send("/loudness", features.Loudness)
+
+	o.mu.Lock()
+	o.events = analysisEvents(analysis)
+	o.mu.Unlock()
+	if !media.IsPlaying {
+		o.stopSendingEvents()
+		return
+	}
+	o.startEvents(time.Duration(media.Progress) * time.Millisecond)
+}
+
+// PlayState sends whether media is playing, and starts or stops sending beats with it. Playback starting sends no
+// beats until Media has been called with the track, or if Media has already started sending them.
+func (o *Output) PlayState(playing bool, position time.Duration) {
+	if !playing {
+		o.stopSendingEvents()
+		o.send("/playing", 0)
+		return
+	}
+	o.send("/playing", 1)
+	o.mu.Lock()
+	running := o.stopEvents != nil
+	o.mu.Unlock()
+	if !running {
+		o.startEvents(position)
+	}
+}
+
+// Close stops the output.
+func (o *Output) Close() error {
+	o.stopSendingEvents()
+	return o.conn.Close()
+}
+
+// analysisEvents returns every beat, bar and section of the analysis, in the order they start.
+func analysisEvents(analysis models.MediaAudioAnalysis) []event {
+	var events []event
+	add := func(kind string, intervals []models.TimeInterval) {
+		for i, interval := range intervals {
+			start := time.Duration(interval.Start * float64(time.Second))
+			events = append(events, event{
+				kind:     kind,
+				index:    i,
+				start:    start,
+				duration: time.Duration(interval.Duration * float64(time.Second)),
+			})
+		}
+	}
+	add("/section", analysis.Sections)
+	add("/bar", analysis.Bars)
+	add("/beat", analysis.Beats)
+
+	// Sections start before the bars and beats they contain.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].start < events[j].start
+	})
+	return events
+}
+
+// startEvents sends each event from the position onwards when it starts, replacing any events already being sent.
+func (o *Output) startEvents(position time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.events) == 0 {
+		return
+	}
+	if o.stopEvents != nil {
+		close(o.stopEvents)
+	}
+	stop := make(chan struct{})
+	o.stopEvents = stop
+
+	events := o.events[sort.Search(len(o.events), func(i int) bool {
+		return o.events[i].start >= position
+	}):]
+
+	startedAt := time.Now().Add(-position)
+	go func() {
+		for _, e := range events {
+			timer := time.NewTimer(time.Until(startedAt.Add(e.start)))
+			select {
+			case <-timer.C:
+				o.send(e.kind, e.index, e.duration.Seconds())
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (o *Output) stopSendingEvents() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stopEvents != nil {
+		close(o.stopEvents)
+		o.stopEvents = nil
+	}
+}
+
+// send sends a message to every target.
+func (o *Output) send(address string, arguments ...interface{}) {
+	b, err := Message{Address: o.prefix + address, Arguments: arguments}.MarshalBinary()
+	if err != nil {
+		log.Println("Failed to encode OSC message:", err)
+		return
+	}
+	for _, target := range o.targets {
+		if _, err := o.conn.WriteToUDP(b, target); err != nil {
+			log.Printf("Failed to send OSC message to %s: %v\n", target, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package osc
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// listen starts a server on loopback, returning the messages it receives.
+func listen(t *testing.T) (*Server, chan Message) {
+	t.Helper()
+	received := make(chan Message, 100)
+	server, err := Listen("127.0.0.1:0", func(msg Message) { received <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, received
+}
+
+// next returns the next message received, failing if none arrives.
+func next(t *testing.T, received chan Message) Message {
+	t.Helper()
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no OSC message received")
+		return Message{}
+	}
+}
+
+// expect checks the next messages received have the addresses, in order.
+func expect(t *testing.T, received chan Message, addresses ...string) {
+	t.Helper()
+	for _, address := range addresses {
+		if msg := next(t, received); msg.Address != address {
+			t.Fatalf("received %s, want %s", msg.Address, address)
+		}
+	}
+}
+
+// expectNothing checks nothing more is received for a while.
+func expectNothing(t *testing.T, received chan Message) {
+	t.Helper()
+	select {
+	case msg := <-received:
+		t.Fatalf("received %s %v, want nothing", msg.Address, msg.Arguments)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func newTestOutput(t *testing.T, server *Server) *Output {
+	t.Helper()
+	o, err := New(Config{Targets: []string{server.Addr().String()}, Prefix: "/test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+func TestListen(t *testing.T) {
+	server, received := listen(t)
+	conn, err := net.Dial("udp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Invalid packets are dropped, and later messages still arrive.
+	if _, err := conn.Write([]byte("not osc")); err != nil {
+		t.Fatal(err)
+	}
+	want := Message{Address: "/lightbeat/set-palette", Arguments: []interface{}{"ocean", int32(1)}}
+	b, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if msg := next(t, received); !reflect.DeepEqual(msg, want) {
+		t.Errorf("received %#v, want %#v", msg, want)
+	}
+}
+
+func TestOutputTrigger(t *testing.T) {
+	server, received := listen(t)
+	o := newTestOutput(t, server)
+
+	o.Trigger(output.Trigger{Type: models.Bar, Number: 12})
+	msg := next(t, received)
+	if want := (Message{Address: "/test/trigger", Arguments: []interface{}{"bar", int32(12)}}); !reflect.DeepEqual(msg, want) {
+		t.Errorf("received %#v, want %#v", msg, want)
+	}
+}
+
+// testMedia returns a track with a section, bar and beat at the start, and another beat shortly after.
+func testMedia(playing bool) (models.Media, models.MediaAudioAnalysis) {
+	media := models.Media{IsPlaying: playing}
+	media.Item.ID, media.Item.Name = "track", "Song"
+	analysis := models.MediaAudioAnalysis{
+		Sections: []models.TimeInterval{{Start: 0, Duration: 10}},
+		Bars:     []models.TimeInterval{{Start: 0, Duration: 2}},
+		Beats:    []models.TimeInterval{{Start: 0, Duration: 0.05}, {Start: 0.05, Duration: 0.5}},
+	}
+	return media, analysis
+}
+
+var mediaAddresses = []string{"/test/media", "/test/energy", "/test/valence", "/test/danceability", "/test/tempo",
+	"/test/loudness"}
+
+func TestOutputSendsBeatsWhilePlaying(t *testing.T) {
+	server, received := listen(t)
+	o := newTestOutput(t, server)
+
+	media, analysis := testMedia(true)
+	o.Media(media, models.MediaAudioFeatures{Tempo: 120}, analysis)
+	expect(t, received, mediaAddresses...)
+	// Sections come before the bars and beats that start with them.
+	expect(t, received, "/test/section", "/test/bar", "/test/beat")
+	msg := next(t, received)
+	if want := (Message{Address: "/test/beat", Arguments: []interface{}{int32(1), float32(0.5)}}); !reflect.DeepEqual(msg, want) {
+		t.Errorf("received %#v, want %#v", msg, want)
+	}
+}
+
+func TestOutputPaused(t *testing.T) {
+	server, received := listen(t)
+	o := newTestOutput(t, server)
+
+	// Paused media only sends its features.
+	media, analysis := testMedia(false)
+	o.Media(media, models.MediaAudioFeatures{}, analysis)
+	expect(t, received, mediaAddresses...)
+	expectNothing(t, received)
+
+	// The beats are sent from where playback starts.
+	o.PlayState(true, 10*time.Millisecond)
+	expect(t, received, "/test/playing", "/test/beat")
+	o.PlayState(false, 60*time.Millisecond)
+	expect(t, received, "/test/playing")
+	expectNothing(t, received)
+}
@@ -0,0 +1,59 @@
+package osc
+
+import (
+	"log"
+	"net"
+)
+
+// maxPacketSize is the largest OSC packet the server reads.
+const maxPacketSize = 65535
+
+// Server receives OSC messages, such as commands from VJ software.
+type Server struct {
+	conn *net.UDPConn
+}
+
+// Listen starts receiving OSC messages on the address, e.g. ":9001", calling the handler with each one.
+// Bundles aren't supported.
+func Listen(address string, handler func(Message)) (*Server, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Listening for OSC messages on", conn.LocalAddr())
+
+	s := &Server{conn: conn}
+	go s.receive(handler)
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close stops receiving messages.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Server) receive(handler func(Message)) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			// The connection has been closed.
+			return
+		}
+		var msg Message
+		if err := msg.UnmarshalBinary(buf[:n]); err != nil {
+			log.Printf("Invalid OSC message from %s: %v\n", from, err)
+			continue
+		}
+		handler(msg)
+	}
+}
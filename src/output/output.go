@@ -0,0 +1,125 @@
+// Package output drives lighting and music equipment other than the edge devices, such as DMX fixtures or VJ
+// software, from the gateway's triggers.
+package output
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Trigger describes a single trigger of the lightshow.
+type Trigger struct {
+	Type       models.TriggerType
+	Number     int           // The index of the trigger in the track.
+	Position   time.Duration // How far through the track the trigger is.
+	Duration   time.Duration // How long the trigger lasts.
+	Color      colors.Color  // The color of the trigger.
+	Brightness float64       // The brightness of the lights, from 0 to 1.
+}
+
+// Output is something driven by the gateway's triggers.
+type Output interface {
+	// Trigger is called at the start of every trigger. It must not block.
+	Trigger(trigger Trigger)
+
+	// Close stops the output.
+	Close() error
+}
+
+// MediaListener is implemented by outputs that need to know about the media being played.
+type MediaListener interface {
+	// Media is called when the playing media changes, or playback moves to a different part of the media.
+	Media(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis)
+}
+
+// PlayStateListener is implemented by outputs that need to know when playback starts and stops.
+type PlayStateListener interface {
+	// PlayState is called when playback starts or stops, with how far through the media it is.
+	PlayState(playing bool, position time.Duration)
+}
+
+// outputs holds every output, by name.
+var outputs = map[string]Output{}
+var outputsMu sync.RWMutex
+
+// Add sends the triggers to the output. An output with the same name as an existing one replaces it.
+func Add(name string, output Output) {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	outputs[name] = output
+}
+
+// Remove stops sending triggers to the named output, and closes it.
+func Remove(name string) error {
+	outputsMu.Lock()
+	output, exists := outputs[name]
+	delete(outputs, name)
+	outputsMu.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return output.Close()
+}
+
+// CloseAll closes and removes every output.
+func CloseAll() {
+	outputsMu.Lock()
+	closing := outputs
+	outputs = map[string]Output{}
+	outputsMu.Unlock()
+
+	for name, output := range closing {
+		if err := output.Close(); err != nil {
+			log.Printf("Failed to close output %s: %v\n", name, err)
+		}
+	}
+}
+
+// SendTrigger passes the trigger to every output.
+func SendTrigger(trigger Trigger) {
+	for _, output := range all() {
+		output.Trigger(trigger)
+	}
+}
+
+// SendMedia tells every output that wants to know about the playing media.
+func SendMedia(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis) {
+	for _, output := range all() {
+		if listener, ok := output.(MediaListener); ok {
+			listener.Media(media, features, analysis)
+		}
+	}
+}
+
+// SendPlayState tells every output that wants to know that playback has started or stopped.
+func SendPlayState(playing bool, position time.Duration) {
+	for _, output := range all() {
+		if listener, ok := output.(PlayStateListener); ok {
+			listener.PlayState(playing, position)
+		}
+	}
+}
+
+// all returns every output, in name order.
+func all() []Output {
+	outputsMu.RLock()
+	defer outputsMu.RUnlock()
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	all := make([]Output, len(names))
+	for i, name := range names {
+		all[i] = outputs[name]
+	}
+	return all
+}
@@ -0,0 +1,278 @@
+// Package wled drives LED strips running WLED firmware, so the gateway's beat effects appear on them without custom
+// firmware. Frames are streamed with WLED's realtime UDP protocol (DRGB, or DNRGB for long strips), and presets are
+// loaded through its JSON API.
+package wled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// RealtimePort is the UDP port WLED listens for realtime frames on.
+const RealtimePort = 21324
+
+const (
+	protocolDRGB  = 2
+	protocolDNRGB = 4
+
+	// maxDRGBLEDs is the most LEDs a DRGB packet can carry. Longer strips are sent in DNRGB chunks.
+	maxDRGBLEDs = 490
+
+	// maxDNRGBLEDs is the most LEDs a single DNRGB packet can carry.
+	maxDNRGBLEDs = 489
+
+	// realtimeTimeout is how many seconds WLED waits after the last frame before going back to its own effects.
+	realtimeTimeout = 2
+
+	// DefaultFPS is how many frames a second are sent if the config doesn't say.
+	DefaultFPS = 50
+)
+
+// Config describes a single WLED device.
+type Config struct {
+	Host        string // The hostname or IP address of the device.
+	LEDs        int    // How many LEDs are on the strip.
+	Port        int    // The UDP port to send realtime frames to. Defaults to RealtimePort.
+	FPS         int    // How many frames a second to send.
+	Presets     []int  // Presets to load when playback stops, chosen by the energy of the media.
+	IdlePreset  int    // The preset to load when playback stops without a media preset. 0 to leave WLED as it is.
+	HTTPTimeout time.Duration
+}
+
+// RGB is the color of a single LED.
+type RGB struct {
+	R, G, B uint8
+}
+
+// Output streams beat effects to a WLED device.
+type Output struct {
+	config Config
+	conn   *net.UDPConn
+	http   *http.Client
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu          sync.Mutex
+	trigger     output.Trigger
+	triggeredAt time.Time
+	playing     bool
+	mediaPreset int // The preset chosen for the current media, or 0 if there isn't one.
+}
+
+// New starts streaming to the WLED device.
+func New(config Config) (*Output, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("WLED host is required")
+	}
+	if config.LEDs <= 0 {
+		return nil, fmt.Errorf("WLED device %s needs at least one LED", config.Host)
+	}
+	if config.FPS <= 0 {
+		config.FPS = DefaultFPS
+	}
+	if config.Port <= 0 {
+		config.Port = RealtimePort
+	}
+	if config.HTTPTimeout <= 0 {
+		config.HTTPTimeout = 5 * time.Second
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Output{
+		config: config,
+		conn:   conn,
+		http:   &http.Client{Timeout: config.HTTPTimeout},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	return o, nil
+}
+
+// Trigger starts the beat effect for the trigger.
+func (o *Output) Trigger(trigger output.Trigger) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.trigger = trigger
+	o.triggeredAt = time.Now()
+	o.playing = true
+}
+
+// Media chooses the preset for the media, and starts or stops streaming depending on whether it's playing.
+func (o *Output) Media(media models.Media, features models.MediaAudioFeatures, analysis models.MediaAudioAnalysis) {
+	o.mu.Lock()
+	o.mediaPreset = presetFor(o.config.Presets, features.Energy)
+	o.mu.Unlock()
+	o.setPlaying(media.IsPlaying)
+}
+
+// PlayState stops streaming while playback is stopped, so WLED goes back to its own effects.
+func (o *Output) PlayState(playing bool, position time.Duration) {
+	o.setPlaying(playing)
+}
+
+// setPlaying starts or stops streaming. When streaming stops, the media's preset is loaded, or the idle preset if the
+// media doesn't have one. Presets only show while nothing is being streamed, because realtime frames override them.
+func (o *Output) setPlaying(playing bool) {
+	o.mu.Lock()
+	o.playing = playing
+	preset := o.mediaPreset
+	o.mu.Unlock()
+
+	if preset == 0 {
+		preset = o.config.IdlePreset
+	}
+	if !playing && preset > 0 {
+		go o.loadPreset(preset)
+	}
+}
+
+// presetFor chooses the preset for media with the energy, from 0 to 1. Calmer media gets the earlier presets.
+func presetFor(presets []int, energy float64) int {
+	if len(presets) == 0 {
+		return 0
+	}
+	index := int(energy * float64(len(presets)))
+	if index >= len(presets) {
+		index = len(presets) - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	return presets[index]
+}
+
+// Close stops streaming. WLED goes back to its own effects after the realtime timeout.
+func (o *Output) Close() error {
+	close(o.stop)
+	<-o.done
+	return o.conn.Close()
+}
+
+// loadPreset tells WLED to switch to the preset, using the JSON API.
+func (o *Output) loadPreset(preset int) {
+	body, _ := json.Marshal(map[string]interface{}{"on": true, "ps": preset})
+	res, err := o.http.Post("http://"+o.config.Host+"/json/state", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to load WLED preset %d on %s: %v\n", preset, o.config.Host, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		log.Printf("Failed to load WLED preset %d on %s: %s\n", preset, o.config.Host, res.Status)
+	}
+}
+
+func (o *Output) run() {
+	defer close(o.done)
+	ticker := time.NewTicker(time.Second / time.Duration(o.config.FPS))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.mu.Lock()
+			trigger, elapsed, playing := o.trigger, time.Since(o.triggeredAt), o.playing
+			o.mu.Unlock()
+			if !playing {
+				continue
+			}
+			for _, packet := range EncodeFrame(Render(o.config.LEDs, trigger, elapsed)) {
+				if _, err := o.conn.Write(packet); err != nil {
+					log.Printf("Failed to send WLED frame to %s: %v\n", o.config.Host, err)
+					break
+				}
+			}
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Render computes the LED colors, elapsed into the trigger. A pulse of the trigger's color sweeps along the strip over
+// the trigger, changing direction every trigger.
+func Render(leds int, trigger output.Trigger, elapsed time.Duration) []RGB {
+	frame := make([]RGB, leds)
+	if trigger.Duration <= 0 || elapsed >= trigger.Duration {
+		return frame
+	}
+
+	r, g, b := trigger.Color.R, trigger.Color.G, trigger.Color.B
+
+	// The pulse is an eighth of the strip wide, and fades out towards its edges.
+	progress := float64(elapsed) / float64(trigger.Duration)
+	centre := progress * float64(leds-1)
+	if trigger.Number&1 != 0 {
+		centre = float64(leds-1) - centre
+	}
+	width := float64(leds) / 8
+	if width < 1 {
+		width = 1
+	}
+
+	for i := range frame {
+		distance := float64(i) - centre
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance >= width {
+			continue
+		}
+		intensity := (1 - distance/width) * trigger.Brightness
+		frame[i] = RGB{scale(r, intensity), scale(g, intensity), scale(b, intensity)}
+	}
+	return frame
+}
+
+// EncodeFrame builds the realtime UDP packets for the frame.
+func EncodeFrame(frame []RGB) [][]byte {
+	if len(frame) <= maxDRGBLEDs {
+		packet := make([]byte, 2, 2+len(frame)*3)
+		packet[0], packet[1] = protocolDRGB, realtimeTimeout
+		return [][]byte{appendRGB(packet, frame)}
+	}
+
+	// DNRGB packets each say which LED they start at.
+	var packets [][]byte
+	for start := 0; start < len(frame); start += maxDNRGBLEDs {
+		end := start + maxDNRGBLEDs
+		if end > len(frame) {
+			end = len(frame)
+		}
+		packet := make([]byte, 4, 4+(end-start)*3)
+		packet[0], packet[1] = protocolDNRGB, realtimeTimeout
+		binary.BigEndian.PutUint16(packet[2:], uint16(start))
+		packets = append(packets, appendRGB(packet, frame[start:end]))
+	}
+	return packets
+}
+
+func appendRGB(packet []byte, frame []RGB) []byte {
+	for _, led := range frame {
+		packet = append(packet, led.R, led.G, led.B)
+	}
+	return packet
+}
+
+func scale(value uint8, intensity float64) uint8 {
+	return uint8(float64(value)*intensity + 0.5)
+}
@@ -0,0 +1,237 @@
+package wled
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// stub pretends to be a WLED device, recording the realtime frames and JSON API requests it's sent.
+type stub struct {
+	frames   chan []byte
+	requests chan map[string]interface{}
+}
+
+// roundTripFunc lets a function stand in for the WLED JSON API.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// newStub starts an output streaming to a stub device, with the rest of the config.
+func newStub(t *testing.T, config Config) (*Output, *stub) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &stub{frames: make(chan []byte, 1024), requests: make(chan map[string]interface{}, 16)}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case s.frames <- append([]byte(nil), buf[:n]...):
+			default:
+			}
+		}
+	}()
+
+	config.Host = "127.0.0.1"
+	config.Port = conn.LocalAddr().(*net.UDPAddr).Port
+	config.FPS = 200
+	o, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+
+	o.http = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		body["url"] = r.URL.String()
+		s.requests <- body
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+	})}
+	return o, s
+}
+
+// waitForLit returns the next frame with a lit LED.
+func (s *stub) waitForLit(t *testing.T, header int) []byte {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case frame := <-s.frames:
+			for _, value := range frame[header:] {
+				if value != 0 {
+					return frame
+				}
+			}
+		case <-timeout:
+			t.Fatal("no lit frame received")
+		}
+	}
+}
+
+func TestStreamDRGB(t *testing.T) {
+	o, s := newStub(t, Config{LEDs: 30})
+	o.Trigger(output.Trigger{Number: 0, Duration: time.Second, Color: colors.Color{R: 0xFF}, Brightness: 1})
+
+	frame := s.waitForLit(t, 2)
+	if frame[0] != protocolDRGB || frame[1] != realtimeTimeout {
+		t.Errorf("header = % x, want DRGB with a %d second timeout", frame[:2], realtimeTimeout)
+	}
+	if len(frame) != 2+30*3 {
+		t.Fatalf("frame is %d bytes, want %d", len(frame), 2+30*3)
+	}
+	for i := 2; i < len(frame); i += 3 {
+		if frame[i+1] != 0 || frame[i+2] != 0 {
+			t.Fatalf("LED %d = % x, want only red", (i-2)/3, frame[i:i+3])
+		}
+	}
+}
+
+func TestStreamDNRGB(t *testing.T) {
+	o, s := newStub(t, Config{LEDs: 600})
+	o.Trigger(output.Trigger{Number: 0, Duration: time.Second, Color: colors.White, Brightness: 1})
+
+	// The strip is too long for DRGB, so it's sent in two chunks.
+	s.waitForLit(t, 4)
+	starts := map[uint16]int{}
+	for len(starts) < 2 {
+		frame := <-s.frames
+		if frame[0] != protocolDNRGB {
+			t.Fatalf("protocol = %d, want DNRGB", frame[0])
+		}
+		starts[binary.BigEndian.Uint16(frame[2:])] = (len(frame) - 4) / 3
+	}
+	if len(starts) != 2 || starts[0] != maxDNRGBLEDs || starts[maxDNRGBLEDs] != 600-maxDNRGBLEDs {
+		t.Errorf("chunks = %v, want %d LEDs from 0 and %d from %d", starts, maxDNRGBLEDs, 600-maxDNRGBLEDs, maxDNRGBLEDs)
+	}
+}
+
+func TestStopStreamingWhenIdle(t *testing.T) {
+	o, s := newStub(t, Config{LEDs: 30, IdlePreset: 3})
+	o.Trigger(output.Trigger{Number: 0, Duration: time.Second, Color: colors.White, Brightness: 1})
+	s.waitForLit(t, 2)
+
+	o.PlayState(false, 0)
+	s.expectPreset(t, 3)
+	s.expectNoFrames(t)
+}
+
+// expectPreset checks the next JSON API request loads the preset.
+func (s *stub) expectPreset(t *testing.T, preset int) {
+	t.Helper()
+	select {
+	case request := <-s.requests:
+		if request["url"] != "http://127.0.0.1/json/state" || request["ps"] != float64(preset) || request["on"] != true {
+			t.Errorf("request = %v, want preset %d loaded", request, preset)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("preset %d not loaded", preset)
+	}
+}
+
+// expectNoFrames checks nothing is streamed, once any frames already on their way have arrived.
+func (s *stub) expectNoFrames(t *testing.T) {
+	t.Helper()
+	time.Sleep(50 * time.Millisecond)
+	for len(s.frames) > 0 {
+		<-s.frames
+	}
+	select {
+	case <-s.frames:
+		t.Error("frame streamed while stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotStreamingUntilPlaying(t *testing.T) {
+	_, s := newStub(t, Config{LEDs: 30})
+	select {
+	case <-s.frames:
+		t.Error("frame streamed before playback started")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMediaStartsAndStopsStreaming(t *testing.T) {
+	o, s := newStub(t, Config{LEDs: 30, Presets: []int{1, 2, 3}})
+
+	o.Media(models.Media{IsPlaying: true}, models.MediaAudioFeatures{Energy: 0.9}, models.MediaAudioAnalysis{})
+	select {
+	case <-s.frames:
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing streamed for playing media")
+	}
+
+	// Paused media stops streaming so its preset shows.
+	o.Media(models.Media{IsPlaying: false}, models.MediaAudioFeatures{Energy: 0.9}, models.MediaAudioAnalysis{})
+	s.expectPreset(t, 3)
+	s.expectNoFrames(t)
+}
+
+func TestPresetsLoadWhenPlaybackStops(t *testing.T) {
+	o, s := newStub(t, Config{LEDs: 30, Presets: []int{5, 6}, IdlePreset: 9})
+
+	// Before any media, the idle preset is used.
+	o.PlayState(false, 0)
+	s.expectPreset(t, 9)
+
+	// Realtime frames hide presets, so nothing is loaded while the media plays.
+	o.Media(models.Media{IsPlaying: true}, models.MediaAudioFeatures{Energy: 0.2}, models.MediaAudioAnalysis{})
+	select {
+	case request := <-s.requests:
+		t.Errorf("request = %v while streaming, want none", request)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	o.PlayState(false, 0)
+	s.expectPreset(t, 5)
+	o.PlayState(true, 0)
+	o.Media(models.Media{IsPlaying: true}, models.MediaAudioFeatures{Energy: 0.8}, models.MediaAudioAnalysis{})
+	o.PlayState(false, 0)
+	s.expectPreset(t, 6)
+}
+
+func TestPresetFor(t *testing.T) {
+	presets := []int{10, 20, 30}
+	tests := []struct {
+		presets []int
+		energy  float64
+		want    int
+	}{
+		{presets, 0, 10},
+		{presets, 0.3, 10},
+		{presets, 0.34, 20},
+		{presets, 0.5, 20},
+		{presets, 0.9, 30},
+		{presets, 1, 30},
+		{presets, -0.1, 10},
+		{presets, 1.5, 30},
+		{[]int{7}, 0.5, 7},
+		{nil, 0.5, 0},
+	}
+	for _, test := range tests {
+		if got := presetFor(test.presets, test.energy); got != test.want {
+			t.Errorf("presetFor(%v, %v) = %d, want %d", test.presets, test.energy, got, test.want)
+		}
+	}
+}
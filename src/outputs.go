@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/tom-milner/LightBeatGateway/output"
+	"github.com/tom-milner/LightBeatGateway/output/dmx"
+	"github.com/tom-milner/LightBeatGateway/output/hue"
+	"github.com/tom-milner/LightBeatGateway/output/link"
+	"github.com/tom-milner/LightBeatGateway/output/midi"
+	"github.com/tom-milner/LightBeatGateway/output/osc"
+	"github.com/tom-milner/LightBeatGateway/output/wled"
+)
+
+// setupOutputs starts every output that has been configured.
+func setupOutputs() {
+	// DMX fixtures over Art-Net or sACN.
+	if patchFile := getOptionalEnv("DMX_PATCH_FILE", ""); patchFile != "" {
+		patch, err := dmx.LoadPatch(patchFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dmxOutput, err := dmx.New(patch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		output.Add("dmx", dmxOutput)
+	}
+
+	// WLED strips, given as a list of <host>/<number of LEDs>.
+	// WLED_PRESETS are loaded when playback stops, chosen by the energy of the track. WLED_IDLE_PRESET is loaded when
+	// there isn't a track preset.
+	if devices := getOptionalEnv("WLED_DEVICES", ""); devices != "" {
+		presets := parseIntList("WLED_PRESETS")
+		idlePreset, err := strconv.Atoi(getOptionalEnv("WLED_IDLE_PRESET", "0"))
+		if err != nil {
+			log.Fatal("WLED_IDLE_PRESET must be a number.")
+		}
+		for _, device := range strings.Split(devices, ",") {
+			parts := strings.Split(strings.TrimSpace(device), "/")
+			if len(parts) != 2 {
+				log.Fatal("WLED_DEVICES must be a list of <host>/<number of LEDs>.")
+			}
+			leds, err := strconv.Atoi(parts[1])
+			if err != nil {
+				log.Fatal("WLED_DEVICES must be a list of <host>/<number of LEDs>.")
+			}
+			wledOutput, err := wled.New(wled.Config{
+				Host:       parts[0],
+				LEDs:       leds,
+				Presets:    presets,
+				IdlePreset: idlePreset,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			output.Add("wled-"+parts[0], wledOutput)
+		}
+	}
+
+	// Smart bulbs in an entertainment group. Frames are sent as plain UDP, so real bridges need a DTLS proxy, and
+	// HUE_PLAIN_UDP must be true to say the bridge or proxy takes them.
+	if bridge := getOptionalEnv("HUE_BRIDGE", ""); bridge != "" {
+		streamPort, err := strconv.Atoi(getOptionalEnv("HUE_STREAM_PORT", strconv.Itoa(hue.DefaultStreamPort)))
+		if err != nil {
+			log.Fatal("HUE_STREAM_PORT must be a number.")
+		}
+		hueOutput, err := hue.New(hue.Config{
+			Bridge:     bridge,
+			Username:   getSecretEnv("HUE_USERNAME"),
+			Group:      getRequiredEnv("HUE_GROUP"),
+			StreamPort: streamPort,
+			PlainUDP:   getOptionalEnv("HUE_PLAIN_UDP", "false") == "true",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		output.Add("hue", hueOutput)
+	}
+
+	// VJ software speaking OSC.
+	oscPrefix := getOptionalEnv("OSC_PREFIX", osc.DefaultPrefix)
+	if targets := getOptionalEnv("OSC_TARGETS", ""); targets != "" {
+		oscOutput, err := osc.New(osc.Config{
+			Targets: strings.Split(targets, ","),
+			Prefix:  oscPrefix,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		output.Add("osc", oscOutput)
+	}
+	if address := getOptionalEnv("OSC_LISTEN", ""); address != "" {
+		if _, err := osc.Listen(address, handleOSCCommand(oscPrefix)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Drum machines and sequencers following the track over MIDI.
+	midiDevice, midiExportDir := getOptionalEnv("MIDI_DEVICE", ""), getOptionalEnv("MIDI_EXPORT_DIR", "")
+	if midiDevice != "" || midiExportDir != "" {
+		midiOutput, err := midi.New(midi.Config{
+			Device:    midiDevice,
+			ExportDir: midiExportDir,
+			Channel:   parseByte("MIDI_CHANNEL", midi.DefaultChannel),
+			BeatNote:  parseByte("MIDI_BEAT_NOTE", midi.DefaultBeatNote),
+			BarNote:   parseByte("MIDI_BAR_NOTE", midi.DefaultBarNote),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		output.Add("midi", midiOutput)
+	}
+
+	// DAWs and apps in an Ableton Link session.
+	if getOptionalEnv("LINK_ENABLED", "false") == "true" {
+		linkOutput, err := link.NewOutput(link.Config{
+			Address:   getOptionalEnv("LINK_ADDRESS", link.DefaultAddress),
+			Interface: getOptionalEnv("LINK_INTERFACE", ""),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		output.Add("link", linkOutput)
+	}
+}
+
+// parseByte parses the optional environment variable as a number from 0 to 255.
+func parseByte(key string, fallback byte) byte {
+	number, err := strconv.ParseUint(getOptionalEnv(key, strconv.Itoa(int(fallback))), 10, 8)
+	if err != nil {
+		log.Fatalf("%s must be a number from 0 to 255.", key)
+	}
+	return byte(number)
+}
+
+// parseIntList parses the optional environment variable as a comma separated list of numbers.
+func parseIntList(key string) []int {
+	var numbers []int
+	for _, item := range strings.Split(getOptionalEnv(key, ""), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		number, err := strconv.Atoi(item)
+		if err != nil {
+			log.Fatalf("%s must be a list of numbers.", key)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
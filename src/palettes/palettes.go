@@ -0,0 +1,129 @@
+// Package palettes derives the colors of a track's lightshow from its audio features. Strategies are pure, so a
+// track always gets the same palette.
+package palettes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// Strategy chooses the palette for a track.
+type Strategy interface {
+	// Palette returns the colors for a track with the features. It must only depend on the features.
+	Palette(features models.MediaAudioFeatures) []colors.Color
+}
+
+// StrategyFunc lets a function be used as a Strategy.
+type StrategyFunc func(features models.MediaAudioFeatures) []colors.Color
+
+// Palette calls f.
+func (f StrategyFunc) Palette(features models.MediaAudioFeatures) []colors.Color {
+	return f(features)
+}
+
+// DefaultStrategy is the strategy used if the config doesn't say.
+const DefaultStrategy = "mood"
+
+var strategies = map[string]Strategy{
+	"mood": StrategyFunc(Mood),
+	"key":  StrategyFunc(Key),
+}
+var strategiesMu sync.RWMutex
+
+// Register adds a strategy that can be chosen by name, replacing any with the same name.
+func Register(name string, strategy Strategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = strategy
+}
+
+// Names returns the name of every strategy, in alphabetical order.
+func Names() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	return sortedNames()
+}
+
+// sortedNames returns the name of every strategy, in alphabetical order. strategiesMu must be held.
+func sortedNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the named strategy.
+func Get(name string) (Strategy, error) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	strategy, exists := strategies[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown palette strategy %q, expected one of %v", name, sortedNames())
+	}
+	return strategy, nil
+}
+
+// Mood places the track on a mood wheel: sad tracks are blue, angry ones red and purple, and happy ones warm oranges
+// and yellows. More energetic tracks are brighter and more saturated, faster tracks spread further around the
+// wheel, more danceable tracks get more colors, and minor keys are cooler.
+func Mood(features models.MediaAudioFeatures) []colors.Color {
+	hue := 240 + 160*clamp(features.Valence)
+	if features.Key >= 0 && features.Mode == models.Minor {
+		hue -= 20
+	}
+	saturation := 0.4 + 0.6*clamp(features.Energy)
+	value := 0.5 + 0.5*clamp(features.Energy)
+	// The range of hues in the palette, in degrees.
+	spread := 30 + 60*clamp((features.Tempo-60)/120)
+
+	n := paletteSize(features)
+	palette := make([]colors.Color, n)
+	for i := range palette {
+		// Spread the colors evenly either side of the track's hue.
+		offset := float64(i)/float64(n-1) - 0.5
+		palette[i] = colors.HSV(hue+offset*spread, saturation, value)
+	}
+	return palette
+}
+
+// Key colors the track by its key on the circle of fifths, so related keys get neighbouring hues. The palette is the
+// key with its closest keys, the dominant and subdominant first. Major keys are bright and saturated, and minor keys
+// are darker and softer. Tracks with no key detected fall back to Mood.
+func Key(features models.MediaAudioFeatures) []colors.Color {
+	if features.Key < 0 || features.Key > 11 {
+		return Mood(features)
+	}
+	hue := float64(features.Key*7%12) * 30
+	saturation, value := 0.9, 1.0
+	if features.Mode == models.Minor {
+		saturation, value = 0.7, 0.7
+	}
+	value *= 0.6 + 0.4*clamp(features.Energy)
+
+	palette := make([]colors.Color, paletteSize(features))
+	for i := range palette {
+		// 0, +1, -1, +2, -2... fifths from the key.
+		fifths := (i + 1) / 2
+		if i%2 == 0 {
+			fifths = -fifths
+		}
+		palette[i] = colors.HSV(hue+float64(fifths)*30, saturation, value)
+	}
+	return palette
+}
+
+// paletteSize returns how many colors the track's palette has, from 2 to 6, more for more danceable tracks.
+func paletteSize(features models.MediaAudioFeatures) int {
+	return 2 + int(math.Round(4*clamp(features.Danceability)))
+}
+
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
@@ -0,0 +1,148 @@
+package palettes
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+func TestMood(t *testing.T) {
+	tests := []struct {
+		name     string
+		features models.MediaAudioFeatures
+		want     []colors.Color
+	}{
+		{
+			name:     "sad, energetic and slow",
+			features: models.MediaAudioFeatures{Valence: 0, Energy: 1, Tempo: 60, Key: 0, Mode: models.Major},
+			want:     []colors.Color{colors.HSV(225, 1, 1), colors.HSV(255, 1, 1)},
+		},
+		{
+			name:     "happy, calm and fast",
+			features: models.MediaAudioFeatures{Valence: 1, Energy: 0, Tempo: 180, Key: 0, Mode: models.Major},
+			want:     []colors.Color{colors.HSV(355, 0.4, 0.5), colors.HSV(445, 0.4, 0.5)},
+		},
+		{
+			name:     "minor keys are cooler",
+			features: models.MediaAudioFeatures{Valence: 0.5, Energy: 0.5, Tempo: 120, Key: 9, Mode: models.Minor},
+			want:     []colors.Color{colors.HSV(300-30, 0.7, 0.75), colors.HSV(300+30, 0.7, 0.75)},
+		},
+		{
+			name:     "no key isn't minor",
+			features: models.MediaAudioFeatures{Valence: 0.5, Energy: 0.5, Tempo: 120, Key: -1, Mode: models.Minor},
+			want:     []colors.Color{colors.HSV(320-30, 0.7, 0.75), colors.HSV(320+30, 0.7, 0.75)},
+		},
+		{
+			name:     "danceable tracks get more colors",
+			features: models.MediaAudioFeatures{Valence: 0, Energy: 1, Tempo: 60, Danceability: 1, Key: 0, Mode: models.Major},
+			want: []colors.Color{
+				colors.HSV(225, 1, 1), colors.HSV(231, 1, 1), colors.HSV(237, 1, 1),
+				colors.HSV(243, 1, 1), colors.HSV(249, 1, 1), colors.HSV(255, 1, 1),
+			},
+		},
+		{
+			name:     "features out of range are clamped",
+			features: models.MediaAudioFeatures{Valence: -1, Energy: 2, Tempo: 0, Danceability: -1, Key: 0, Mode: models.Major},
+			want:     []colors.Color{colors.HSV(225, 1, 1), colors.HSV(255, 1, 1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Mood(test.features); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Mood = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		features models.MediaAudioFeatures
+		want     []colors.Color
+	}{
+		{
+			name:     "C major",
+			features: models.MediaAudioFeatures{Key: 0, Mode: models.Major, Energy: 1},
+			want:     []colors.Color{colors.HSV(0, 0.9, 1), colors.HSV(30, 0.9, 1)},
+		},
+		{
+			name:     "D major is two fifths round from C",
+			features: models.MediaAudioFeatures{Key: 2, Mode: models.Major, Energy: 1},
+			want:     []colors.Color{colors.HSV(60, 0.9, 1), colors.HSV(90, 0.9, 1)},
+		},
+		{
+			name:     "minor keys are darker and softer",
+			features: models.MediaAudioFeatures{Key: 7, Mode: models.Minor, Energy: 0},
+			want:     []colors.Color{colors.HSV(30, 0.7, 0.42), colors.HSV(60, 0.7, 0.42)},
+		},
+		{
+			name:     "the closest keys come first",
+			features: models.MediaAudioFeatures{Key: 0, Mode: models.Major, Energy: 1, Danceability: 1},
+			want: []colors.Color{
+				colors.HSV(0, 0.9, 1), colors.HSV(30, 0.9, 1), colors.HSV(-30, 0.9, 1),
+				colors.HSV(60, 0.9, 1), colors.HSV(-60, 0.9, 1), colors.HSV(90, 0.9, 1),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Key(test.features); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Key = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// Without a key, the mood decides.
+	for _, key := range []int{-1, 12} {
+		features := models.MediaAudioFeatures{Key: key, Valence: 0.3, Energy: 0.6, Tempo: 100}
+		if got, want := Key(features), Mood(features); !reflect.DeepEqual(got, want) {
+			t.Errorf("Key with key %d = %v, want the mood palette %v", key, got, want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	gray := StrategyFunc(func(models.MediaAudioFeatures) []colors.Color {
+		return []colors.Color{{R: 128, G: 128, B: 128}}
+	})
+
+	// Strategies can be registered while others are being looked up.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		name := fmt.Sprint("test-", i)
+		go func() {
+			defer wg.Done()
+			Register(name, gray)
+		}()
+		go func() {
+			defer wg.Done()
+			Get(DefaultStrategy)
+			Names()
+		}()
+	}
+	wg.Wait()
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		defer strategiesMu.Unlock()
+		for i := 0; i < 10; i++ {
+			delete(strategies, fmt.Sprint("test-", i))
+		}
+	})
+
+	strategy, err := Get("test-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strategy.Palette(models.MediaAudioFeatures{}); len(got) != 1 {
+		t.Errorf("palette = %v, want the registered strategy's", got)
+	}
+	if _, err := Get("missing"); err == nil {
+		t.Error("no error for a missing strategy")
+	}
+}
@@ -0,0 +1,102 @@
+package spotify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+// cacheDir is where the analysis and features of tracks are kept once they've been fetched, or empty to not keep
+// them.
+var cacheDir string
+
+// SetCacheDir keeps the analysis and features of every track fetched in the directory, and uses them instead of
+// fetching them again. Effects can be rendered from the cached tracks without Spotify.
+func SetCacheDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cacheDir = dir
+	return nil
+}
+
+// AnalysisFile returns the file the track's analysis is cached in, in the directory.
+func AnalysisFile(dir string, trackID string) string {
+	return filepath.Join(dir, trackID+".analysis.json")
+}
+
+// FeaturesFile returns the file the track's features are cached in, in the directory.
+func FeaturesFile(dir string, trackID string) string {
+	return filepath.Join(dir, trackID+".features.json")
+}
+
+// LoadAnalysis reads a track's analysis from a file.
+func LoadAnalysis(file string) (models.MediaAudioAnalysis, error) {
+	var analysis models.MediaAudioAnalysis
+	err := loadJSON(file, &analysis)
+	return analysis, err
+}
+
+// LoadFeatures reads a track's features from a file.
+func LoadFeatures(file string) (models.MediaAudioFeatures, error) {
+	features := models.MediaAudioFeatures{Key: -1}
+	err := loadJSON(file, &features)
+	return features, err
+}
+
+func loadJSON(file string, v interface{}) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// cached reads the file from the cache into v, returning whether it was there.
+func cached(file func(string, string) string, trackID string, v interface{}) bool {
+	if cacheDir == "" {
+		return false
+	}
+	return loadJSON(file(cacheDir, trackID), v) == nil
+}
+
+// cache writes v to the file in the cache. Failing to cache is only logged, as the track can be fetched again.
+func cache(file func(string, string) string, trackID string, v interface{}) {
+	if cacheDir == "" {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = writeFile(file(cacheDir, trackID), b)
+	}
+	if err != nil {
+		log.Println("Failed to cache track:", err)
+	}
+}
+
+// writeFile writes to a temporary file, then renames it into place, so a crash mid-write never leaves a truncated
+// file that's read back as a corrupt track.
+func writeFile(file string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
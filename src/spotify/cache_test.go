@@ -0,0 +1,47 @@
+package spotify
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := SetCacheDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cacheDir = "" })
+
+	var analysis models.MediaAudioAnalysis
+	if cached(AnalysisFile, "track", &analysis) {
+		t.Fatal("track cached before it was fetched")
+	}
+
+	want := models.MediaAudioAnalysis{Beats: []models.TimeInterval{{Start: 0.5, Duration: 0.5}}}
+	want.Track.Duration = 180
+	cache(AnalysisFile, "track", want)
+	// Caching again replaces the file, rather than writing over it.
+	want.Track.Duration = 200
+	cache(AnalysisFile, "track", want)
+
+	if !cached(AnalysisFile, "track", &analysis) {
+		t.Fatal("track not cached")
+	}
+	if analysis.Track.Duration != 200 || len(analysis.Beats) != 1 || analysis.Beats[0] != want.Beats[0] {
+		t.Errorf("cached analysis = %+v, want %+v", analysis, want)
+	}
+
+	// Only the cached file is left, with no temporary files beside it.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "track.analysis.json" {
+		t.Errorf("cache holds %d files, want only the analysis", len(files))
+	}
+	if mode := files[0].Mode().Perm(); mode != 0644 {
+		t.Errorf("cached file mode = %v, want 0644", mode)
+	}
+}
@@ -22,15 +22,27 @@ type MediaAudioFeatures struct {
 	Loudness         float64 `json:"loudness"`
 	Speechiness      float64 `json:"speechiness"`
 	Valence          float64 `json:"valence"`
-	Tempo            float64 `json:tempo`
+	Tempo            float64 `json:"tempo"`
+	Key              int     `json:"key"`  // The pitch class of the key, from 0 (C) to 11 (B), or -1 if none was detected.
+	Mode             Mode    `json:"mode"` // Whether the key is major or minor.
 }
 
+// Mode is the modality of a track's key.
+type Mode int
+
+const (
+	Minor Mode = 0
+	Major Mode = 1
+)
+
 // MediaAudioAnalysis is the model to hold all the track analysis data.
 type MediaAudioAnalysis struct {
-	Beats  []TimeInterval `json:"beats"`  // All the beats in track.
-	Bars   []TimeInterval `json:"bars"`   // All the bars in the track.
-	Tatums []TimeInterval `json:"tatums"` //All the tatums in the track.
-	Track  struct {
+	Beats    []TimeInterval `json:"beats"`    // All the beats in track.
+	Bars     []TimeInterval `json:"bars"`     // All the bars in the track.
+	Tatums   []TimeInterval `json:"tatums"`   //All the tatums in the track.
+	Sections []TimeInterval `json:"sections"` // All the sections (verse, chorus etc.) in the track.
+	Segments []Segment      `json:"segments"` // All the segments (short, consistent sounds) in the track.
+	Track    struct {
 		Duration float64 `json:"duration"` // The duration of the track.
 	} `json:"track"`
 }
@@ -46,7 +58,50 @@ type TimeInterval struct {
 	Duration float64 `json:"duration"` // The duration of the interval.
 }
 
+// Segment is a short part of the track with a consistent sound, such as a single note or drum hit.
+type Segment struct {
+	Start           float64 `json:"start"`             // The start of the segment.
+	Duration        float64 `json:"duration"`          // The duration of the segment.
+	LoudnessStart   float64 `json:"loudness_start"`    // The loudness at the start of the segment, in dB.
+	LoudnessMax     float64 `json:"loudness_max"`      // The peak loudness of the segment, in dB.
+	LoudnessMaxTime float64 `json:"loudness_max_time"` // How far into the segment the peak is.
+}
+
+// Trigger is sent to the edge devices every time a trigger happens.
 type Trigger struct {
-	Number   int `json:"number"`
-	Duration int `json:"duration"`
+	Number   int `json:"number"`   // The index of the trigger in the track.
+	Duration int `json:"duration"` // How long the trigger lasts, in milliseconds.
+}
+
+// Schedule is sent to the edge devices so they can time the upcoming triggers themselves.
+type Schedule struct {
+	Triggers []ScheduledTrigger `json:"triggers"`
+}
+
+// ScheduledTrigger is a single upcoming trigger.
+type ScheduledTrigger struct {
+	Number   int `json:"number"`   // The index of the trigger in the track.
+	At       int `json:"at"`       // How long after the schedule was sent the trigger happens, in milliseconds.
+	Duration int `json:"duration"` // How long the trigger lasts, in milliseconds.
+}
+
+// TriggerType is the part of the track used to trigger the lights.
+type TriggerType string
+
+const (
+	Beat TriggerType = "beat"
+	Bar  TriggerType = "bar"
+)
+
+// TriggerTypes holds every supported trigger type.
+var TriggerTypes = []TriggerType{Beat, Bar}
+
+// Valid returns whether the trigger type is supported.
+func (t TriggerType) Valid() bool {
+	for _, triggerType := range TriggerTypes {
+		if t == triggerType {
+			return true
+		}
+	}
+	return false
 }
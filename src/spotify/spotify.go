@@ -75,6 +75,9 @@ func makeSpotifyRequest(client *http.Client, req *http.Request) (*http.Response,
 func GetMediaAudioFeatures(trackID string) (models.MediaAudioFeatures, error) {
 
 	var audioFeatures models.MediaAudioFeatures
+	if cached(FeaturesFile, trackID, &audioFeatures) {
+		return audioFeatures, nil
+	}
 
 	client, req, err := buildAPIRequest("GET", urls.MediaAudioFeatures+"/"+trackID, nil)
 
@@ -100,6 +103,9 @@ func GetMediaAudioFeatures(trackID string) (models.MediaAudioFeatures, error) {
 
 	// Decode the data.
 	err = json.NewDecoder(res.Body).Decode(&audioFeatures)
+	if err == nil {
+		cache(FeaturesFile, trackID, audioFeatures)
+	}
 	return audioFeatures, err
 }
 
@@ -107,6 +113,9 @@ func GetMediaAudioFeatures(trackID string) (models.MediaAudioFeatures, error) {
 func GetMediaAudioAnalysis(trackID string) (models.MediaAudioAnalysis, error) {
 
 	var trackAn models.MediaAudioAnalysis
+	if cached(AnalysisFile, trackID, &trackAn) {
+		return trackAn, nil
+	}
 
 	client, req, err := buildAPIRequest("GET", urls.MediaAudioAnalysis+"/"+trackID, nil)
 	if err != nil {
@@ -131,6 +140,9 @@ func GetMediaAudioAnalysis(trackID string) (models.MediaAudioAnalysis, error) {
 
 	// Decode the data.
 	err = json.NewDecoder(res.Body).Decode(&trackAn)
+	if err == nil {
+		cache(AnalysisFile, trackID, trackAn)
+	}
 	return trackAn, err
 }
 
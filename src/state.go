@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/tom-milner/LightBeatGateway/edge/messages"
+	"github.com/tom-milner/LightBeatGateway/hardware"
+	"github.com/tom-milner/LightBeatGateway/palettes"
+	"github.com/tom-milner/LightBeatGateway/spotify/models"
+	"github.com/tom-milner/LightBeatGateway/utils/colors"
+)
+
+// gatewayState holds what's currently playing, and the settings of the lights that can be changed by commands.
+type gatewayState struct {
+	mu         sync.RWMutex
+	trigger    models.TriggerType
+	media      models.Media
+	brightness float64
+	palette    []colors.Color
+	strategy   string // The name of the palette strategy, or empty if the palette was set by hand.
+	features   *models.MediaAudioFeatures
+	paused     bool
+}
+
+var state = &gatewayState{
+	trigger:    models.Beat,
+	brightness: 1,
+	palette:    []colors.Color{colors.MustParse(colors.Red)},
+}
+
+func (s *gatewayState) TriggerType() models.TriggerType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.trigger
+}
+
+func (s *gatewayState) SetTriggerType(trigger models.TriggerType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trigger = trigger
+}
+
+func (s *gatewayState) SetMedia(media models.Media) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.media = media
+}
+
+func (s *gatewayState) Brightness() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.brightness
+}
+
+func (s *gatewayState) SetBrightness(brightness float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.brightness = brightness
+}
+
+// Color returns the palette color to use for the given trigger.
+func (s *gatewayState) Color(triggerNum int) colors.Color {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.palette[triggerNum%len(s.palette)]
+}
+
+// SetPalette sets the palette by hand, so it's kept when the media changes.
+func (s *gatewayState) SetPalette(palette []colors.Color) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.palette = append([]colors.Color(nil), palette...)
+	s.strategy = ""
+}
+
+// SetPaletteStrategy sets the strategy that chooses the palette for each track, and applies it to the current one.
+func (s *gatewayState) SetPaletteStrategy(name string) error {
+	if _, err := palettes.Get(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strategy = name
+	s.applyStrategy()
+	return nil
+}
+
+// SetFeatures sets the audio features of the current media, choosing its palette if there's a strategy.
+func (s *gatewayState) SetFeatures(features models.MediaAudioFeatures) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.features = &features
+	s.applyStrategy()
+}
+
+// applyStrategy chooses the palette for the current media with the strategy. s.mu must be held.
+func (s *gatewayState) applyStrategy() {
+	if s.strategy == "" || s.features == nil {
+		return
+	}
+	strategy, err := palettes.Get(s.strategy)
+	if err != nil {
+		return
+	}
+	if palette := strategy.Palette(*s.features); len(palette) > 0 {
+		s.palette = palette
+	}
+}
+
+func (s *gatewayState) Paused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paused
+}
+
+func (s *gatewayState) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+// Status describes the state for the get-status command.
+func (s *gatewayState) Status() messages.Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return messages.Status{
+		Trigger:    s.trigger,
+		Playing:    s.media.IsPlaying,
+		MediaID:    s.media.Item.ID,
+		MediaName:  s.media.Item.Name,
+		Brightness: s.brightness,
+		Palette:    hexCodes(s.palette),
+		Strategy:   s.strategy,
+		Paused:     s.paused,
+		Effects:    hardware.Effects(),
+	}
+}
+
+// hexCodes returns the hex color code of each color.
+func hexCodes(palette []colors.Color) []string {
+	codes := make([]string, len(palette))
+	for i, color := range palette {
+		codes[i] = color.Hex()
+	}
+	return codes
+}
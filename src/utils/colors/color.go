@@ -0,0 +1,195 @@
+package colors
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Color is a 24 bit RGB color. It's encoded as a hex color code, e.g. FF0000, in JSON.
+type Color struct {
+	R, G, B uint8
+}
+
+// Some colors that are handy for effects.
+var (
+	Black = Color{0, 0, 0}
+	White = Color{255, 255, 255}
+)
+
+// Parse converts a hex color code, such as FF0000 or #FF0000, to a color.
+func Parse(code string) (Color, error) {
+	hex := strings.TrimPrefix(code, "#")
+	if !IsHex(hex) {
+		return Color{}, fmt.Errorf("invalid hex color: %q", code)
+	}
+	r, g, b, err := HexToRGB(hex)
+	if err != nil {
+		return Color{}, err
+	}
+	return Color{r, g, b}, nil
+}
+
+// MustParse is like Parse, but panics if the code is invalid. It's meant for colors written in the code.
+func MustParse(code string) Color {
+	c, err := Parse(code)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Hex returns the hex color code of the color, e.g. FF0000.
+func (c Color) Hex() string {
+	return fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B)
+}
+
+func (c Color) String() string {
+	return c.Hex()
+}
+
+// MarshalText encodes the color as its hex color code.
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.Hex()), nil
+}
+
+// UnmarshalText decodes a hex color code.
+func (c *Color) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// Scale returns the color at the intensity, from 0 to 1.
+func (c Color) Scale(intensity float64) Color {
+	if intensity <= 0 {
+		return Black
+	}
+	if intensity >= 1 {
+		return c
+	}
+	return Color{scale(c.R, intensity), scale(c.G, intensity), scale(c.B, intensity)}
+}
+
+// Add returns the sum of the colors, with each channel capped at 255.
+func (c Color) Add(o Color) Color {
+	return Color{addChannel(c.R, o.R), addChannel(c.G, o.G), addChannel(c.B, o.B)}
+}
+
+// Max returns the brightest of each channel of the colors.
+func (c Color) Max(o Color) Color {
+	return Color{maxChannel(c.R, o.R), maxChannel(c.G, o.G), maxChannel(c.B, o.B)}
+}
+
+// Blend mixes the colors linearly, t of the way from a to b, where t is from 0 to 1.
+func Blend(a, b Color, t float64) Color {
+	t = clamp(t)
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return Color{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B)}
+}
+
+// HSV creates a color from a hue in degrees, which wraps around every 360, and a saturation and value from 0 to 1.
+func HSV(hue, saturation, value float64) Color {
+	value = clamp(value)
+	chroma := value * clamp(saturation)
+	return fromHueChroma(hue, chroma, value-chroma)
+}
+
+// HSV returns the hue in degrees from 0 to 360, and the saturation and value from 0 to 1, of the color.
+func (c Color) HSV() (hue, saturation, value float64) {
+	hue, max, min := c.hue()
+	if max > 0 {
+		saturation = (max - min) / max
+	}
+	return hue, saturation, max
+}
+
+// HSL creates a color from a hue in degrees, which wraps around every 360, and a saturation and lightness from 0
+// to 1.
+func HSL(hue, saturation, lightness float64) Color {
+	lightness = clamp(lightness)
+	chroma := (1 - math.Abs(2*lightness-1)) * clamp(saturation)
+	return fromHueChroma(hue, chroma, lightness-chroma/2)
+}
+
+// HSL returns the hue in degrees from 0 to 360, and the saturation and lightness from 0 to 1, of the color.
+func (c Color) HSL() (hue, saturation, lightness float64) {
+	hue, max, min := c.hue()
+	lightness = (max + min) / 2
+	if max != min {
+		saturation = (max - min) / (1 - math.Abs(2*lightness-1))
+	}
+	return hue, saturation, lightness
+}
+
+// hue returns the hue in degrees of the color, and its largest and smallest channels from 0 to 1.
+func (c Color) hue() (hue, max, min float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	max = math.Max(r, math.Max(g, b))
+	min = math.Min(r, math.Min(g, b))
+	chroma := max - min
+	switch {
+	case chroma == 0:
+		hue = 0
+	case max == r:
+		hue = 60 * math.Mod((g-b)/chroma+6, 6)
+	case max == g:
+		hue = 60 * ((b-r)/chroma + 2)
+	default:
+		hue = 60 * ((r-g)/chroma + 4)
+	}
+	return hue, max, min
+}
+
+// fromHueChroma creates a color from its hue in degrees and chroma, plus m added to every channel.
+func fromHueChroma(hue, chroma, m float64) Color {
+	hue = math.Mod(hue, 360)
+	if hue < 0 {
+		hue += 360
+	}
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	var r, g, b float64
+	switch int(hue / 60) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	channel := func(v float64) uint8 { return uint8(math.Round(clamp(v+m) * 255)) }
+	return Color{channel(r), channel(g), channel(b)}
+}
+
+func scale(value uint8, intensity float64) uint8 {
+	return uint8(float64(value)*intensity + 0.5)
+}
+
+func addChannel(a, b uint8) uint8 {
+	if sum := int(a) + int(b); sum < 255 {
+		return uint8(sum)
+	}
+	return 255
+}
+
+func maxChannel(a, b uint8) uint8 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
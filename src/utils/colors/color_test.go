@@ -0,0 +1,225 @@
+package colors
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		code  string
+		want  Color
+		valid bool
+	}{
+		{"FF0000", Color{255, 0, 0}, true},
+		{"00ff7f", Color{0, 255, 127}, true},
+		{"#0000FF", Color{0, 0, 255}, true},
+		{"#abcdef", Color{0xAB, 0xCD, 0xEF}, true},
+		// Red used to be defined with an extra digit.
+		{"FF00000", Color{}, false},
+		{"#FF00000", Color{}, false},
+		{"F00", Color{}, false},
+		{"#F00", Color{}, false},
+		{"", Color{}, false},
+		{"#", Color{}, false},
+		{"##FF0000", Color{}, false},
+		{"GG0000", Color{}, false},
+		{"FF 000", Color{}, false},
+		{"-FFFFF", Color{}, false},
+		{"0xFF00", Color{}, false},
+	}
+	for _, test := range tests {
+		c, err := Parse(test.code)
+		if (err == nil) != test.valid {
+			t.Errorf("Parse(%q) error = %v, want valid %v", test.code, err, test.valid)
+			continue
+		}
+		if c != test.want {
+			t.Errorf("Parse(%q) = %v, want %v", test.code, c, test.want)
+		}
+	}
+}
+
+func TestIsHex(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"FF0000", true},
+		{"c0ffee", true},
+		{Red, true},
+		{Green, true},
+		{Blue, true},
+		{"FF00000", false},
+		{"#FF0000", false},
+		{"F00", false},
+		{"ocean", false},
+		{"FF000G", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := IsHex(test.code); got != test.want {
+			t.Errorf("IsHex(%q) = %v, want %v", test.code, got, test.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal([]Color{{255, 128, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `["FF8000"]` {
+		t.Errorf("encoded %s, want hex codes", b)
+	}
+	var decoded []Color
+	if err := json.Unmarshal([]byte(`["#ff8000"]`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 1 || decoded[0] != (Color{255, 128, 0}) {
+		t.Errorf("decoded %v", decoded)
+	}
+	if err := json.Unmarshal([]byte(`["FF00000"]`), &decoded); err == nil {
+		t.Error("decoded an invalid hex code")
+	}
+}
+
+func TestHSV(t *testing.T) {
+	tests := []struct {
+		hue, saturation, value float64
+		want                   Color
+	}{
+		{0, 1, 1, Color{255, 0, 0}},
+		{60, 1, 1, Color{255, 255, 0}},
+		{120, 1, 1, Color{0, 255, 0}},
+		{240, 1, 1, Color{0, 0, 255}},
+		{359, 1, 1, Color{255, 0, 4}},
+		// Hues wrap around.
+		{360, 1, 1, Color{255, 0, 0}},
+		{420, 1, 1, Color{255, 255, 0}},
+		{-120, 1, 1, Color{0, 0, 255}},
+		{0, 0, 1, White},
+		{200, 1, 0, Black},
+		{0, 1, 0.5, Color{128, 0, 0}},
+		// Saturation and value are clamped.
+		{0, 2, 2, Color{255, 0, 0}},
+	}
+	for _, test := range tests {
+		c := HSV(test.hue, test.saturation, test.value)
+		if c != test.want {
+			t.Errorf("HSV(%v, %v, %v) = %v, want %v", test.hue, test.saturation, test.value, c, test.want)
+		}
+		// Converting back gives the same color.
+		if back := HSV(c.HSV()); back != c {
+			h, s, v := c.HSV()
+			t.Errorf("%v -> HSV(%v, %v, %v) -> %v", c, h, s, v, back)
+		}
+	}
+}
+
+func TestHSVHues(t *testing.T) {
+	tests := []struct {
+		c   Color
+		hue float64
+	}{
+		{Color{255, 0, 0}, 0},
+		{Color{255, 255, 0}, 60},
+		{Color{255, 0, 4}, 359},
+		{White, 0},
+	}
+	for _, test := range tests {
+		// Colors only hold 8 bits a channel, so the hue comes back to within a degree.
+		if hue, _, _ := test.c.HSV(); math.Abs(hue-test.hue) > 1 || hue >= 360 {
+			t.Errorf("%v hue = %v, want %v", test.c, hue, test.hue)
+		}
+	}
+}
+
+func TestHSL(t *testing.T) {
+	tests := []struct {
+		hue, saturation, lightness float64
+		want                       Color
+	}{
+		{0, 1, 0.5, Color{255, 0, 0}},
+		{60, 1, 0.5, Color{255, 255, 0}},
+		{359, 1, 0.5, Color{255, 0, 4}},
+		{360, 1, 0.5, Color{255, 0, 0}},
+		{0, 1, 1, White},
+		{0, 1, 0, Black},
+		{0, 0, 0.5, Color{128, 128, 128}},
+		{240, 1, 0.25, Color{0, 0, 128}},
+	}
+	for _, test := range tests {
+		c := HSL(test.hue, test.saturation, test.lightness)
+		if c != test.want {
+			t.Errorf("HSL(%v, %v, %v) = %v, want %v", test.hue, test.saturation, test.lightness, c, test.want)
+		}
+		if back := HSL(c.HSL()); back != c {
+			h, s, l := c.HSL()
+			t.Errorf("%v -> HSL(%v, %v, %v) -> %v", c, h, s, l, back)
+		}
+	}
+}
+
+func TestBlend(t *testing.T) {
+	a, b := Color{10, 100, 255}, Color{210, 0, 55}
+	tests := []struct {
+		t    float64
+		want Color
+	}{
+		{0, a},
+		{1, b},
+		{0.5, Color{110, 50, 155}},
+		{0.25, Color{60, 75, 205}},
+		// Outside 0 to 1 is clamped to the ends.
+		{-1, a},
+		{2, b},
+	}
+	for _, test := range tests {
+		if c := Blend(a, b, test.t); c != test.want {
+			t.Errorf("Blend(%v, %v, %v) = %v, want %v", a, b, test.t, c, test.want)
+		}
+	}
+}
+
+func TestScaleAddMax(t *testing.T) {
+	c := Color{200, 100, 0}
+	if got := c.Scale(0.5); got != (Color{100, 50, 0}) {
+		t.Errorf("Scale(0.5) = %v", got)
+	}
+	if got := c.Scale(-1); got != Black {
+		t.Errorf("Scale(-1) = %v, want black", got)
+	}
+	if got := c.Scale(2); got != c {
+		t.Errorf("Scale(2) = %v, want the color", got)
+	}
+	if got := c.Add(Color{100, 100, 100}); got != (Color{255, 200, 100}) {
+		t.Errorf("Add = %v, want channels capped at 255", got)
+	}
+	if got := c.Max(Color{100, 150, 50}); got != (Color{200, 150, 50}) {
+		t.Errorf("Max = %v", got)
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	for _, name := range PaletteNames() {
+		palette, err := Palette(name)
+		if err != nil || len(palette) == 0 {
+			t.Errorf("palette %s = %v, %v", name, palette, err)
+		}
+	}
+	if primary, _ := Palette("primary"); primary[0] != (Color{255, 0, 0}) {
+		t.Errorf("primary palette starts with %v, want red", primary[0])
+	}
+
+	// Changing a palette doesn't change the named one.
+	ocean, _ := Palette("ocean")
+	ocean[0] = White
+	if again, _ := Palette("ocean"); again[0] == White {
+		t.Error("changing a copy changed the named palette")
+	}
+	if _, err := Palette("unknown"); err == nil {
+		t.Error("found an unknown palette")
+	}
+}
@@ -1,9 +1,40 @@
 package colors
 
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
 const (
-	Red string = "FF00000"
-	Green   string = "00FF00"
-	Blue   string = "0000FF"
+	Red   string = "FF0000"
+	Green string = "00FF00"
+	Blue  string = "0000FF"
 )
 
-var List [3]string = [3]string{Red,Green,Blue}
+var List [3]string = [3]string{Red, Green, Blue}
+
+// IsHex returns whether the code is a 6-digit hex color code, such as FF0000.
+func IsHex(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// HexToRGB converts the first 6 digits of a hex color code to its red, green and blue components.
+func HexToRGB(code string) (uint8, uint8, uint8, error) {
+	if len(code) < 6 {
+		return 0, 0, 0, errors.New("hex color code too short: " + code)
+	}
+	rgb, err := strconv.ParseUint(code[:6], 16, 32)
+	if err != nil {
+		return 0, 0, 0, errors.New("invalid hex color code: " + code)
+	}
+	return uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), nil
+}
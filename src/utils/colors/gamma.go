@@ -0,0 +1,22 @@
+package colors
+
+import "math"
+
+// GammaTable corrects colors for how LEDs look to the eye. LEDs are linear, so without correction dim colors look far
+// too bright and blends look washed out.
+type GammaTable [256]uint8
+
+// NewGammaTable creates the table for the gamma. A gamma of 1 leaves colors unchanged, and about 2.2 to 2.8 suits most
+// LEDs.
+func NewGammaTable(gamma float64) *GammaTable {
+	var t GammaTable
+	for i := range t {
+		t[i] = uint8(math.Round(math.Pow(float64(i)/255, gamma) * 255))
+	}
+	return &t
+}
+
+// Correct returns the color with each channel corrected.
+func (t *GammaTable) Correct(c Color) Color {
+	return Color{t[c.R], t[c.G], t[c.B]}
+}
@@ -0,0 +1,36 @@
+package colors
+
+import "testing"
+
+func TestGammaTable(t *testing.T) {
+	tests := []struct {
+		gamma float64
+		in    Color
+		want  Color
+	}{
+		// Off and full are the same whatever the gamma.
+		{2.2, Black, Black},
+		{2.2, White, White},
+		{2.8, Color{0, 255, 0}, Color{0, 255, 0}},
+		{1, Black, Black},
+		{1, White, White},
+		// A gamma of 1 changes nothing.
+		{1, Color{1, 128, 254}, Color{1, 128, 254}},
+		// Higher gammas dim the middle.
+		{2.2, Color{128, 64, 1}, Color{56, 12, 0}},
+	}
+	for _, test := range tests {
+		if got := NewGammaTable(test.gamma).Correct(test.in); got != test.want {
+			t.Errorf("gamma %v: Correct(%v) = %v, want %v", test.gamma, test.in, got, test.want)
+		}
+	}
+}
+
+func TestGammaTableRises(t *testing.T) {
+	table := NewGammaTable(2.5)
+	for i := 1; i < len(table); i++ {
+		if table[i] < table[i-1] {
+			t.Fatalf("table[%d] = %d is less than table[%d] = %d", i, table[i], i-1, table[i-1])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package colors
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Palettes holds the named palettes that can be chosen instead of picking colors one by one.
+var Palettes = map[string][]Color{
+	"primary": {MustParse(Red), MustParse(Green), MustParse(Blue)},
+	"fire":    {MustParse("FF2000"), MustParse("FF6A00"), MustParse("FFB000"), MustParse("FFE066")},
+	"ocean":   {MustParse("003B73"), MustParse("0074B7"), MustParse("00B4D8"), MustParse("90E0EF")},
+	"forest":  {MustParse("0B3D20"), MustParse("2D6A4F"), MustParse("52B788"), MustParse("B7E4C7")},
+	"sunset":  {MustParse("FF4E50"), MustParse("FC913A"), MustParse("F9D423"), MustParse("C83E8C")},
+	"neon":    {MustParse("FF00FF"), MustParse("00FFFF"), MustParse("39FF14"), MustParse("FFFF00")},
+	"pastel":  {MustParse("FFB3BA"), MustParse("FFDFBA"), MustParse("BAFFC9"), MustParse("BAE1FF")},
+}
+
+// PaletteNames returns the name of every named palette, in alphabetical order.
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Palette returns a copy of the named palette.
+func Palette(name string) ([]Color, error) {
+	palette, exists := Palettes[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown palette %q, expected one of %v", name, PaletteNames())
+	}
+	return append([]Color(nil), palette...), nil
+}
@@ -26,7 +26,7 @@ func GetOutboundIP() net.IP {
 func GenRandomHexCode() string {
 	var code string
 	for i := 0; i < 3; i++ {
-		code += fmt.Sprintf("%02X", rand.Intn(255))
+		code += fmt.Sprintf("%02X", rand.Intn(256))
 	}
 	// fmt.Println(code)
 	return code